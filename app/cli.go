@@ -0,0 +1,469 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	priceDataApi "github.com/erich/api/pricedata/price_data/v1"
+	mongoDriver "go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/erich/pricetracking/config"
+	priceCtl "github.com/erich/pricetracking/controller/price"
+	"github.com/erich/pricetracking/helper/mongo"
+	"github.com/erich/pricetracking/mapper"
+	"github.com/erich/pricetracking/repository/migration"
+)
+
+const CLI_TIME_FORMAT = "2006-01-02T15:04:05Z07:00, 2006-01-02, now or a duration relative to now such as -24h"
+
+// command is an admin subcommand of the service binary
+type command struct {
+	usage string
+	run   func(ctx context.Context, env *commandEnv, args []string) error
+}
+
+// commandEnv holds what a command runs against, the same config and controllers as the server
+type commandEnv struct {
+	cfg         *config.Config
+	tenant      string // set with --tenant, the commands run against the tenant in tenant mode
+	out         io.Writer
+	mongoClient *mongoDriver.Client
+	ctl         priceCtl.PriceDataController
+}
+
+// client connects to mongo on first use, so that flags are checked before
+func (env *commandEnv) client() (*mongoDriver.Client, error) {
+	if env.mongoClient != nil {
+		return env.mongoClient, nil
+	}
+	mongoClient, err := mongo.NewMongoClient(env.cfg)
+	if err != nil {
+		return nil, err
+	}
+	env.mongoClient = mongoClient
+	return mongoClient, nil
+}
+
+// controller initiates the modules on first use. In tenant mode it is the controller of the --tenant tenant,
+// or the controller routing by tenant without one, which loads every tenant and rejects other calls.
+func (env *commandEnv) controller() (priceCtl.PriceDataController, error) {
+	if env.ctl != nil {
+		return env.ctl, nil
+	}
+	// checks the --tenant flag
+	if _, err := env.tenantConfigs(); err != nil {
+		return nil, err
+	}
+	mongoClient, err := env.client()
+	if err != nil {
+		return nil, err
+	}
+
+	mods, ctl, err := InitiateModules(mongoClient, env.cfg)
+	if err != nil {
+		return nil, err
+	}
+	env.ctl = ctl
+	if env.tenant != "" {
+		env.ctl = mods[env.tenant].ctls.priceConroller
+	}
+	return env.ctl, nil
+}
+
+// tenantConfigs returns the config of the --tenant tenant, or of every tenant without one
+func (env *commandEnv) tenantConfigs() (map[string]*config.Config, error) {
+	cfgs := TenantConfigs(env.cfg)
+	if env.tenant == "" {
+		return cfgs, nil
+	}
+	if !env.cfg.Tenant.Enabled() {
+		return nil, errors.New("--tenant needs tenant.Mode")
+	}
+	tenantCfg, ok := cfgs[env.tenant]
+	if !ok {
+		return nil, fmt.Errorf("unknown tenant %q", env.tenant)
+	}
+	return map[string]*config.Config{env.tenant: tenantCfg}, nil
+}
+
+// flagSet returns the flags of a command running against the data, with the --tenant flag
+func (env *commandEnv) flagSet(name string, r *rangeFlags) *flag.FlagSet {
+	fs := newFlagSet(name, r)
+	fs.StringVar(&env.tenant, "tenant", "", "tenant id in tenant mode, every tenant is loaded or migrated without one")
+	return fs
+}
+
+func (env *commandEnv) close() {
+	if env.mongoClient != nil {
+		mongo.Close(context.Background(), env.mongoClient)
+	}
+}
+
+var commands = map[string]command{
+	"load": {
+		usage: "load the new price points from the asset gateway, like the scheduled load",
+		run:   runLoad,
+	},
+	"backfill": {
+		usage: "load a range from the asset gateway: backfill --start 2024-01-01 [--end now] [--asset id]",
+		run:   runBackfill,
+	},
+	"query": {
+		usage: "print windowed prices as csv: query [--window 1h] [--agg avg] [--start -24h] [--end now] [--asset id]",
+		run:   runQuery,
+	},
+	"export": {
+		usage: "export prices to a file: export [--format csv] [--out file] [--window 1h --agg avg | raw if no window]",
+		run:   runExport,
+	},
+	"migrate": {
+		usage: "apply or revert the schema migrations: migrate up | down [--steps 1] [--force] | status",
+		run:   runMigrate,
+	},
+	"check-config": {
+		usage: "validate config/config.yml and exit",
+		run:   runCheckConfig,
+	},
+}
+
+// IsCommand reports whether name is an admin subcommand, the server is started otherwise
+func IsCommand(name string) bool {
+	_, ok := commands[name]
+	return ok || name == "help" || name == "-h" || name == "--help"
+}
+
+// RunCommand runs the admin subcommand args[0] with the flags in args[1:]
+func RunCommand(ctx context.Context, cfg *config.Config, args []string) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		printUsage(os.Stdout)
+		return nil
+	}
+
+	env := &commandEnv{cfg: cfg, out: os.Stdout}
+	defer env.close()
+	return cmd.run(ctx, env, args[1:])
+}
+
+func printUsage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "usage: pricetracking [command] [flags], the server is started without a command")
+	fmt.Fprintln(w, "in tenant mode the commands except check-config take --tenant id")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-13s %s\n", name, commands[name].usage)
+	}
+}
+
+// timeFlag parses RFC3339 timestamps, dates, now and durations relative to now
+type timeFlag struct {
+	t time.Time
+}
+
+func (f *timeFlag) String() string {
+	if f.t.IsZero() {
+		return ""
+	}
+	return f.t.Format(time.RFC3339)
+}
+
+func (f *timeFlag) Set(s string) error {
+	if s == "now" {
+		f.t = time.Now().UTC()
+		return nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		f.t = time.Now().Add(d).UTC()
+		return nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			f.t = t.UTC()
+			return nil
+		}
+	}
+	return fmt.Errorf("expected %s", CLI_TIME_FORMAT)
+}
+
+// rangeFlags are the asset and time range flags shared by the commands
+type rangeFlags struct {
+	asset      string
+	start, end timeFlag
+}
+
+func newFlagSet(name string, r *rangeFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	if r != nil {
+		fs.StringVar(&r.asset, "asset", "", "asset id, defaults to the asset of the asset gateway")
+		fs.Var(&r.start, "start", "start of the range, "+CLI_TIME_FORMAT)
+		fs.Var(&r.end, "end", "end of the range, defaults to now, "+CLI_TIME_FORMAT)
+	}
+	return fs
+}
+
+// protoRange returns the range with the end defaulting to now and the start to one day before the end
+func (r *rangeFlags) protoRange() (*timestamppb.Timestamp, *timestamppb.Timestamp) {
+	end := r.end.t
+	if end.IsZero() {
+		end = time.Now().UTC()
+	}
+	start := r.start.t
+	if start.IsZero() {
+		start = end.Add(-24 * time.Hour)
+	}
+	return timestamppb.New(start), timestamppb.New(end)
+}
+
+func toProtoAggregation(agg string) (priceDataApi.Aggregation, error) {
+	value, ok := priceDataApi.Aggregation_value["AGGREGATION_"+strings.ToUpper(agg)]
+	if !ok || value == 0 {
+		return 0, fmt.Errorf("unknown aggregation %q, expected min, max, avg or sum", agg)
+	}
+	return priceDataApi.Aggregation(value), nil
+}
+
+func runLoad(ctx context.Context, env *commandEnv, args []string) error {
+	if err := env.flagSet("load", nil).Parse(args); err != nil {
+		return err
+	}
+	ctl, err := env.controller()
+	if err != nil {
+		return err
+	}
+	if err := ctl.Load(ctx); err != nil {
+		return err
+	}
+	fmt.Fprintln(env.out, "load completed")
+	return nil
+}
+
+func runBackfill(ctx context.Context, env *commandEnv, args []string) error {
+	var r rangeFlags
+	if err := env.flagSet("backfill", &r).Parse(args); err != nil {
+		return err
+	}
+	if r.start.t.IsZero() {
+		return errors.New("--start is required")
+	}
+	start, end := r.protoRange()
+	ctl, err := env.controller()
+	if err != nil {
+		return err
+	}
+
+	summary, err := ctl.Backfill(ctx, r.asset, start.AsTime(), end.AsTime())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.out, "accepted: %d, rejected: %d\n", summary.Accepted, summary.Rejected)
+	for _, rejection := range summary.Rejections {
+		fmt.Fprintf(env.out, "  point %d: %s\n", rejection.Row, rejection.Reason)
+	}
+	return nil
+}
+
+func runQuery(ctx context.Context, env *commandEnv, args []string) error {
+	var r rangeFlags
+	fs := env.flagSet("query", &r)
+	window := fs.String("window", "1h", "window of the aggregation, e.g. 15m, 1h or 1d")
+	agg := fs.String("agg", "avg", "aggregation: min, max, avg or sum")
+	currency := fs.String("currency", "", "convert into the currency")
+	unit := fs.String("unit", "", "convert into the energy unit")
+	uncorrected := fs.Bool("uncorrected", false, "ignore corrections and deletions")
+	excludeAnomalies := fs.Bool("exclude-anomalies", false, "skip the points flagged as anomalies")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	aggregation, err := toProtoAggregation(*agg)
+	if err != nil {
+		return err
+	}
+	start, end := r.protoRange()
+
+	query, err := mapper.ToQueryModel(&priceDataApi.Query{
+		Start:            start,
+		End:              end,
+		Window:           *window,
+		Aggregation:      aggregation,
+		Asset:            r.asset,
+		Uncorrected:      *uncorrected,
+		ExcludeAnomalies: *excludeAnomalies,
+		Currency:         *currency,
+		Unit:             *unit,
+	})
+	if err != nil {
+		return err
+	}
+	ctl, err := env.controller()
+	if err != nil {
+		return err
+	}
+	entries, priceUnit, err := ctl.Find(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(env.out)
+	header := "value"
+	if u := priceUnit.String(); u != "" {
+		header += " (" + u + ")"
+	}
+	w.Write([]string{"time", header})
+	for _, entry := range entries {
+		w.Write([]string{entry.Time.UTC().Format(time.RFC3339), strconv.FormatFloat(entry.Value, 'f', -1, 64)})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func runExport(ctx context.Context, env *commandEnv, args []string) error {
+	var r rangeFlags
+	fs := env.flagSet("export", &r)
+	format := fs.String("format", "csv", "file format: csv, ndjson or parquet")
+	out := fs.String("out", "", "file to write, defaults to stdout")
+	window := fs.String("window", "", "window of the aggregation, the raw points are exported if empty")
+	agg := fs.String("agg", "avg", "aggregation of the windows: min, max, avg or sum")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	formatValue, ok := priceDataApi.ExportFormat_value["EXPORT_FORMAT_"+strings.ToUpper(*format)]
+	if !ok || formatValue == 0 {
+		return fmt.Errorf("unknown format %q, expected csv, ndjson or parquet", *format)
+	}
+	start, end := r.protoRange()
+
+	protoReq := &priceDataApi.ExportDataRequest{
+		Query:  &priceDataApi.Query{Start: start, End: end, Asset: r.asset, Window: *window},
+		Format: priceDataApi.ExportFormat(formatValue),
+		Raw:    *window == "",
+	}
+	if *window != "" {
+		aggregation, err := toProtoAggregation(*agg)
+		if err != nil {
+			return err
+		}
+		protoReq.Query.Aggregation = aggregation
+	}
+	req, err := mapper.ToExportRequestModel(protoReq)
+	if err != nil {
+		return err
+	}
+	ctl, err := env.controller()
+	if err != nil {
+		return err
+	}
+
+	w := env.out
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := ctl.Export(ctx, req, w); err != nil {
+		return err
+	}
+	if f, ok := w.(*os.File); ok && *out != "" {
+		return f.Sync()
+	}
+	return nil
+}
+
+func runMigrate(ctx context.Context, env *commandEnv, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up | down [--steps 1] [--force] | status")
+	}
+	direction := args[0]
+	fs := env.flagSet("migrate "+direction, nil)
+	steps := fs.Int("steps", 1, "number of migrations to revert")
+	force := fs.Bool("force", false, "revert migrations that drop collections holding documents")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if direction != "up" && direction != "down" && direction != "status" {
+		return errors.New("usage: migrate up | down [--steps 1] [--force] | status")
+	}
+	if *steps < 1 {
+		return errors.New("--steps must be at least 1")
+	}
+	tenantCfgs, err := env.tenantConfigs()
+	if err != nil {
+		return err
+	}
+	mongoClient, err := env.client()
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(tenantCfgs))
+	for id := range tenantCfgs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if id != "" {
+			fmt.Fprintf(env.out, "tenant %s\n", id)
+		}
+		if err := migrate(ctx, env.out, migration.NewMigrator(mongoClient, tenantCfgs[id]), direction, *steps, *force); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrate(ctx context.Context, out io.Writer, migrator *migration.Migrator, direction string, steps int, force bool) error {
+	var done []migration.Migration
+	var err error
+	switch direction {
+	case "up":
+		done, err = migrator.Up(ctx)
+	case "down":
+		done, err = migrator.Down(ctx, steps, force)
+	}
+	for _, m := range done {
+		fmt.Fprintf(out, "%s %d: %s\n", direction, m.Version, m.Description)
+	}
+	if err != nil {
+		return err
+	}
+
+	version, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "schema version %d, latest %d\n", version, migrator.Latest())
+
+	drift, err := migrator.Drift(ctx)
+	if err != nil {
+		return err
+	}
+	for _, d := range drift {
+		fmt.Fprintf(out, "drift: %s\n", d)
+	}
+	return nil
+}
+
+func runCheckConfig(ctx context.Context, env *commandEnv, args []string) error {
+	if err := newFlagSet("check-config", nil).Parse(args); err != nil {
+		return err
+	}
+	// the config is validated when it is parsed, so it is valid once the command runs
+	fmt.Fprintf(env.out, "config is valid, app version %s\n", env.cfg.Server.AppVersion)
+	return nil
+}
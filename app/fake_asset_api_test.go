@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/erich/pricetracking/gateway"
+)
+
+// assetResponse is a scripted response of the fake asset api
+type assetResponse struct {
+	Status int                 // defaults to 200
+	Points []gateway.TempEntry // encoded as the series result unless Body is set
+	Body   string              // raw body, e.g. malformed json
+	Delay  time.Duration       // before the response is written, the request may be cancelled meanwhile
+}
+
+// assetRequest is a request received by the fake asset api, the times in the gateway format
+type assetRequest struct {
+	Start string
+	End   string
+}
+
+// fakeAssetAPI is an httptest stand-in for the edgecom series api. Scripted responses are served in order,
+// afterwards the points of the series within the requested range are returned.
+type fakeAssetAPI struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	series   []gateway.TempEntry
+	script   []assetResponse
+	latency  time.Duration
+	requests []assetRequest
+}
+
+func newFakeAssetAPI(t *testing.T) *fakeAssetAPI {
+	f := &fakeAssetAPI{}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// URL is the series endpoint, see assetClient.ServerAddr
+func (f *fakeAssetAPI) URL() string {
+	return f.server.URL + "/core/asset/series"
+}
+
+// SetSeries replaces the points served without a scripted response
+func (f *fakeAssetAPI) SetSeries(points ...gateway.TempEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.series = points
+}
+
+// Script appends responses served to the next requests, one per request
+func (f *fakeAssetAPI) Script(responses ...assetResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.script = append(f.script, responses...)
+}
+
+// SetLatency delays every response
+func (f *fakeAssetAPI) SetLatency(latency time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency = latency
+}
+
+// Requests returns the received requests in order
+func (f *fakeAssetAPI) Requests() []assetRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]assetRequest(nil), f.requests...)
+}
+
+// Reset forgets the received requests and the remaining scripted responses
+func (f *fakeAssetAPI) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = nil
+	f.script = nil
+}
+
+func (f *fakeAssetAPI) serve(w http.ResponseWriter, r *http.Request) {
+	request := assetRequest{Start: r.URL.Query().Get("start"), End: r.URL.Query().Get("end")}
+	response := f.next(request)
+
+	if response.Delay > 0 {
+		select {
+		case <-time.After(response.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	body := []byte(response.Body)
+	if response.Body == "" {
+		points := response.Points
+		if points == nil {
+			points = []gateway.TempEntry{}
+		}
+		body, _ = json.Marshal(gateway.TempResult{Entries: points})
+	}
+	if response.Status == 0 {
+		response.Status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.Status)
+	w.Write(body)
+}
+
+// next records the request and returns its response
+func (f *fakeAssetAPI) next(request assetRequest) assetResponse {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, request)
+
+	var response assetResponse
+	if len(f.script) > 0 {
+		response, f.script = f.script[0], f.script[1:]
+	} else {
+		response.Points = seriesWithin(f.series, request)
+	}
+	response.Delay += f.latency
+	return response
+}
+
+// seriesWithin returns the points in [start, end), the gateway formats the times without zone in local time
+func seriesWithin(series []gateway.TempEntry, request assetRequest) []gateway.TempEntry {
+	start, errStart := time.ParseInLocation(gateway.DATE_FORMAT, request.Start, time.Local)
+	end, errEnd := time.ParseInLocation(gateway.DATE_FORMAT, request.End, time.Local)
+	if errStart != nil || errEnd != nil {
+		return series
+	}
+
+	points := []gateway.TempEntry{}
+	for _, point := range series {
+		if point.Time >= start.Unix() && point.Time < end.Unix() {
+			points = append(points, point)
+		}
+	}
+	return points
+}
@@ -2,16 +2,19 @@ package server
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net"
-	"os"
-	"os/signal"
-	"syscall"
+	"net/http"
+	"strings"
 	"time"
 
 	priceDataApi "github.com/erich/api/pricedata/price_data/v1"
+	"github.com/erich/pricetracking/helper/auth"
 	"github.com/erich/pricetracking/helper/grpc_env"
+	"github.com/erich/pricetracking/helper/lifecycle"
 	"github.com/erich/pricetracking/helper/metric"
+	"github.com/erich/pricetracking/helper/tlsconfig"
 	grpcMiddleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpcAuth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
 	grpcZap "github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
@@ -19,16 +22,19 @@ import (
 	grpcCtxTags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
 	grpcPrometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"go.uber.org/zap"
 
 	"github.com/erich/pricetracking/config"
 	"github.com/erich/pricetracking/handler"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
-	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
 )
 
@@ -37,6 +43,19 @@ type Server struct {
 	logger      *zap.Logger
 	cfg         *config.Config
 	mongoClient *mongo.Client
+
+	// storage and network of the server, replaced by the integration tests
+	repositories func(cfg *config.Config) (*repos, error)
+	pingStorage  func(ctx context.Context) error
+	listen       func(addr string) (net.Listener, error)
+	// bootstrapped is closed once the bootstrap load finished
+	bootstrapped chan struct{}
+
+	tenants     *tenantResolver
+	tlsReloader *tlsconfig.Reloader
+	health      *healthChecker
+	// delivery intervals of a reloaded config, nil without webhooks
+	alertIntervals chan time.Duration
 }
 
 // NewGrpcServer is to create Server constructor
@@ -45,40 +64,67 @@ func NewGrpcServer(logger *zap.Logger, cfg *config.Config, mongoClient *mongo.Cl
 		logger:      logger,
 		cfg:         cfg,
 		mongoClient: mongoClient,
+		repositories: func(cfg *config.Config) (*repos, error) {
+			return InitiateRepositories(mongoClient, cfg)
+		},
+		pingStorage: func(ctx context.Context) error {
+			return mongoClient.Ping(ctx, readpref.Primary())
+		},
+		listen: func(addr string) (net.Listener, error) {
+			return net.Listen("tcp", addr)
+		},
+		bootstrapped: make(chan struct{}),
 	}
 }
 
-// Run server
-func (s *Server) Run() error {
-
-	//initiate gateway modules
-	gws, err := InitiateGateways(s.cfg)
-	if err != nil {
-		return err
-	}
+// Register initiates the modules and appends the servers and the bootstrap load to the lifecycle,
+// they are started by lc.Run in the order below and stopped in reverse order
+func (s *Server) Register(lc *lifecycle.Manager) error {
 
-	//initiate persistence modules
-	rps, err := InitiateRepositories(s.mongoClient, s.cfg)
+	//initiate gateway, persistence and controller modules of every tenant
+	mods, priceController, err := initiateModules(s.cfg, s.repositories)
 	if err != nil {
 		return err
 	}
 
-	//initiate controller modules
-	ctls := InitiateControllers(s.cfg, rps, gws)
-
 	//initiate GRPC server
 	serverEnv := grpc_env.ServerEnv{
 		Logger: s.logger,
 		Config: s.cfg,
 	}
 
-	server := s.initGrpcServer(serverEnv)
+	//load the TLS certificates, the GRPC port serves plaintext without them
+	var tlsReloader *tlsconfig.Reloader
+	if s.cfg.TLS.CertFile != "" {
+		if tlsReloader, err = tlsconfig.NewReloader(s.cfg.TLS); err != nil {
+			return err
+		}
+		var cancelReload context.CancelFunc
+		lc.Append(lifecycle.Hook{
+			Name: "TLS certificate reloader",
+			Start: func(ctx context.Context) error {
+				var reloadCtx context.Context
+				reloadCtx, cancelReload = context.WithCancel(ctx)
+				go tlsReloader.Run(reloadCtx)
+				return nil
+			},
+			Stop: func(ctx context.Context) error {
+				cancelReload()
+				return nil
+			},
+		})
+	}
+
+	s.tlsReloader = tlsReloader
+	server := s.initGrpcServer(serverEnv, tlsReloader)
 
 	//register server metrics
 	grpcPrometheus.Register(server)
 
-	//register health api
-	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	//register health api, reporting the periodic dependency checks
+	healthChecker := newHealthChecker(s.cfg, s.pingStorage, mods)
+	s.health = healthChecker
+	grpc_health_v1.RegisterHealthServer(server, healthChecker.server)
 
 	//register reflection api for non-production environment, so that GRPC clients can be used.
 	if s.cfg.Server.Mode != "Production" {
@@ -86,18 +132,225 @@ func (s *Server) Run() error {
 	}
 
 	//register User api
-	authServer := handler.NewPriceDataApiServer(s.cfg, ctls.priceConroller)
+	authServer := handler.NewPriceDataApiServer(s.cfg, priceController)
 	priceDataApi.RegisterPriceDataServiceServer(server, authServer)
 
-	return s.startGrpcServer(server, func() {
-		err := ctls.priceConroller.Load(context.TODO())
+	lc.Append(grpcServerHook(lc, server, s.cfg.Server.Port, s.listen))
+
+	//initiate REST/JSON gateway, its connection to the GRPC port is closed after the server stopped
+	if s.cfg.Server.HttpPort != "" {
+		httpServer, gatewayConn, err := s.initHttpGateway(healthChecker, tlsReloader)
 		if err != nil {
-			log.Println("bootstrap data failed")
+			return err
 		}
+		lc.Append(lifecycle.Hook{Name: "REST gateway connection", Stop: func(ctx context.Context) error {
+			return gatewayConn.Close()
+		}})
+		lc.Append(httpServerHook(lc, "REST gateway", httpServer))
+	}
+
+	//initiate gRPC-Web server for browser clients
+	if s.cfg.GrpcWeb.Port != "" {
+		lc.Append(httpServerHook(lc, "gRPC-Web server", s.initGrpcWebServer(server, tlsReloader)))
+	}
+
+	lc.Append(bootstrapLoadHook(func(ctx context.Context) {
+		// every tenant is loaded in tenant mode
+		err := priceController.Load(ctx)
+		if err != nil {
+			log.Printf("bootstrap data failed. %v", err)
+		}
+		// report the bootstrapped data without waiting for the next check
+		healthChecker.check(ctx)
+		close(s.bootstrapped)
+	}))
+
+	//deliver the firing alerts queued by the loads, every tenant in tenant mode
+	if len(s.cfg.Alert.Webhooks) > 0 {
+		s.alertIntervals = make(chan time.Duration, 1)
+		lc.Append(alertDispatcherHook(priceController.DeliverAlerts, s.cfg.Alert.Interval*time.Second, s.alertIntervals))
+	}
+
+	//the health checks stop first, so that probes report NOT_SERVING while draining
+	var cancelHealth context.CancelFunc
+	lc.Append(lifecycle.Hook{
+		Name: "health checker",
+		Start: func(ctx context.Context) error {
+			var healthCtx context.Context
+			healthCtx, cancelHealth = context.WithCancel(ctx)
+			go healthChecker.Run(healthCtx)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			cancelHealth()
+			healthChecker.Shutdown()
+			return nil
+		},
 	})
+	return nil
 }
 
-func (s *Server) initGrpcServer(serverEnv grpc_env.ServerEnv) *grpc.Server {
+// Reload applies the settings of a reloaded config which are safe to change while serving: the tenant rate limits,
+// and the intervals of the TLS reload, the health checks and the alert deliveries
+func (s *Server) Reload(cfg *config.Config) {
+	if s.tenants != nil {
+		s.tenants.SetRateLimit(cfg)
+	}
+	if s.tlsReloader != nil {
+		s.tlsReloader.SetReloadInterval(cfg.TLS.ReloadInterval)
+	}
+	if s.health != nil {
+		s.health.SetInterval(cfg.Health.Interval)
+	}
+	if s.alertIntervals != nil {
+		select {
+		case <-s.alertIntervals:
+		default:
+		}
+		s.alertIntervals <- cfg.Alert.Interval * time.Second
+	}
+}
+
+// grpcServerHook serves on port, in-flight RPCs are drained until the shutdown deadline
+func grpcServerHook(lc *lifecycle.Manager, server *grpc.Server, port string, listen func(addr string) (net.Listener, error)) lifecycle.Hook {
+	return lifecycle.Hook{
+		Name: "GRPC server",
+		Start: func(ctx context.Context) error {
+			listener, err := listen(port)
+			if err != nil {
+				return err
+			}
+			log.Printf("zeonology GRPC Server is listening on port: %v", port)
+			go func() {
+				if err := server.Serve(listener); err != nil {
+					lc.Fail(fmt.Errorf("GRPC server failed: %w", err))
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			drained := make(chan struct{})
+			go func() {
+				server.GracefulStop()
+				close(drained)
+			}()
+			select {
+			case <-drained:
+				return nil
+			case <-ctx.Done():
+				server.Stop()
+				return fmt.Errorf("in-flight RPCs cancelled: %w", ctx.Err())
+			}
+		},
+	}
+}
+
+// httpServerHook serves on the address of httpServer, over TLS with its TLSConfig. In-flight requests are drained
+// until the shutdown deadline.
+func httpServerHook(lc *lifecycle.Manager, name string, httpServer *http.Server) lifecycle.Hook {
+	return lifecycle.Hook{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			listener, err := net.Listen("tcp", httpServer.Addr)
+			if err != nil {
+				return err
+			}
+			log.Printf("zeonology HTTP Server is listening on port: %v", httpServer.Addr)
+			serve := httpServer.Serve
+			if httpServer.TLSConfig != nil {
+				serve = func(l net.Listener) error {
+					return httpServer.ServeTLS(l, "", "")
+				}
+			}
+			go func() {
+				if err := serve(listener); err != nil && err != http.ErrServerClosed {
+					lc.Fail(fmt.Errorf("%s failed: %w", name, err))
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			if err := httpServer.Shutdown(ctx); err != nil {
+				httpServer.Close()
+				return err
+			}
+			return nil
+		},
+	}
+}
+
+// bootstrapLoadHook runs load once the servers are listening. On shutdown the load is awaited until
+// the deadline and cancelled afterwards. The load keeps running when the run context is cancelled, so that
+// it is awaited like the in-flight RPCs.
+func bootstrapLoadHook(load func(ctx context.Context)) lifecycle.Hook {
+	var cancel context.CancelFunc
+	done := make(chan struct{})
+	return lifecycle.Hook{
+		Name: "bootstrap load",
+		Start: func(ctx context.Context) error {
+			var loadCtx context.Context
+			loadCtx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+			go func() {
+				defer close(done)
+				load(loadCtx)
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			defer cancel()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return fmt.Errorf("bootstrap load cancelled: %w", ctx.Err())
+			}
+		},
+	}
+}
+
+// alertDispatcherHook calls deliver every interval, the ticker is restarted with the intervals received. On shutdown the running delivery is cancelled,
+// the queued deliveries are kept and a claimed one is retried once its claim expired.
+func alertDispatcherHook(deliver func(ctx context.Context) error, interval time.Duration, intervals <-chan time.Duration) lifecycle.Hook {
+	var cancel context.CancelFunc
+	done := make(chan struct{})
+	return lifecycle.Hook{
+		Name: "alert dispatcher",
+		Start: func(ctx context.Context) error {
+			var dispatchCtx context.Context
+			dispatchCtx, cancel = context.WithCancel(ctx)
+			go func() {
+				defer close(done)
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-dispatchCtx.Done():
+						return
+					case <-ticker.C:
+					case interval := <-intervals:
+						ticker.Reset(interval)
+						continue
+					}
+					if err := deliver(dispatchCtx); err != nil && dispatchCtx.Err() == nil {
+						log.Printf("alert delivery failed. %v", err)
+					}
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return fmt.Errorf("alert delivery cancelled: %w", ctx.Err())
+			}
+		},
+	}
+}
+
+func (s *Server) initGrpcServer(serverEnv grpc_env.ServerEnv, tlsReloader *tlsconfig.Reloader) *grpc.Server {
 	opts := []grpcZap.Option{
 		grpcZap.WithDecider(func(fullMethodName string, err error) bool {
 			// will not log gRPC calls if it was a call to healthcheck and no error was raised
@@ -109,7 +362,8 @@ func (s *Server) initGrpcServer(serverEnv grpc_env.ServerEnv) *grpc.Server {
 			return true
 		}),
 	}
-	server := grpc.NewServer(grpc.KeepaliveParams(keepalive.ServerParameters{
+	s.tenants = newTenantResolver(s.cfg)
+	serverOpts := []grpc.ServerOption{grpc.KeepaliveParams(keepalive.ServerParameters{
 		MaxConnectionIdle: s.cfg.Server.MaxConnectionIdle * time.Minute,
 		Timeout:           s.cfg.Server.Timeout * time.Second,
 		MaxConnectionAge:  s.cfg.Server.MaxConnectionAge * time.Minute,
@@ -122,7 +376,8 @@ func (s *Server) initGrpcServer(serverEnv grpc_env.ServerEnv) *grpc.Server {
 			grpcPrometheus.StreamServerInterceptor,
 			metric.StreamServerMetricsInterceptor(),
 			grpcZap.StreamServerInterceptor(s.logger, opts...),
-			grpcAuth.StreamServerInterceptor(AuthInterceptorFunc),
+			grpcAuth.StreamServerInterceptor(s.authenticate),
+			s.tenants.StreamServerInterceptor(),
 			grpcRecovery.StreamServerInterceptor(),
 		)),
 		grpc.UnaryInterceptor(grpcMiddleware.ChainUnaryServer(
@@ -132,45 +387,41 @@ func (s *Server) initGrpcServer(serverEnv grpc_env.ServerEnv) *grpc.Server {
 			grpcPrometheus.UnaryServerInterceptor,
 			metric.UnaryServerMetricsInterceptor(),
 			grpcZap.UnaryServerInterceptor(s.logger, opts...),
-			grpcAuth.UnaryServerInterceptor(AuthInterceptorFunc),
+			grpcAuth.UnaryServerInterceptor(s.authenticate),
+			s.tenants.UnaryServerInterceptor(),
 			grpcRecovery.UnaryServerInterceptor(),
 		)),
-	)
-	return server
+	}
+	if tlsReloader != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsReloader.ServerConfig())))
+	}
+	return grpc.NewServer(serverOpts...)
 }
 
-func (s *Server) startGrpcServer(server *grpc.Server, postBootFunc func()) error {
-	listener, err := net.Listen("tcp", s.cfg.Server.Port)
-	if err != nil {
-		return err
+// authenticate is used by grpc_auth.UnaryServerInterceptor for authorization.
+// The identity of a verified client certificate is added to the context, so that handlers can authorize the client
+// with auth.ClientIdentityFromContext. The REST gateway connects with the server certificate and forwards the
+// certificate of its caller, gRPC-Web calls carry the certificate of their HTTPS connection. While client
+// certificates are required, PriceDataService calls without one are rejected, the health and reflection
+// services stay open.
+func (s *Server) authenticate(ctx context.Context) (context.Context, error) {
+	identity, ok := auth.IdentityFromPeer(ctx)
+	if ok && s.tlsReloader != nil && s.tlsReloader.IsOwnCertificate(identity.Certificate) {
+		identity, ok = auth.ForwardedIdentity(ctx)
+	}
+	if ok {
+		grpcCtxTags.Extract(ctx).Set("auth.client", identity.CommonName)
+		return auth.WithClientIdentity(ctx, identity), nil
 	}
-	defer listener.Close()
-
-	go func() {
-		log.Printf("zeonology GRPC Server is listening on port: %v", s.cfg.Server.Port)
-
-		go func() {
-			// Wait a brief moment to ensure the server is ready.
-			time.Sleep(100 * time.Millisecond)
-			postBootFunc()
-		}()
-
-		if err := server.Serve(listener); err != nil {
-			log.Fatalf("Server failed to start. %v", err)
-		}
-	}()
-
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
-	<-quit
-	server.GracefulStop()
-	log.Println("Server Exited Properly")
-	return nil
+	method, _ := grpc.Method(ctx)
+	if s.cfg.TLS.ClientCAFile != "" && s.cfg.TLS.ClientAuth != "request" && isPriceDataMethod(method) {
+		return nil, status.Error(codes.Unauthenticated, "a client certificate is required")
+	}
+	return ctx, nil
 }
 
-// AuthInterceptorFunc is used by grpc_auth.UnaryServerInterceptor for authorization.
-// this method is a dummy method, it is overwritten by method in httpHandler func (u *authService) AuthFuncOverride()
-func AuthInterceptorFunc(ctx context.Context) (context.Context, error) {
-	return ctx, nil
+// isPriceDataMethod reports whether fullMethod is a PriceDataService method
+func isPriceDataMethod(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/"+priceDataApi.PriceDataService_ServiceDesc.ServiceName+"/")
 }
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/erich/pricetracking/helper/tlsconfig"
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"google.golang.org/grpc"
+)
+
+// initGrpcWebServer wraps the GRPC server with gRPC-Web and CORS support,
+// so that browsers can call the api directly without the envoy sidecar. With TLS it serves HTTPS, and the calls
+// carry the client certificate of their connection like native GRPC calls.
+func (s *Server) initGrpcWebServer(server *grpc.Server, tlsReloader *tlsconfig.Reloader) *http.Server {
+	wrapped := grpcweb.WrapServer(server,
+		grpcweb.WithOriginFunc(allowedOriginFunc(s.cfg.GrpcWeb.AllowedOrigins)),
+		grpcweb.WithAllowedRequestHeaders([]string{"*"}),
+	)
+
+	httpServer := &http.Server{
+		Addr:        s.cfg.GrpcWeb.Port,
+		Handler:     wrapped,
+		ReadTimeout: s.cfg.Server.ReadTimeout * time.Second,
+	}
+	if tlsReloader != nil {
+		httpServer.TLSConfig = tlsReloader.HTTPServerConfig()
+	}
+	return httpServer
+}
+
+// allowedOriginFunc checks the request origin against the configured allowed origins
+func allowedOriginFunc(allowedOrigins []string) func(origin string) bool {
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		allowed[strings.TrimSuffix(origin, "/")] = true
+	}
+
+	return func(origin string) bool {
+		return allowed["*"] || allowed[origin]
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	priceDataApi "github.com/erich/api/pricedata/price_data/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/erich/pricetracking/config"
+	"github.com/erich/pricetracking/helper/lifecycle"
+	"github.com/erich/pricetracking/helper/logger"
+)
+
+// harness runs the full server against the fake asset api and the in-memory store,
+// the clients are connected over an in-process bufconn listener
+type harness struct {
+	cfg    *config.Config
+	api    *fakeAssetAPI
+	store  *memoryStore
+	client priceDataApi.PriceDataServiceClient
+}
+
+// harnessOption overrides a setting of config/config.yml, e.g. withConfig("assetClient.CircuitThreshold", 2)
+type harnessOption func(settings map[string]interface{})
+
+func withConfig(key string, value interface{}) harnessOption {
+	return func(settings map[string]interface{}) {
+		settings[key] = value
+	}
+}
+
+// newHarness starts the server and waits for its bootstrap load. The bootstrap load gets an empty series,
+// its request is forgotten so that the tests start with an empty store and a fresh api.
+func newHarness(t *testing.T, opts ...harnessOption) *harness {
+	t.Helper()
+	api := newFakeAssetAPI(t)
+
+	settings := map[string]interface{}{
+		"assetClient.ServerAddr": api.URL(),
+		"server.HttpPort":        "",
+		"grpcWeb.Port":           "",
+		"logger.Level":           "error",
+	}
+	for _, opt := range opts {
+		opt(settings)
+	}
+	cfg := loadTestConfig(t, settings)
+
+	store := newMemoryStore(cfg)
+	listener := bufconn.Listen(1024 * 1024)
+	s := NewGrpcServer(logger.NewLogger(cfg), cfg, nil)
+	s.repositories = func(cfg *config.Config) (*repos, error) {
+		return store.repositories(), nil
+	}
+	s.pingStorage = func(ctx context.Context) error {
+		return nil
+	}
+	s.listen = func(addr string) (net.Listener, error) {
+		return listener, nil
+	}
+
+	lc := lifecycle.NewManager(cfg.Server.ShutdownTimeout * time.Second)
+	if err := s.Register(lc); err != nil {
+		t.Fatalf("register server: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- lc.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-stopped; err != nil {
+			t.Errorf("server shutdown: %v", err)
+		}
+	})
+
+	select {
+	case <-s.bootstrapped:
+	case err := <-stopped:
+		t.Fatalf("server stopped: %v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("bootstrap load did not finish")
+	}
+	api.Reset()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &harness{
+		cfg:    cfg,
+		api:    api,
+		store:  store,
+		client: priceDataApi.NewPriceDataServiceClient(conn),
+	}
+}
+
+// loadTestConfig parses config/config.yml with the settings applied, so that the tests run the shipped defaults
+func loadTestConfig(t *testing.T, settings map[string]interface{}) *config.Config {
+	t.Helper()
+	content, err := os.ReadFile("../config/config.yml")
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	v, err := config.LoadViperConfigFromString(string(content))
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	for key, value := range settings {
+		v.Set(key, value)
+	}
+	cfg, err := config.ParseConfig(v)
+	if err != nil {
+		t.Fatalf("parse config: %v", err)
+	}
+	return cfg
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	priceDataApi "github.com/erich/api/pricedata/price_data/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/erich/pricetracking/config"
+	"github.com/erich/pricetracking/gateway"
+	"github.com/erich/pricetracking/helper/logger"
+	priceRepo "github.com/erich/pricetracking/repository/pricedata"
+)
+
+// services reported by the health server next to the overall status
+const (
+	HEALTH_SERVICE_MONGO         = "mongo"
+	HEALTH_SERVICE_LOAD          = "load"
+	HEALTH_SERVICE_ASSET_GATEWAY = "asset_gateway"
+)
+
+const (
+	defaultHealthInterval = 10 * time.Second
+	defaultHealthTimeout  = 2 * time.Second
+)
+
+type healthCheck struct {
+	service  string
+	required bool // the service is not ready if a required check fails
+	check    func(ctx context.Context) error
+}
+
+// HealthResult is the outcome of the latest run of a check
+type HealthResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// healthChecker runs the dependency checks periodically and reports them through the GRPC health server
+type healthChecker struct {
+	cfg      *config.Config
+	server   *health.Server
+	checks   []healthCheck
+	mu       sync.RWMutex
+	serving  bool
+	draining bool // set by Shutdown, the service stays NOT_SERVING
+	results  map[string]HealthResult
+	// check intervals of a reloaded config, applied by Run
+	intervals chan time.Duration
+}
+
+func newHealthChecker(cfg *config.Config, pingStorage func(ctx context.Context) error, mods map[string]*modules) *healthChecker {
+	hc := &healthChecker{
+		cfg:       cfg,
+		server:    health.NewServer(),
+		results:   map[string]HealthResult{},
+		intervals: make(chan time.Duration, 1),
+	}
+	hc.checks = []healthCheck{
+		{HEALTH_SERVICE_MONGO, true, pingStorage},
+		// the loads of every tenant must be fresh
+		{HEALTH_SERVICE_LOAD, true, func(ctx context.Context) error {
+			return eachTenant(mods, func(m *modules) error {
+				return checkLoadAge(ctx, m.rps.LastUpdateMongoRepo, cfg.Health.MaxLoadAge*time.Minute)
+			})
+		}},
+		// an open circuit alone does not fail readiness, the stored data is still served until it is stale
+		{HEALTH_SERVICE_ASSET_GATEWAY, false, func(ctx context.Context) error {
+			return eachTenant(mods, func(m *modules) error {
+				if state := m.gws.assetGateway.CircuitState(); state == gateway.CircuitState_OPEN {
+					return fmt.Errorf("circuit is %s", state)
+				}
+				return nil
+			})
+		}},
+	}
+	// nothing is ready before the first run
+	hc.server.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	return hc
+}
+
+// eachTenant runs check on the modules of every tenant and joins the failures, prefixed with the tenant id
+func eachTenant(mods map[string]*modules, check func(m *modules) error) error {
+	ids := make([]string, 0, len(mods))
+	for id := range mods {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var errs []error
+	for _, id := range ids {
+		err := check(mods[id])
+		if err != nil && id != "" {
+			err = fmt.Errorf("tenant %s: %w", id, err)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkLoadAge fails if the last successful load is older than maxAge
+func checkLoadAge(ctx context.Context, lastUpdateRepo priceRepo.LastUpdateMongoRepo, maxAge time.Duration) error {
+	if maxAge <= 0 {
+		return nil
+	}
+	last, err := lastUpdateRepo.Get(ctx)
+	if err != nil {
+		return err
+	}
+	if last.IsZero() {
+		return fmt.Errorf("no successful load yet")
+	}
+	if age := time.Since(last); age > maxAge {
+		return fmt.Errorf("last successful load was %s ago", age.Round(time.Second))
+	}
+	return nil
+}
+
+// SetInterval changes the seconds between the checks, e.g. of a reloaded config. It is not safe for concurrent use.
+func (hc *healthChecker) SetInterval(interval time.Duration) {
+	select {
+	case <-hc.intervals:
+	default:
+	}
+	hc.intervals <- interval
+}
+
+func healthInterval(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return defaultHealthInterval
+	}
+	return interval * time.Second
+}
+
+// Run checks the dependencies every interval until ctx is done, a changed interval restarts the ticker
+func (hc *healthChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(healthInterval(hc.cfg.Health.Interval))
+	defer ticker.Stop()
+
+	hc.check(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			hc.check(ctx)
+		case interval := <-hc.intervals:
+			ticker.Reset(healthInterval(interval))
+		}
+	}
+}
+
+// check runs every check once and updates the statuses of the health server
+func (hc *healthChecker) check(ctx context.Context) {
+	timeout := hc.cfg.Health.Timeout * time.Second
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+
+	serving := true
+	results := make(map[string]HealthResult, len(hc.checks))
+	for _, c := range hc.checks {
+		checkCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := c.check(checkCtx)
+		cancel()
+
+		status := grpc_health_v1.HealthCheckResponse_SERVING
+		result := HealthResult{Status: status.String()}
+		if err != nil {
+			status = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+			result = HealthResult{Status: status.String(), Error: err.Error()}
+			serving = serving && !c.required
+		}
+		hc.server.SetServingStatus(c.service, status)
+		results[c.service] = result
+	}
+
+	overall := grpc_health_v1.HealthCheckResponse_SERVING
+	if !serving {
+		overall = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}
+	hc.server.SetServingStatus("", overall)
+	hc.server.SetServingStatus(priceDataApi.PriceDataService_ServiceDesc.ServiceName, overall)
+
+	hc.mu.Lock()
+	previous := hc.results
+	hc.serving, hc.results = serving && !hc.draining, results
+	hc.mu.Unlock()
+
+	for service, result := range results {
+		if previous[service].Status != result.Status {
+			logger.Info("health status changed", zap.String("service", service), zap.String("status", result.Status), zap.String("error", result.Error))
+		}
+	}
+}
+
+// Shutdown reports NOT_SERVING from now on, through the GRPC health server and /readyz,
+// so that the load balancers stop routing to the draining service
+func (hc *healthChecker) Shutdown() {
+	hc.mu.Lock()
+	hc.draining, hc.serving = true, false
+	hc.mu.Unlock()
+	hc.server.Shutdown()
+}
+
+// Status returns whether the service is ready and the results of the latest run
+func (hc *healthChecker) Status() (bool, map[string]HealthResult) {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	return hc.serving, hc.results
+}
+
+// livezHandler reports that the process is able to serve http requests, dependencies are not checked
+func livezHandler(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok"))
+}
+
+// readyzHandler reports the latest health checks, with 503 if a required check failed
+func readyzHandler(hc *healthChecker) func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		serving, results := hc.Status()
+		overall := grpc_health_v1.HealthCheckResponse_SERVING
+		code := http.StatusOK
+		if !serving {
+			overall = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(struct {
+			Status string                  `json:"status"`
+			Checks map[string]HealthResult `json:"checks"`
+		}{overall.String(), results})
+	}
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	priceDataApi "github.com/erich/api/pricedata/price_data/v1"
+	"github.com/erich/pricetracking/helper/app_errors"
+	"github.com/erich/pricetracking/helper/auth"
+	"github.com/erich/pricetracking/helper/tlsconfig"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/utilities"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// queryFields are the Query fields which REST clients may pass as flat query parameters
+var queryFields = map[string]bool{
+	"start":             true,
+	"end":               true,
+	"window":            true,
+	"aggregation":       true,
+	"asset":             true,
+	"uncorrected":       true,
+	"exclude_anomalies": true,
+	"currency":          true,
+	"unit":              true,
+}
+
+// queryPaths are the routes whose request nests the Query message
+var queryPaths = map[string]bool{
+	"/v1/prices": true,
+	"/v1/export": true,
+}
+
+// enumPrefixes are the enum query parameters which may be passed without their proto prefix
+var enumPrefixes = map[string]string{
+	"aggregation": "AGGREGATION_",
+	"format":      "EXPORT_FORMAT_",
+}
+
+var exportContentTypes = map[priceDataApi.ExportFormat]string{
+	priceDataApi.ExportFormat_EXPORT_FORMAT_CSV:     "text/csv",
+	priceDataApi.ExportFormat_EXPORT_FORMAT_NDJSON:  "application/x-ndjson",
+	priceDataApi.ExportFormat_EXPORT_FORMAT_PARQUET: "application/vnd.apache.parquet",
+}
+
+// initHttpGateway creates the REST/JSON gateway. Requests are proxied to the GRPC port,
+// so that they go through the same interceptor chain as native GRPC calls. With TLS the gateway serves HTTPS
+// and forwards the verified client certificate of the request with the call.
+func (s *Server) initHttpGateway(healthChecker *healthChecker, tlsReloader *tlsconfig.Reloader) (*http.Server, *grpc.ClientConn, error) {
+	mux := runtime.NewServeMux(
+		runtime.WithErrorHandler(httpErrorHandler),
+		runtime.WithIncomingHeaderMatcher(tenantHeaderMatcher(s.cfg.Tenant.Header)),
+		runtime.WithMetadata(func(ctx context.Context, r *http.Request) metadata.MD {
+			return auth.ForwardCertificate(r)
+		}),
+	)
+
+	creds := insecure.NewCredentials()
+	if tlsReloader != nil {
+		creds = credentials.NewTLS(tlsReloader.LoopbackConfig())
+	}
+	// the connection is established with the first request, once the GRPC server is listening
+	conn, err := grpc.NewClient("passthrough:///"+grpcEndpoint(s.cfg.Server.Port), grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.registerHttpHandlers(mux, conn, healthChecker); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	httpServer := &http.Server{
+		Addr:         s.cfg.Server.HttpPort,
+		Handler:      flattenQueryParams(mux),
+		ReadTimeout:  s.cfg.Server.ReadTimeout * time.Second,
+		WriteTimeout: s.cfg.Server.WriteTimeout * time.Second,
+	}
+	if tlsReloader != nil {
+		httpServer.TLSConfig = tlsReloader.HTTPServerConfig()
+	}
+	return httpServer, conn, nil
+}
+
+// registerHttpHandlers registers the PriceDataService routes proxied over conn, the export download and the probes
+func (s *Server) registerHttpHandlers(mux *runtime.ServeMux, conn *grpc.ClientConn, healthChecker *healthChecker) error {
+	client := priceDataApi.NewPriceDataServiceClient(conn)
+	// the handlers use the contexts of their requests
+	if err := priceDataApi.RegisterPriceDataServiceHandlerClient(context.TODO(), mux, client); err != nil {
+		return err
+	}
+	if err := mux.HandlePath(http.MethodGet, "/v1/export", exportDownloadHandler(mux, client)); err != nil {
+		return err
+	}
+	// kubernetes probes
+	if err := mux.HandlePath(http.MethodGet, "/livez", livezHandler); err != nil {
+		return err
+	}
+	return mux.HandlePath(http.MethodGet, "/readyz", readyzHandler(healthChecker))
+}
+
+// httpErrorHandler maps GRPC status codes to http status with app_errors.MapGRPCErrCodeToHttpStatus
+func httpErrorHandler(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	httpErr := &runtime.HTTPStatusError{
+		HTTPStatus: app_errors.MapGRPCErrCodeToHttpStatus(status.Code(err)),
+		Err:        err,
+	}
+	runtime.DefaultHTTPErrorHandler(ctx, mux, marshaler, w, r, httpErr)
+}
+
+// exportDownloadHandler serves the ExportData stream as a file download
+func exportDownloadHandler(mux *runtime.ServeMux, client priceDataApi.PriceDataServiceClient) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		_, outbound := runtime.MarshalerForRequest(mux, r)
+
+		ctx, err := runtime.AnnotateContext(r.Context(), mux, r, "/data_api.v1.PriceDataService/ExportData")
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+
+		req := &priceDataApi.ExportDataRequest{}
+		if err := runtime.PopulateQueryParameters(req, r.URL.Query(), &utilities.DoubleArray{}); err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, status.Error(codes.InvalidArgument, err.Error()))
+			return
+		}
+
+		stream, err := client.ExportData(ctx, req)
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+
+		// errors of the first chunk are still reported with a http status
+		resp, err := stream.Recv()
+		if err != nil && err != io.EOF {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+
+		// exports may take longer than the server write timeout
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+			log.Printf("export download cannot clear write deadline. %v", err)
+		}
+
+		ext := strings.ToLower(strings.TrimPrefix(req.Format.String(), "EXPORT_FORMAT_"))
+		w.Header().Set("Content-Type", exportContentTypes[req.Format])
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="prices.%s"`, ext))
+		for err == nil {
+			if _, err = w.Write(resp.Chunk); err != nil {
+				return
+			}
+			resp, err = stream.Recv()
+		}
+		if err != io.EOF {
+			// the status line is already sent, so the download is aborted instead
+			log.Printf("export download failed. %v", err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// flattenQueryParams allows `?start=&end=&window=&aggregation=avg` instead of the nested
+// `?query.start=&query.aggregation=AGGREGATION_AVG` form expected by grpc-gateway.
+func flattenQueryParams(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.RawQuery != "" {
+			values := r.URL.Query()
+			nested := queryPaths[r.URL.Path]
+			for key, vals := range values {
+				prefix, isEnum := enumPrefixes[key]
+				isQueryField := nested && queryFields[key]
+				if !isQueryField && !isEnum {
+					continue
+				}
+				delete(values, key)
+				if isQueryField {
+					key = "query." + key
+				}
+				for _, v := range vals {
+					if isEnum {
+						v = toEnumName(prefix, v)
+					}
+					values.Add(key, v)
+				}
+			}
+			r.URL.RawQuery = values.Encode()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// toEnumName turns a short enum value like "avg" into its proto name "AGGREGATION_AVG"
+func toEnumName(prefix string, v string) string {
+	if _, err := strconv.Atoi(v); err == nil {
+		return v
+	}
+	v = strings.ToUpper(v)
+	if !strings.HasPrefix(v, prefix) {
+		v = prefix + v
+	}
+	return v
+}
+
+// grpcEndpoint turns a listen address like ":5051" into a dialable address
+func grpcEndpoint(port string) string {
+	if strings.HasPrefix(port, ":") {
+		return "localhost" + port
+	}
+	return port
+}
+
+// tenantHeaderMatcher forwards the tenant header as GRPC metadata, next to the headers forwarded by default.
+// A forwarded client certificate is only set by the gateway, never taken from the request headers.
+func tenantHeaderMatcher(tenantHeader string) runtime.HeaderMatcherFunc {
+	return func(key string) (string, bool) {
+		if tenantHeader != "" && strings.EqualFold(key, tenantHeader) {
+			return strings.ToLower(tenantHeader), true
+		}
+		name, ok := runtime.DefaultHeaderMatcher(key)
+		if strings.EqualFold(name, auth.ForwardedCertificateKey) {
+			return "", false
+		}
+		return name, ok
+	}
+}
@@ -1,15 +1,24 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
 	"github.com/erich/pricetracking/config"
 	priceCtl "github.com/erich/pricetracking/controller/price"
 	"github.com/erich/pricetracking/gateway"
+	"github.com/erich/pricetracking/model"
+	"github.com/erich/pricetracking/repository/migration"
 	priceRepo "github.com/erich/pricetracking/repository/pricedata"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type gateways struct {
-	assetGateway gateway.AssetClient
+	assetGateway   gateway.AssetClient
+	webhookGateway gateway.WebhookClient
 }
 
 func InitiateGateways(cfg *config.Config) (*gateways, error) {
@@ -17,28 +26,150 @@ func InitiateGateways(cfg *config.Config) (*gateways, error) {
 	if err != nil {
 		return nil, err
 	}
+	webhookGateway, err := gateway.NewWebhookClient(cfg)
+	if err != nil {
+		return nil, err
+	}
 	return &gateways{
-		assetGateway: assetGateway,
+		assetGateway:   assetGateway,
+		webhookGateway: webhookGateway,
 	}, nil
 }
 
+// TenantConfigs returns the config of every tenant by id, or the config as tenant "" without tenant mode
+func TenantConfigs(cfg *config.Config) map[string]*config.Config {
+	if !cfg.Tenant.Enabled() {
+		return map[string]*config.Config{"": cfg}
+	}
+	cfgs := make(map[string]*config.Config, len(cfg.Tenant.Tenants))
+	for _, tenant := range cfg.Tenant.Tenants {
+		cfgs[tenant.ID] = cfg.ForTenant(tenant)
+	}
+	return cfgs
+}
+
+// InitiateSchema applies the pending schema migrations of every tenant with mongo.AutoMigrate,
+// and fails on pending migrations otherwise
+func InitiateSchema(ctx context.Context, cfg *config.Config, mongoClient *mongo.Client) error {
+	for id, tenantCfg := range TenantConfigs(cfg) {
+		if err := initiateTenantSchema(ctx, tenantCfg, mongoClient); err != nil {
+			if id != "" {
+				return fmt.Errorf("tenant %s: %w", id, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func initiateTenantSchema(ctx context.Context, cfg *config.Config, mongoClient *mongo.Client) error {
+	migrator := migration.NewMigrator(mongoClient, cfg)
+	if err := migrateSchema(ctx, cfg, migrator); err != nil {
+		return err
+	}
+
+	// the collection options apply on creation only, changed settings need manual steps
+	drift, err := migrator.Drift(ctx)
+	if err != nil {
+		return err
+	}
+	for _, d := range drift {
+		log.Printf("schema drift in %s: %s", cfg.Mongo.Database, d)
+	}
+	return nil
+}
+
+func migrateSchema(ctx context.Context, cfg *config.Config, migrator *migration.Migrator) error {
+	if cfg.Mongo.AutoMigrate {
+		// replicas starting together wait for the one holding the lock
+		for {
+			_, err := migrator.Up(ctx)
+			if !errors.Is(err, migration.ErrLocked) {
+				return err
+			}
+			log.Printf("waiting for the schema migrations of another instance. %v", err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(2 * time.Second):
+			}
+		}
+	}
+
+	pending, err := migrator.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d schema migrations are pending, run `make migrate_up` or enable mongo.AutoMigrate", len(pending))
+	}
+	log.Printf("schema version %d of %s is up to date", migrator.Latest(), cfg.Mongo.Database)
+	return nil
+}
+
+// CheckServerVersion fails if the mongo server does not support the configured features
+func CheckServerVersion(ctx context.Context, cfg *config.Config, mongoClient *mongo.Client) error {
+	if model.DedupPolicy(cfg.Import.DedupPolicy) == model.DedupPolicy_OVERWRITE {
+		if err := priceRepo.CheckReplaceSupported(ctx, mongoClient); err != nil {
+			return fmt.Errorf("import.DedupPolicy overwrite: %w", err)
+		}
+	}
+	return nil
+}
+
 type repos struct {
-	PriceDataMongoRepo  priceRepo.PriceDataMongoRepo
-	LastUpdateMongoRepo priceRepo.LastUpdateMongoRepo
+	PriceDataMongoRepo   priceRepo.PriceDataMongoRepo
+	LastUpdateMongoRepo  priceRepo.LastUpdateMongoRepo
+	CorrectionMongoRepo  priceRepo.CorrectionMongoRepo
+	QuarantineMongoRepo  priceRepo.QuarantineMongoRepo
+	AnomalyMongoRepo     priceRepo.AnomalyMongoRepo
+	AlertRuleMongoRepo   priceRepo.AlertRuleMongoRepo
+	AlertOutboxMongoRepo priceRepo.AlertOutboxMongoRepo
+	MetadataMongoRepo    priceRepo.AssetMetadataMongoRepo
 }
 
 func InitiateRepositories(mongoClient *mongo.Client, cfg *config.Config) (*repos, error) {
-	priceDataMongoRepo, err := priceRepo.NewPriceDataMongoRepo(mongoClient)
+	priceDataMongoRepo, err := priceRepo.NewPriceDataMongoRepo(mongoClient, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	lastUpdateMongoRepo, err := priceRepo.NewLastRetrivalMongoRepo(mongoClient, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	correctionMongoRepo, err := priceRepo.NewCorrectionMongoRepo(mongoClient, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	quarantineMongoRepo, err := priceRepo.NewQuarantineMongoRepo(mongoClient, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	anomalyMongoRepo, err := priceRepo.NewAnomalyMongoRepo(mongoClient, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	lastUpdateMongoRepo, err := priceRepo.NewLastRetrivalMongoRepo(mongoClient)
+	alertRuleMongoRepo, err := priceRepo.NewAlertRuleMongoRepo(mongoClient, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	return &repos{priceDataMongoRepo, lastUpdateMongoRepo}, nil
+	alertOutboxMongoRepo, err := priceRepo.NewAlertOutboxMongoRepo(mongoClient, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataMongoRepo, err := priceRepo.NewAssetMetadataMongoRepo(mongoClient, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &repos{priceDataMongoRepo, lastUpdateMongoRepo, correctionMongoRepo, quarantineMongoRepo, anomalyMongoRepo, alertRuleMongoRepo, alertOutboxMongoRepo, metadataMongoRepo}, nil
 }
 
 type controllers struct {
@@ -49,8 +180,55 @@ func InitiateControllers(cfg *config.Config, rps *repos, gws *gateways) *control
 	priceConroller := priceCtl.NewPriceDataController(cfg,
 		rps.PriceDataMongoRepo,
 		rps.LastUpdateMongoRepo,
+		rps.CorrectionMongoRepo,
+		rps.QuarantineMongoRepo,
+		rps.AnomalyMongoRepo,
+		rps.AlertRuleMongoRepo,
+		rps.AlertOutboxMongoRepo,
+		rps.MetadataMongoRepo,
 		gws.assetGateway,
+		gws.webhookGateway,
 	)
 
 	return &controllers{priceConroller}
 }
+
+// modules are the gateways, repositories and controllers of a tenant
+type modules struct {
+	rps  *repos
+	gws  *gateways
+	ctls *controllers
+}
+
+// InitiateModules initiates the modules of every tenant, and returns them by tenant id with the controller
+// routing to the tenant of the request. Without tenant mode the modules are keyed "" and the controller is theirs.
+func InitiateModules(mongoClient *mongo.Client, cfg *config.Config) (map[string]*modules, priceCtl.PriceDataController, error) {
+	return initiateModules(cfg, func(tenantCfg *config.Config) (*repos, error) {
+		return InitiateRepositories(mongoClient, tenantCfg)
+	})
+}
+
+// initiateModules initiates the modules with the repositories of newRepositories
+func initiateModules(cfg *config.Config, newRepositories func(cfg *config.Config) (*repos, error)) (map[string]*modules, priceCtl.PriceDataController, error) {
+	mods := map[string]*modules{}
+	for id, tenantCfg := range TenantConfigs(cfg) {
+		gws, err := InitiateGateways(tenantCfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		rps, err := newRepositories(tenantCfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		mods[id] = &modules{rps: rps, gws: gws, ctls: InitiateControllers(tenantCfg, rps, gws)}
+	}
+
+	if !cfg.Tenant.Enabled() {
+		return mods, mods[""].ctls.priceConroller, nil
+	}
+	tenantCtls := make(map[string]priceCtl.PriceDataController, len(mods))
+	for id, m := range mods {
+		tenantCtls[id] = m.ctls.priceConroller
+	}
+	return mods, priceCtl.NewTenantController(tenantCtls), nil
+}
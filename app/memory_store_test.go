@@ -0,0 +1,662 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/erich/pricetracking/config"
+	"github.com/erich/pricetracking/helper/app_errors"
+	"github.com/erich/pricetracking/model"
+	priceRepo "github.com/erich/pricetracking/repository/pricedata"
+)
+
+// memoryStore is an in-process stand-in for the mongo collections of a tenant. It implements the repositories
+// with the semantics of the mongo queries, e.g. the $dateTrunc windows and the correction lookups.
+type memoryStore struct {
+	mu sync.Mutex
+	// points stored before the asset field was introduced belong to the default asset
+	defaultAsset string
+
+	points      []model.Entry
+	lastUpdate  time.Time
+	corrections map[correctionKey]model.Correction
+	audit       []model.AuditEntry
+	quarantine  []model.QuarantinedEntry
+	anomalies   []model.Anomaly
+	alertRules  []model.AlertRule
+	outbox      []model.AlertDelivery
+	metadata    map[string]model.AssetMetadata
+}
+
+type correctionKey struct {
+	asset string
+	time  int64
+}
+
+func newMemoryStore(cfg *config.Config) *memoryStore {
+	return &memoryStore{
+		defaultAsset: cfg.AssetClient.Asset,
+		corrections:  map[correctionKey]model.Correction{},
+		metadata:     map[string]model.AssetMetadata{},
+	}
+}
+
+// repositories returns the repositories backed by the store
+func (s *memoryStore) repositories() *repos {
+	return &repos{
+		PriceDataMongoRepo:   &memoryPriceRepo{s},
+		LastUpdateMongoRepo:  &memoryLastUpdateRepo{s},
+		CorrectionMongoRepo:  &memoryCorrectionRepo{s},
+		QuarantineMongoRepo:  &memoryQuarantineRepo{s},
+		AnomalyMongoRepo:     &memoryAnomalyRepo{s},
+		AlertRuleMongoRepo:   &memoryAlertRuleRepo{s},
+		AlertOutboxMongoRepo: &memoryAlertOutboxRepo{s},
+		MetadataMongoRepo:    &memoryMetadataRepo{s},
+	}
+}
+
+// Points returns a copy of the stored price points
+func (s *memoryStore) Points() []model.Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]model.Entry(nil), s.points...)
+}
+
+// Quarantined returns a copy of the quarantined price points
+func (s *memoryStore) Quarantined() []model.QuarantinedEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]model.QuarantinedEntry(nil), s.quarantine...)
+}
+
+// Outbox returns a copy of the pending alert deliveries
+func (s *memoryStore) Outbox() []model.AlertDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]model.AlertDelivery(nil), s.outbox...)
+}
+
+// LastUpdate returns the time of the last load with price points
+func (s *memoryStore) LastUpdate() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastUpdate
+}
+
+func (s *memoryStore) matchesAsset(entry model.Entry, asset string) bool {
+	return entry.Asset == asset || (entry.Asset == "" && asset == s.defaultAsset)
+}
+
+type memoryPriceRepo struct{ s *memoryStore }
+
+// Create implements priceRepo.PriceDataMongoRepo.
+func (r *memoryPriceRepo) Create(ctx context.Context, pg []model.Entry) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, entry := range pg {
+		entry.Time = entry.Time.UTC()
+		r.s.points = append(r.s.points, entry)
+	}
+	return nil
+}
+
+// Find implements priceRepo.PriceDataMongoRepo.
+func (r *memoryPriceRepo) Find(ctx context.Context, query model.Query) ([]model.Entry, error) {
+	var entries []model.Entry
+	err := r.iterate(query, func(entry model.Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// dateTruncOrigin is the reference of the $dateTrunc bins
+var dateTruncOrigin = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// iterate calls fn for every aggregated window of the query like the aggregation pipeline
+func (r *memoryPriceRepo) iterate(query model.Query, fn func(model.Entry) error) error {
+	window := query.WindowDuration()
+	if window <= 0 {
+		return fmt.Errorf("failed to aggregate data: invalid window %s", query.Window())
+	}
+
+	var windows []model.Entry
+	var values [][]float64
+	for _, point := range r.points(query) {
+		interval := dateTruncOrigin.Add(point.Time.Sub(dateTruncOrigin) / window * window)
+		if point.Time.Before(dateTruncOrigin) && !interval.Equal(point.Time) {
+			interval = interval.Add(-window)
+		}
+		if len(windows) == 0 || !windows[len(windows)-1].Time.Equal(interval) {
+			windows = append(windows, model.Entry{Time: interval})
+			values = append(values, nil)
+		}
+		values[len(values)-1] = append(values[len(values)-1], point.Value)
+	}
+
+	for i, entry := range windows {
+		value, err := aggregate(query.Aggregation, values[i])
+		if err != nil {
+			return err
+		}
+		entry.Value = value
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func aggregate(aggregation model.Aggregation, values []float64) (float64, error) {
+	result := values[0]
+	switch aggregation {
+	case model.Aggregation_MIN:
+		for _, v := range values[1:] {
+			result = math.Min(result, v)
+		}
+	case model.Aggregation_MAX:
+		for _, v := range values[1:] {
+			result = math.Max(result, v)
+		}
+	case model.Aggregation_SUM, model.Aggregation_AVG:
+		for _, v := range values[1:] {
+			result += v
+		}
+		if aggregation == model.Aggregation_AVG {
+			result /= float64(len(values))
+		}
+	default:
+		return 0, fmt.Errorf("failed to aggregate data: unknown aggregation %q", aggregation)
+	}
+	return result, nil
+}
+
+// IterateRaw implements priceRepo.PriceDataMongoRepo.
+func (r *memoryPriceRepo) IterateRaw(ctx context.Context, query model.Query, fn func(model.Entry) error) error {
+	for _, point := range r.points(query) {
+		if err := fn(point); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// points returns the price points of the query in time order, see priceDataMongoRepo.pointsPipeline
+func (r *memoryPriceRepo) points(query model.Query) []model.Entry {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var points []model.Entry
+	for _, point := range r.s.points {
+		if !r.s.matchesAsset(point, query.Asset) || point.Time.Before(query.StartTime) || !point.Time.Before(query.EndTime) {
+			continue
+		}
+		if query.ExcludeAnomalies && r.flagged(query.Asset, point.Time) {
+			continue
+		}
+		if !query.Uncorrected {
+			if correction, ok := r.s.corrections[correctionKey{query.Asset, point.Time.UnixNano()}]; ok {
+				if correction.Deleted {
+					continue
+				}
+				point.Value = correction.Value
+			}
+		}
+		points = append(points, point)
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+	return points
+}
+
+func (r *memoryPriceRepo) flagged(asset string, t time.Time) bool {
+	for _, anomaly := range r.s.anomalies {
+		if anomaly.Asset == asset && anomaly.Time.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindPoint implements priceRepo.PriceDataMongoRepo.
+func (r *memoryPriceRepo) FindPoint(ctx context.Context, asset string, t time.Time) (*model.Entry, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, point := range r.s.points {
+		if r.s.matchesAsset(point, asset) && point.Time.Equal(t) {
+			return &point, nil
+		}
+	}
+	return nil, nil
+}
+
+// FindTimes implements priceRepo.PriceDataMongoRepo.
+func (r *memoryPriceRepo) FindTimes(ctx context.Context, asset string, times []time.Time) ([]time.Time, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	var found []time.Time
+	for _, point := range r.s.points {
+		if r.s.matchesAsset(point, asset) && containsTime(times, point.Time) {
+			found = append(found, point.Time)
+		}
+	}
+	return found, nil
+}
+
+// Replace implements priceRepo.PriceDataMongoRepo.
+func (r *memoryPriceRepo) Replace(ctx context.Context, asset string, entries []model.Entry) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	times := make([]time.Time, len(entries))
+	for i, entry := range entries {
+		times[i] = entry.Time
+	}
+	kept := r.s.points[:0]
+	for _, point := range r.s.points {
+		if !r.s.matchesAsset(point, asset) || !containsTime(times, point.Time) {
+			kept = append(kept, point)
+		}
+	}
+	r.s.points = append(kept, entries...)
+	return nil
+}
+
+func containsTime(times []time.Time, t time.Time) bool {
+	for _, v := range times {
+		if v.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Summarize implements priceRepo.PriceDataMongoRepo.
+func (r *memoryPriceRepo) Summarize(ctx context.Context, query model.Query) (model.Summary, error) {
+	points := r.points(query)
+	if len(points) == 0 {
+		return model.Summary{}, nil
+	}
+
+	summary := model.Summary{
+		Count: int64(len(points)),
+		Min:   points[0],
+		Max:   points[0],
+		First: points[0],
+		Last:  points[len(points)-1],
+	}
+	var sum float64
+	for _, point := range points {
+		sum += point.Value
+		// $min and $max compare the price before the timestamp
+		if point.Value < summary.Min.Value {
+			summary.Min = point
+		}
+		if point.Value >= summary.Max.Value {
+			summary.Max = point
+		}
+	}
+	summary.Mean = sum / float64(len(points))
+	var squares float64
+	for _, point := range points {
+		squares += (point.Value - summary.Mean) * (point.Value - summary.Mean)
+	}
+	summary.StdDev = math.Sqrt(squares / float64(len(points)))
+	summary.Change = summary.Last.Value - summary.First.Value
+	if summary.First.Value != 0 {
+		summary.ChangePercent = summary.Change / math.Abs(summary.First.Value) * 100
+	}
+	for _, entry := range []*model.Entry{&summary.Min, &summary.Max, &summary.First, &summary.Last} {
+		*entry = model.Entry{Time: entry.Time, Value: entry.Value}
+	}
+	return summary, nil
+}
+
+type memoryLastUpdateRepo struct{ s *memoryStore }
+
+// Update implements priceRepo.LastUpdateMongoRepo.
+func (r *memoryLastUpdateRepo) Update(ctx context.Context, last time.Time) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.lastUpdate = last
+	return nil
+}
+
+// Get implements priceRepo.LastUpdateMongoRepo.
+func (r *memoryLastUpdateRepo) Get(ctx context.Context) (time.Time, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.lastUpdate, nil
+}
+
+type memoryCorrectionRepo struct{ s *memoryStore }
+
+// Get implements priceRepo.CorrectionMongoRepo.
+func (r *memoryCorrectionRepo) Get(ctx context.Context, asset string, t time.Time) (*model.Correction, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	correction, ok := r.s.corrections[correctionKey{asset, t.UnixNano()}]
+	if !ok {
+		return nil, nil
+	}
+	return &correction, nil
+}
+
+// Save implements priceRepo.CorrectionMongoRepo.
+func (r *memoryCorrectionRepo) Save(ctx context.Context, correction model.Correction, audit model.AuditEntry, pointValue float64) (model.AuditEntry, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	key := correctionKey{correction.Asset, correction.Time.UnixNano()}
+	var previous *model.Correction
+	if replaced, ok := r.s.corrections[key]; ok {
+		previous = &replaced
+	}
+	audit.PreviousValue = model.CorrectedValue(pointValue, previous)
+	r.s.corrections[key] = correction
+	r.s.audit = append(r.s.audit, audit)
+	return audit, nil
+}
+
+type memoryQuarantineRepo struct{ s *memoryStore }
+
+// Create implements priceRepo.QuarantineMongoRepo.
+func (r *memoryQuarantineRepo) Create(ctx context.Context, entries []model.QuarantinedEntry) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, entry := range entries {
+		// object ids increase, so the hex ids keep the insertion order
+		entry.ID = primitive.NewObjectID().Hex()
+		r.s.quarantine = append(r.s.quarantine, entry)
+	}
+	return nil
+}
+
+// List implements priceRepo.QuarantineMongoRepo.
+func (r *memoryQuarantineRepo) List(ctx context.Context, filter model.QuarantineFilter) ([]model.QuarantinedEntry, error) {
+	if filter.PageToken != "" {
+		if _, err := primitive.ObjectIDFromHex(filter.PageToken); err != nil {
+			return nil, fmt.Errorf("%w: invalid page token", app_errors.ErrInvalidRequest)
+		}
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	var entries []model.QuarantinedEntry
+	for _, entry := range r.s.quarantine {
+		if (filter.Asset != "" && entry.Asset != filter.Asset) || (filter.Rule != "" && entry.Rule != filter.Rule) ||
+			entry.ID <= filter.PageToken {
+			continue
+		}
+		if filter.PageSize > 0 && len(entries) == filter.PageSize {
+			break
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Get implements priceRepo.QuarantineMongoRepo.
+func (r *memoryQuarantineRepo) Get(ctx context.Context, ids []string) ([]model.QuarantinedEntry, error) {
+	if err := validObjectIDs(ids); err != nil {
+		return nil, err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	var entries []model.QuarantinedEntry
+	for _, entry := range r.s.quarantine {
+		if containsID(ids, entry.ID) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// MarkReleasing implements priceRepo.QuarantineMongoRepo.
+func (r *memoryQuarantineRepo) MarkReleasing(ctx context.Context, ids []string) error {
+	if err := validObjectIDs(ids); err != nil {
+		return err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for i, entry := range r.s.quarantine {
+		if containsID(ids, entry.ID) {
+			r.s.quarantine[i].Releasing = true
+		}
+	}
+	return nil
+}
+
+// Delete implements priceRepo.QuarantineMongoRepo.
+func (r *memoryQuarantineRepo) Delete(ctx context.Context, ids []string) error {
+	if err := validObjectIDs(ids); err != nil {
+		return err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	kept := r.s.quarantine[:0]
+	for _, entry := range r.s.quarantine {
+		if !containsID(ids, entry.ID) {
+			kept = append(kept, entry)
+		}
+	}
+	r.s.quarantine = kept
+	return nil
+}
+
+func validObjectIDs(ids []string) error {
+	for _, id := range ids {
+		if _, err := primitive.ObjectIDFromHex(id); err != nil {
+			return fmt.Errorf("%w: invalid id %q", app_errors.ErrInvalidRequest, id)
+		}
+	}
+	return nil
+}
+
+func containsID(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+type memoryAnomalyRepo struct{ s *memoryStore }
+
+// Save implements priceRepo.AnomalyMongoRepo.
+func (r *memoryAnomalyRepo) Save(ctx context.Context, anomalies []model.Anomaly) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, anomaly := range anomalies {
+		replaced := false
+		for i, stored := range r.s.anomalies {
+			if stored.Asset == anomaly.Asset && stored.Time.Equal(anomaly.Time) && stored.Rule == anomaly.Rule {
+				r.s.anomalies[i], replaced = anomaly, true
+			}
+		}
+		if !replaced {
+			r.s.anomalies = append(r.s.anomalies, anomaly)
+		}
+	}
+	return nil
+}
+
+// List implements priceRepo.AnomalyMongoRepo.
+func (r *memoryAnomalyRepo) List(ctx context.Context, filter model.AnomalyFilter) ([]model.Anomaly, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	var anomalies []model.Anomaly
+	for _, anomaly := range r.s.anomalies {
+		if anomaly.Asset != filter.Asset || (filter.Rule != "" && anomaly.Rule != filter.Rule) ||
+			(!filter.StartTime.IsZero() && anomaly.Time.Before(filter.StartTime)) ||
+			(!filter.EndTime.IsZero() && !anomaly.Time.Before(filter.EndTime)) {
+			continue
+		}
+		anomalies = append(anomalies, anomaly)
+	}
+	sort.SliceStable(anomalies, func(i, j int) bool {
+		if !anomalies[i].Time.Equal(anomalies[j].Time) {
+			return anomalies[i].Time.Before(anomalies[j].Time)
+		}
+		return anomalies[i].Rule < anomalies[j].Rule
+	})
+	return anomalies, nil
+}
+
+type memoryAlertRuleRepo struct{ s *memoryStore }
+
+// Create implements priceRepo.AlertRuleMongoRepo.
+func (r *memoryAlertRuleRepo) Create(ctx context.Context, rule model.AlertRule) (model.AlertRule, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	rule.ID = primitive.NewObjectID().Hex()
+	r.s.alertRules = append(r.s.alertRules, rule)
+	return rule, nil
+}
+
+// List implements priceRepo.AlertRuleMongoRepo.
+func (r *memoryAlertRuleRepo) List(ctx context.Context, asset string) ([]model.AlertRule, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	var rules []model.AlertRule
+	for _, rule := range r.s.alertRules {
+		if asset == "" || rule.Asset == asset {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// Delete implements priceRepo.AlertRuleMongoRepo.
+func (r *memoryAlertRuleRepo) Delete(ctx context.Context, id string) error {
+	if err := validObjectIDs([]string{id}); err != nil {
+		return err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for i, rule := range r.s.alertRules {
+		if rule.ID == id {
+			r.s.alertRules = append(r.s.alertRules[:i], r.s.alertRules[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: alert rule %s", app_errors.ErrNotFound, id)
+}
+
+// SetFiring implements priceRepo.AlertRuleMongoRepo.
+func (r *memoryAlertRuleRepo) SetFiring(ctx context.Context, id string, firing bool, at time.Time) (bool, error) {
+	if err := validObjectIDs([]string{id}); err != nil {
+		return false, err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for i, rule := range r.s.alertRules {
+		if rule.ID != id || rule.Firing == firing {
+			continue
+		}
+		r.s.alertRules[i].Firing = firing
+		if firing {
+			r.s.alertRules[i].FiredAt = at
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+type memoryAlertOutboxRepo struct{ s *memoryStore }
+
+// Enqueue implements priceRepo.AlertOutboxMongoRepo.
+func (r *memoryAlertOutboxRepo) Enqueue(ctx context.Context, deliveries []model.AlertDelivery) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, delivery := range deliveries {
+		delivery.ID = primitive.NewObjectID().Hex()
+		r.s.outbox = append(r.s.outbox, delivery)
+	}
+	return nil
+}
+
+// Claim implements priceRepo.AlertOutboxMongoRepo.
+func (r *memoryAlertOutboxRepo) Claim(ctx context.Context, now time.Time, lease time.Duration) (model.AlertDelivery, bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	due := -1
+	for i, delivery := range r.s.outbox {
+		if !delivery.NextAttempt.After(now) && (due < 0 || delivery.NextAttempt.Before(r.s.outbox[due].NextAttempt)) {
+			due = i
+		}
+	}
+	if due < 0 {
+		return model.AlertDelivery{}, false, nil
+	}
+	r.s.outbox[due].NextAttempt = now.Add(lease)
+	return r.s.outbox[due], true, nil
+}
+
+// Retry implements priceRepo.AlertOutboxMongoRepo.
+func (r *memoryAlertOutboxRepo) Retry(ctx context.Context, id string, next time.Time, lastError string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for i, delivery := range r.s.outbox {
+		if delivery.ID == id {
+			r.s.outbox[i].Attempts++
+			r.s.outbox[i].NextAttempt = next
+			r.s.outbox[i].LastError = lastError
+		}
+	}
+	return nil
+}
+
+// Delete implements priceRepo.AlertOutboxMongoRepo.
+func (r *memoryAlertOutboxRepo) Delete(ctx context.Context, id string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for i, delivery := range r.s.outbox {
+		if delivery.ID == id {
+			r.s.outbox = append(r.s.outbox[:i], r.s.outbox[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+type memoryMetadataRepo struct{ s *memoryStore }
+
+// Get implements priceRepo.AssetMetadataMongoRepo.
+func (r *memoryMetadataRepo) Get(ctx context.Context, asset string) (*model.AssetMetadata, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	metadata, ok := r.s.metadata[asset]
+	if !ok {
+		return nil, nil
+	}
+	return &metadata, nil
+}
+
+// Save implements priceRepo.AssetMetadataMongoRepo.
+func (r *memoryMetadataRepo) Save(ctx context.Context, metadata model.AssetMetadata) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.metadata[metadata.Asset] = metadata
+	return nil
+}
+
+var (
+	_ priceRepo.PriceDataMongoRepo     = (*memoryPriceRepo)(nil)
+	_ priceRepo.LastUpdateMongoRepo    = (*memoryLastUpdateRepo)(nil)
+	_ priceRepo.CorrectionMongoRepo    = (*memoryCorrectionRepo)(nil)
+	_ priceRepo.QuarantineMongoRepo    = (*memoryQuarantineRepo)(nil)
+	_ priceRepo.AnomalyMongoRepo       = (*memoryAnomalyRepo)(nil)
+	_ priceRepo.AlertRuleMongoRepo     = (*memoryAlertRuleRepo)(nil)
+	_ priceRepo.AlertOutboxMongoRepo   = (*memoryAlertOutboxRepo)(nil)
+	_ priceRepo.AssetMetadataMongoRepo = (*memoryMetadataRepo)(nil)
+)
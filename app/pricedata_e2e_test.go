@@ -0,0 +1,442 @@
+package server
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	priceDataApi "github.com/erich/api/pricedata/price_data/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/erich/pricetracking/gateway"
+	"github.com/erich/pricetracking/model"
+)
+
+// quarterHours returns a point every 15 minutes from start with the given values
+func quarterHours(start time.Time, values ...float64) []gateway.TempEntry {
+	points := make([]gateway.TempEntry, len(values))
+	for i, value := range values {
+		points[i] = gateway.TempEntry{Time: start.Add(time.Duration(i) * 15 * time.Minute).Unix(), Value: value}
+	}
+	return points
+}
+
+// testStart is an hour well within the bootstrap range of two years
+func testStart() time.Time {
+	return time.Now().UTC().Truncate(time.Hour).Add(-48 * time.Hour)
+}
+
+func findHourly(t *testing.T, h *harness, start time.Time, end time.Time) []*priceDataApi.PriceData {
+	t.Helper()
+	resp, err := h.client.FindData(context.Background(), &priceDataApi.FindDataRequest{Query: &priceDataApi.Query{
+		Start:       timestamppb.New(start),
+		End:         timestamppb.New(end),
+		Window:      "1h",
+		Aggregation: priceDataApi.Aggregation_AGGREGATION_AVG,
+	}})
+	if err != nil {
+		t.Fatalf("FindData: %v", err)
+	}
+	return resp.Prices
+}
+
+func assertPrices(t *testing.T, got []*priceDataApi.PriceData, want map[time.Time]float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d prices, want %d: %v", len(got), len(want), got)
+	}
+	for _, price := range got {
+		value, ok := want[price.Time.AsTime()]
+		if !ok || value != price.Value {
+			t.Errorf("got %v at %v, want %v", price.Value, price.Time.AsTime(), want)
+		}
+	}
+}
+
+func assertCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Fatalf("got code %v (%v), want %v", got, err, want)
+	}
+}
+
+func TestLoadDataThenFindData(t *testing.T) {
+	h := newHarness(t)
+	start := testStart()
+	h.api.SetSeries(quarterHours(start, 10, 11, 12, 13, 14, 15, 16, 17)...)
+
+	if _, err := h.client.LoadData(context.Background(), &priceDataApi.LoadDataRequest{}); err != nil {
+		t.Fatalf("LoadData: %v", err)
+	}
+
+	// the first load goes back two years
+	requests := h.api.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d asset api requests, want 1", len(requests))
+	}
+	from, err := time.ParseInLocation(gateway.DATE_FORMAT, requests[0].Start, time.Local)
+	if err != nil || time.Since(from) < 2*365*24*time.Hour {
+		t.Errorf("first load started at %q, want two years back", requests[0].Start)
+	}
+	if h.store.LastUpdate().IsZero() {
+		t.Error("last update was not recorded")
+	}
+
+	prices := findHourly(t, h, start, start.Add(2*time.Hour))
+	assertPrices(t, prices, map[time.Time]float64{
+		start:                11.5,
+		start.Add(time.Hour): 15.5,
+	})
+}
+
+func TestLoadDataContinuesFromLastUpdate(t *testing.T) {
+	h := newHarness(t)
+	start := testStart()
+	h.api.Script(
+		assetResponse{Points: quarterHours(start, 10, 10, 10, 10)},
+		assetResponse{Points: quarterHours(start.Add(time.Hour), 20, 20, 20, 20)},
+	)
+
+	for i := 0; i < 2; i++ {
+		if _, err := h.client.LoadData(context.Background(), &priceDataApi.LoadDataRequest{}); err != nil {
+			t.Fatalf("LoadData %d: %v", i, err)
+		}
+	}
+
+	requests := h.api.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("got %d asset api requests, want 2", len(requests))
+	}
+	if requests[1].Start != requests[0].End {
+		t.Errorf("second load started at %q, want the end of the first load %q", requests[1].Start, requests[0].End)
+	}
+	assertPrices(t, findHourly(t, h, start, start.Add(2*time.Hour)), map[time.Time]float64{
+		start:                10,
+		start.Add(time.Hour): 20,
+	})
+}
+
+func TestLoadDataQuarantinesInvalidPoints(t *testing.T) {
+	h := newHarness(t)
+	start := testStart()
+	// above validation.MaxValue
+	h.api.SetSeries(quarterHours(start, 10, 20000, 12, 14)...)
+
+	if _, err := h.client.LoadData(context.Background(), &priceDataApi.LoadDataRequest{}); err != nil {
+		t.Fatalf("LoadData: %v", err)
+	}
+
+	quarantined := h.store.Quarantined()
+	if len(quarantined) != 1 || quarantined[0].Value != 20000 {
+		t.Fatalf("got quarantined %v, want the point of 20000", quarantined)
+	}
+	assertPrices(t, findHourly(t, h, start, start.Add(time.Hour)), map[time.Time]float64{start: 12})
+}
+
+func TestReleaseQuarantinedAppliesDedupPolicy(t *testing.T) {
+	h := newHarness(t)
+	start := testStart()
+	h.api.SetSeries(quarterHours(start, 10, 20000, 12, 14)...)
+	if _, err := h.client.LoadData(context.Background(), &priceDataApi.LoadDataRequest{}); err != nil {
+		t.Fatalf("LoadData: %v", err)
+	}
+	asset := h.cfg.AssetClient.Asset
+	err := h.store.repositories().QuarantineMongoRepo.Create(context.Background(), []model.QuarantinedEntry{
+		{Asset: asset, Time: start, Value: 99, Rule: "MaxValue"},
+		{Asset: asset, Time: start.Add(30 * time.Minute), Value: math.NaN(), Rule: "NotFinite"},
+	})
+	if err != nil {
+		t.Fatalf("quarantine: %v", err)
+	}
+	quarantined := h.store.Quarantined()
+	release := func(ids ...string) (*priceDataApi.ReleaseQuarantinedResponse, error) {
+		return h.client.ReleaseQuarantined(context.Background(), &priceDataApi.ReleaseQuarantinedRequest{Ids: ids})
+	}
+
+	_, err = release(quarantined[0].ID, quarantined[2].ID)
+	assertCode(t, err, codes.InvalidArgument)
+	if got := len(h.store.Quarantined()); got != 3 {
+		t.Fatalf("got %d quarantined points after a refused release, want 3", got)
+	}
+
+	// the stored point at start is kept by the skip policy
+	resp, err := release(quarantined[0].ID, quarantined[1].ID)
+	if err != nil {
+		t.Fatalf("ReleaseQuarantined: %v", err)
+	}
+	if resp.Released != 2 || len(h.store.Quarantined()) != 1 {
+		t.Fatalf("got %d released and %v quarantined, want 2 released", resp.Released, h.store.Quarantined())
+	}
+	assertPrices(t, findHourly(t, h, start, start.Add(time.Hour)), map[time.Time]float64{start: (10 + 20000 + 12 + 14) / 4.0})
+}
+
+func TestReleaseQuarantinedRetryStoresOnce(t *testing.T) {
+	h := newHarness(t, withConfig("import.DedupPolicy", "allow"))
+	start := testStart()
+	h.api.SetSeries(quarterHours(start, 10, 20000, 12, 14)...)
+	if _, err := h.client.LoadData(context.Background(), &priceDataApi.LoadDataRequest{}); err != nil {
+		t.Fatalf("LoadData: %v", err)
+	}
+
+	// a release which stored the point and failed to delete it from the quarantine
+	quarantined := h.store.Quarantined()[0]
+	repos := h.store.repositories()
+	if err := repos.QuarantineMongoRepo.MarkReleasing(context.Background(), []string{quarantined.ID}); err != nil {
+		t.Fatalf("MarkReleasing: %v", err)
+	}
+	if err := repos.PriceDataMongoRepo.Create(context.Background(), []model.Entry{quarantined.ToEntry()}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	_, err := h.client.ReleaseQuarantined(context.Background(), &priceDataApi.ReleaseQuarantinedRequest{Ids: []string{quarantined.ID}})
+	if err != nil {
+		t.Fatalf("ReleaseQuarantined: %v", err)
+	}
+	if points := h.store.Points(); len(points) != 4 {
+		t.Fatalf("got %d stored points, want the released point stored once: %v", len(points), points)
+	}
+}
+
+func TestLoadDataAssetAPIErrors(t *testing.T) {
+	for name, response := range map[string]assetResponse{
+		"server error":   {Status: http.StatusInternalServerError},
+		"not found":      {Status: http.StatusNotFound},
+		"malformed body": {Body: `{"result": [`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := newHarness(t)
+			h.api.Script(response)
+
+			_, err := h.client.LoadData(context.Background(), &priceDataApi.LoadDataRequest{})
+			assertCode(t, err, codes.Internal)
+			if points := h.store.Points(); len(points) != 0 {
+				t.Errorf("got %d stored points after a failed load", len(points))
+			}
+			if !h.store.LastUpdate().IsZero() {
+				t.Error("last update was recorded after a failed load")
+			}
+		})
+	}
+}
+
+func TestLoadDataAssetAPILatency(t *testing.T) {
+	h := newHarness(t)
+	h.api.SetLatency(5 * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	began := time.Now()
+	_, err := h.client.LoadData(ctx, &priceDataApi.LoadDataRequest{})
+	assertCode(t, err, codes.DeadlineExceeded)
+	if elapsed := time.Since(began); elapsed > 2*time.Second {
+		t.Errorf("LoadData returned after %v, want the deadline", elapsed)
+	}
+	if len(h.api.Requests()) != 1 {
+		t.Errorf("got %d asset api requests, want 1", len(h.api.Requests()))
+	}
+}
+
+func TestLoadDataOpensCircuit(t *testing.T) {
+	h := newHarness(t, withConfig("assetClient.CircuitThreshold", 2))
+	h.api.Script(
+		assetResponse{Status: http.StatusBadGateway},
+		assetResponse{Status: http.StatusBadGateway},
+	)
+
+	for i := 0; i < 2; i++ {
+		_, err := h.client.LoadData(context.Background(), &priceDataApi.LoadDataRequest{})
+		assertCode(t, err, codes.Internal)
+	}
+
+	// the open circuit fails the load without calling the api
+	_, err := h.client.LoadData(context.Background(), &priceDataApi.LoadDataRequest{})
+	assertCode(t, err, codes.Internal)
+	if !strings.Contains(status.Convert(err).Message(), gateway.ErrCircuitOpen.Error()) {
+		t.Errorf("got %v, want %v", err, gateway.ErrCircuitOpen)
+	}
+	if len(h.api.Requests()) != 2 {
+		t.Errorf("got %d asset api requests, want 2", len(h.api.Requests()))
+	}
+}
+
+func TestFindDataInvalidQuery(t *testing.T) {
+	h := newHarness(t)
+	start := testStart()
+
+	for name, query := range map[string]*priceDataApi.Query{
+		"missing query": nil,
+		"invalid window": {
+			Start: timestamppb.New(start), End: timestamppb.New(start.Add(time.Hour)),
+			Window: "fortnight", Aggregation: priceDataApi.Aggregation_AGGREGATION_AVG,
+		},
+		"missing aggregation": {
+			Start: timestamppb.New(start), End: timestamppb.New(start.Add(time.Hour)),
+			Window: "1h",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := h.client.FindData(context.Background(), &priceDataApi.FindDataRequest{Query: query})
+			assertCode(t, err, codes.InvalidArgument)
+		})
+	}
+}
+
+func TestFindDataConvertsUnits(t *testing.T) {
+	// eurusd stores USD per EUR, the asset is in USD/MWh
+	h := newHarness(t, withConfig("fx.Series", map[string]string{"EUR/USD": "eurusd"}))
+	start := testStart()
+	h.api.SetSeries(quarterHours(start, 10, 10, 20, 20)...)
+	if _, err := h.client.LoadData(context.Background(), &priceDataApi.LoadDataRequest{}); err != nil {
+		t.Fatalf("LoadData: %v", err)
+	}
+	err := h.store.repositories().PriceDataMongoRepo.Create(context.Background(), []model.Entry{
+		{Time: start, Value: 1, Asset: "eurusd"},
+		{Time: start.Add(30 * time.Minute), Value: 0.5, Asset: "eurusd"},
+	})
+	if err != nil {
+		t.Fatalf("create fx series: %v", err)
+	}
+
+	for name, test := range map[string]struct {
+		currency, unit string
+		want           float64
+		wantUnit       string
+	}{
+		// every point is converted with its rate, 10, 10, 40 and 40 EUR
+		"fx within the window": {currency: "EUR", want: 25, wantUnit: "EUR/MWh"},
+		"cents per kWh":        {unit: "¢/kWh", want: 1.5, wantUnit: "¢/kWh"},
+		"dollars per kWh":      {unit: "$/kWh", want: 0.015, wantUnit: "$/kWh"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp, err := h.client.FindData(context.Background(), &priceDataApi.FindDataRequest{Query: &priceDataApi.Query{
+				Start:       timestamppb.New(start),
+				End:         timestamppb.New(start.Add(time.Hour)),
+				Window:      "1h",
+				Aggregation: priceDataApi.Aggregation_AGGREGATION_AVG,
+				Currency:    test.currency,
+				Unit:        test.unit,
+			}})
+			if err != nil {
+				t.Fatalf("FindData: %v", err)
+			}
+			if unit := resp.Currency + "/" + resp.Unit; unit != test.wantUnit {
+				t.Errorf("got unit %s, want %s", unit, test.wantUnit)
+			}
+			if len(resp.Prices) != 1 || math.Abs(resp.Prices[0].Value-test.want) > 1e-9 {
+				t.Fatalf("got prices %v, want %v", resp.Prices, test.want)
+			}
+		})
+	}
+
+	_, err = h.client.FindData(context.Background(), &priceDataApi.FindDataRequest{Query: &priceDataApi.Query{
+		Start:    timestamppb.New(start),
+		End:      timestamppb.New(start.Add(time.Hour)),
+		Currency: "EURO",
+	}})
+	assertCode(t, err, codes.InvalidArgument)
+}
+
+func TestExportDataConvertsUnits(t *testing.T) {
+	h := newHarness(t)
+	start := testStart()
+	h.api.SetSeries(quarterHours(start, 10, 10, 20, 20)...)
+	if _, err := h.client.LoadData(context.Background(), &priceDataApi.LoadDataRequest{}); err != nil {
+		t.Fatalf("LoadData: %v", err)
+	}
+	export := func(req *priceDataApi.ExportDataRequest) (string, error) {
+		stream, err := h.client.ExportData(context.Background(), req)
+		if err != nil {
+			return "", err
+		}
+		var out strings.Builder
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				return out.String(), nil
+			}
+			if err != nil {
+				return "", err
+			}
+			out.Write(resp.Chunk)
+		}
+	}
+
+	got, err := export(&priceDataApi.ExportDataRequest{Format: priceDataApi.ExportFormat_EXPORT_FORMAT_CSV, Query: &priceDataApi.Query{
+		Start:       timestamppb.New(start),
+		End:         timestamppb.New(start.Add(time.Hour)),
+		Window:      "1h",
+		Aggregation: priceDataApi.Aggregation_AGGREGATION_AVG,
+		Unit:        "kWh",
+	}})
+	if err != nil {
+		t.Fatalf("ExportData: %v", err)
+	}
+	want := "time,asset,unit,window,aggregation,value\n" + start.Format(time.RFC3339) + "," + h.cfg.AssetClient.Asset + ",USD/kWh,1h,avg,0.015\n"
+	if got != want {
+		t.Errorf("got export %q, want %q", got, want)
+	}
+
+	// the raw points cannot be converted
+	_, err = export(&priceDataApi.ExportDataRequest{Format: priceDataApi.ExportFormat_EXPORT_FORMAT_CSV, Raw: true, Query: &priceDataApi.Query{
+		Start: timestamppb.New(start),
+		End:   timestamppb.New(start.Add(time.Hour)),
+		Unit:  "kWh",
+	}})
+	assertCode(t, err, codes.InvalidArgument)
+}
+
+func TestLoadDataRetriesAlertDelivery(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, r.Header.Get(gateway.EVENT_ID_HEADER))
+		// the first attempt fails
+		if len(events) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer webhook.Close()
+
+	h := newHarness(t,
+		withConfig("alert.Webhooks", []string{webhook.URL}),
+		withConfig("alert.Secret", "secret"),
+		withConfig("alert.Interval", 1),
+		withConfig("alert.RetryBackoff", 0),
+	)
+	_, err := h.client.CreateAlertRule(context.Background(), &priceDataApi.CreateAlertRuleRequest{
+		Condition: priceDataApi.AlertCondition_ALERT_CONDITION_CROSSES_ABOVE,
+		Level:     100,
+	})
+	if err != nil {
+		t.Fatalf("CreateAlertRule: %v", err)
+	}
+	h.api.SetSeries(quarterHours(testStart(), 90, 120)...)
+
+	// the load only queues the delivery, the dispatcher posts it
+	if _, err := h.client.LoadData(context.Background(), &priceDataApi.LoadDataRequest{}); err != nil {
+		t.Fatalf("LoadData: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(h.store.Outbox()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 || events[0] == "" || events[0] != events[1] {
+		t.Fatalf("got deliveries %v, want a failed and a retried delivery of the same event", events)
+	}
+	if outbox := h.store.Outbox(); len(outbox) != 0 {
+		t.Errorf("got %d queued deliveries after the retry, want 0", len(outbox))
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+
+	grpcMiddleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpcCtxTags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/erich/pricetracking/config"
+	"github.com/erich/pricetracking/helper/auth"
+	"github.com/erich/pricetracking/helper/tenant"
+)
+
+// tenantResolver takes the tenant of PriceDataService calls from the request metadata, checks that the client
+// certificate belongs to the tenant and applies its rate limit. The health and reflection services are called without a tenant.
+type tenantResolver struct {
+	cfg *config.Config
+	// client identities of every tenant
+	tenants map[string]map[string]bool
+	limiter *tenant.Limiter
+}
+
+func newTenantResolver(cfg *config.Config) *tenantResolver {
+	r := &tenantResolver{
+		cfg:     cfg,
+		tenants: map[string]map[string]bool{},
+		limiter: tenant.NewLimiter(cfg.Tenant.RateLimit, cfg.Tenant.Burst),
+	}
+	for _, t := range cfg.Tenant.Tenants {
+		r.tenants[t.ID] = map[string]bool{}
+		for _, client := range t.Clients {
+			r.tenants[t.ID][client] = true
+		}
+	}
+	return r
+}
+
+// SetRateLimit applies the rate limit of a reloaded config
+func (r *tenantResolver) SetRateLimit(cfg *config.Config) {
+	r.limiter.SetRate(cfg.Tenant.RateLimit, cfg.Tenant.Burst)
+}
+
+// allowed reports whether the client identity of the call belongs to the tenant
+func (r *tenantResolver) allowed(ctx context.Context, id string) (bool, error) {
+	clients, ok := r.tenants[id]
+	if !ok {
+		return false, status.Errorf(codes.PermissionDenied, "unknown tenant %q", id)
+	}
+	identity, ok := auth.ClientIdentityFromContext(ctx)
+	if !ok {
+		return false, status.Error(codes.Unauthenticated, "a client certificate is required to call as a tenant")
+	}
+	for _, name := range identity.Names() {
+		if clients[name] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *tenantResolver) resolve(ctx context.Context, fullMethod string) (context.Context, error) {
+	if !isPriceDataMethod(fullMethod) {
+		return ctx, nil
+	}
+
+	// without tenant mode all calls share the limit of the tenant ""
+	id := ""
+	if r.cfg.Tenant.Enabled() {
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get(r.cfg.Tenant.Header)
+		if len(values) == 0 || values[0] == "" {
+			return nil, status.Errorf(codes.Unauthenticated, "the %s metadata is required", r.cfg.Tenant.Header)
+		}
+		id = values[0]
+		allowed, err := r.allowed(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, status.Errorf(codes.PermissionDenied, "the client certificate does not belong to tenant %q", id)
+		}
+		grpcCtxTags.Extract(ctx).Set("tenant", id)
+		ctx = tenant.WithTenant(ctx, id)
+	}
+
+	if !r.limiter.Allow(id) {
+		return nil, status.Error(codes.ResourceExhausted, "rate limit of the tenant exceeded")
+	}
+	return ctx, nil
+}
+
+// UnaryServerInterceptor sets the tenant of unary calls
+func (r *tenantResolver) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		newCtx, err := r.resolve(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(newCtx, req)
+	}
+}
+
+// StreamServerInterceptor sets the tenant of streaming calls
+func (r *tenantResolver) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		newCtx, err := r.resolve(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		wrapped := &grpcMiddleware.WrappedServerStream{ServerStream: stream, WrappedContext: newCtx}
+		return handler(srv, wrapped)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 )
 
@@ -19,6 +20,18 @@ type Config struct {
 	Logger   Logger
 	Jaeger   Jaeger
 	AssetClient AssetClient
+	GrpcWeb     GrpcWeb
+	Import      Import
+	Validation  Validation
+	Anomaly     Anomaly
+	Coverage    Coverage
+	Batch       Batch
+	Alert       Alert
+	Forecast    Forecast
+	Fx          Fx
+	Health      Health
+	TLS         TLS
+	Tenant      Tenant
 	//Auth     []AuthConfig
 	//JWT      JWT
 }
@@ -27,11 +40,15 @@ type Config struct {
 type ServerConfig struct {
 	AppVersion        string
 	Port              string
+	HttpPort          string // REST/JSON gateway port, the gateway is disabled if empty
 	Mode              string // if mode is not "Production", the reflection is be enabled
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
 	MaxConnectionIdle time.Duration
 	Timeout           time.Duration
 	MaxConnectionAge  time.Duration
 	Time              time.Duration
+	ShutdownTimeout   time.Duration // seconds in-flight RPCs and loads are drained for on shutdown
 }
 
 // Logger config
@@ -44,7 +61,21 @@ type Logger struct {
 
 
 type MongoConfig struct {
-	Uri string
+	Uri         string // connection string, credentials are better set with Username and Password
+	Username    string
+	Password    string
+	AuthSource  string // database the user is defined in, defaults to admin
+	AutoMigrate bool   // apply the pending schema migrations at startup, the server does not start with pending migrations otherwise
+	Database    string // defaults to zeonology, the tenant id is appended in the database tenant mode
+	Collections Collections
+	TimeSeries  TimeSeries
+}
+
+// TimeSeries are the options of the price data time series collection. Migration 5 rebuilds the collection with
+// them, afterwards the startup reports where the collection differs from them. The metaField is always asset.
+type TimeSeries struct {
+	Granularity string        // seconds, minutes or hours, close to the interval of the points of a series
+	BucketSpan  time.Duration // seconds, custom bucketing of mongo 6.3 instead of the granularity
 }
 
 // Metrics config
@@ -63,6 +94,95 @@ type Jaeger struct {
 
 type AssetClient struct {
 	ServerAddr string
+	Asset      string // id of the asset served by ServerAddr
+	Currency   string // price currency of the asset unless stored otherwise, e.g. USD
+	Unit       string // energy unit of the asset price unless stored otherwise, e.g. MWh
+	// consecutive failed loads which open the circuit, loads fail fast until CircuitCooldown seconds passed
+	CircuitThreshold int
+	CircuitCooldown  time.Duration
+}
+
+// GrpcWeb is config for the native gRPC-Web server used by browser clients
+type GrpcWeb struct {
+	Port           string   // gRPC-Web is disabled if empty
+	AllowedOrigins []string // CORS origins allowed to call the api, "*" allows any origin
+}
+
+// Import is config for ImportData
+type Import struct {
+	DedupPolicy   string // skip, overwrite or allow points of an already stored timestamp
+	BatchSize     int    // number of rows persisted at once
+	MaxRejections int    // number of rejected rows detailed in the import summary
+}
+
+// Validation is config for the data quality checks of loaded price points
+type Validation struct {
+	Rules         []string // enabled rules: not_finite, negative, zero, out_of_range, future_timestamp
+	MinValue      float64  // lower bound of out_of_range
+	MaxValue      float64  // upper bound of out_of_range
+	MaxFutureSkew time.Duration
+}
+
+// Anomaly is config for the statistical anomaly detection which runs after every load
+type Anomaly struct {
+	Rules           []string      // enabled rules: zscore, mad, jump
+	Window          int           // number of preceding points the rolling statistics are computed over
+	Lookback        time.Duration // hours of stored history preceding the loaded points
+	ZScoreThreshold float64
+	MadThreshold    float64 // threshold of the modified z-score based on the median absolute deviation
+	JumpThreshold   float64 // percentage change between consecutive points
+}
+
+// Coverage is config for the data gap and coverage report
+type Coverage struct {
+	Cadence     time.Duration // minutes between expected price points, inferred from the data if 0
+	MinGap      time.Duration // minutes, shorter gaps are not reported. Defaults to twice the cadence
+	GaugeWindow time.Duration // hours of data the coverage gauge is computed over after every load
+}
+
+// Batch is config for BatchFindData
+type Batch struct {
+	Workers    int // queries of a batch executed concurrently
+	MaxQueries int // queries allowed per batch
+}
+
+// Alert is config for the delivery of firing alert rules
+type Alert struct {
+	Webhooks     []string      // urls the firing alerts are posted to
+	Secret       string        // key of the HMAC-SHA256 payload signature
+	MaxRetries   int           // retries of a failed delivery per webhook
+	RetryBackoff time.Duration // seconds before the first retry, doubled for every further retry
+	Timeout      time.Duration // seconds per delivery attempt
+	Interval     time.Duration // seconds between the checks for pending deliveries
+}
+
+// Forecast is config for the Holt-Winters forecasts
+type Forecast struct {
+	HistorySeasons int           // seasons of stored history the model is fitted on
+	CacheSize      int           // forecasts kept in memory, the least recently used is dropped first. Defaults to 256
+	CacheTTL       time.Duration // minutes a forecast is kept, defaults to 60
+}
+
+// Fx is config for the currency conversion of queries
+type Fx struct {
+	Series       map[string]string // asset of the rate series per currency pair, e.g. EUR/USD: <asset> storing USD per EUR
+	MaxStaleness time.Duration     // hours a rate is used for the following points
+}
+
+// Health is config for the dependency checks reported by the health service and /readyz
+type Health struct {
+	Interval   time.Duration // seconds between checks
+	Timeout    time.Duration // seconds per check
+	MaxLoadAge time.Duration // minutes since the last successful load before the data is stale, 0 disables the check
+}
+
+// TLS is config for the certificates of the GRPC server, TLS is disabled if CertFile is empty
+type TLS struct {
+	CertFile       string
+	KeyFile        string
+	ClientCAFile   string        // CA bundle client certificates are verified against, enables mutual TLS
+	ClientAuth     string        // require or request a client certificate if ClientCAFile is set, defaults to require
+	ReloadInterval time.Duration // seconds between checks of the files for changes
 }
 
 /* type AuthConfig struct {
@@ -127,6 +247,19 @@ func ParseConfig(v *viper.Viper) (*Config, error) {
 		log.Printf("unable to decode into struct, %v", err)
 		return nil, err
 	}
+	if err := readSecrets(v, &c); err != nil {
+		return nil, err
+	}
+	c.Mongo.setDefaults()
+
+	// keys without a field would be ignored silently
+	problems := c.problems()
+	for _, key := range unknownKeys(v) {
+		problems.add(key, "unknown key")
+	}
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
 
 	return &c, nil
 }
@@ -147,6 +280,27 @@ func GetServiceConfig() (*Config, error) {
 	return cfg, nil
 }
 
+// WatchServiceConfig parses the config file again on every change and passes the new config to onChange,
+// changes which fail validation are logged and ignored. The running config is not modified,
+// onChange applies the settings which are safe to change without a restart.
+func WatchServiceConfig(onChange func(cfg *Config)) error {
+	cfgViper, err := LoadViperConfig()
+	if err != nil {
+		return err
+	}
+
+	cfgViper.OnConfigChange(func(e fsnotify.Event) {
+		cfg, err := ParseConfig(cfgViper)
+		if err != nil {
+			log.Printf("config change of %s is ignored. %v", e.Name, err)
+			return
+		}
+		onChange(cfg)
+	})
+	cfgViper.WatchConfig()
+	return nil
+}
+
 func (c Config) GetTracerName() string {
 	return fmt.Sprintf("%s-tracer", c.Jaeger.ServiceName)
 }
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// REDACTED replaces secrets in logs
+const REDACTED = "xxxxx"
+
+// secretSettings are the settings which may be read from a file, e.g. a kubernetes secret mount. The path is taken
+// from the <KEY>_FILE environment variable (e.g. MONGO_PASSWORD_FILE) or the <key>File setting (e.g. mongo.PasswordFile).
+var secretSettings = []struct {
+	key   string
+	value func(c *Config) *string
+}{
+	{"mongo.Uri", func(c *Config) *string { return &c.Mongo.Uri }},
+	{"mongo.Password", func(c *Config) *string { return &c.Mongo.Password }},
+	{"alert.Secret", func(c *Config) *string { return &c.Alert.Secret }},
+}
+
+// uriCredentials matches the password of the userinfo in a connection string
+var uriCredentials = regexp.MustCompile(`(://[^:/@\s]*:)[^@/\s]*@`)
+
+// readSecrets replaces the secret settings with the content of their files
+func readSecrets(v *viper.Viper, c *Config) error {
+	for _, secret := range secretSettings {
+		path := os.Getenv(strings.ToUpper(strings.ReplaceAll(secret.key, ".", "_")) + "_FILE")
+		if path == "" {
+			path = v.GetString(secret.key + "File")
+		}
+		if path == "" {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("secret %s: %w", secret.key, err)
+		}
+		// secret files usually end with a newline
+		*secret.value(c) = strings.TrimRight(string(content), "\r\n")
+	}
+	return nil
+}
+
+// secretFileKeys are the <key>File settings of the secrets
+func secretFileKeys() []string {
+	keys := make([]string, len(secretSettings))
+	for i, secret := range secretSettings {
+		keys[i] = strings.ToLower(secret.key + "File")
+	}
+	return keys
+}
+
+// Secrets returns the configured secret values, they are redacted from every log line
+func (c *Config) Secrets() []string {
+	var secrets []string
+	for _, value := range []string{c.Mongo.Password, c.Alert.Secret} {
+		if value != "" {
+			secrets = append(secrets, value)
+		}
+	}
+	if u, err := url.Parse(c.Mongo.Uri); err == nil && u.User != nil {
+		if password, ok := u.User.Password(); ok && password != "" {
+			secrets = append(secrets, password, url.QueryEscape(password))
+		}
+	}
+	return secrets
+}
+
+// RedactURI replaces the password of a connection string
+func RedactURI(uri string) string {
+	return uriCredentials.ReplaceAllString(uri, "${1}"+REDACTED+"@")
+}
+
+// Redact replaces the secrets and the passwords of connection strings in s. The secrets are replaced as they are
+// and as escaped in JSON strings, as the JSON log encoder escapes quotes, backslashes and control characters.
+func Redact(s string, secrets []string) string {
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, REDACTED)
+		if escaped := jsonEscape(secret); escaped != secret {
+			s = strings.ReplaceAll(s, escaped, REDACTED)
+		}
+	}
+	return RedactURI(s)
+}
+
+// jsonEscape escapes s like the zap JSON encoder escapes strings
+func jsonEscape(s string) string {
+	const hex = "0123456789abcdef"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' || c == '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < 0x20:
+			b.WriteString(`\u00`)
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xF])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
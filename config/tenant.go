@@ -0,0 +1,106 @@
+package config
+
+const (
+	DEFAULT_DB_NAME = "zeonology"
+
+	TENANT_MODE_DATABASE = "database" // every tenant has its own database
+	TENANT_MODE_PREFIX   = "prefix"   // the tenants share the database, the collection names are prefixed with the tenant id
+)
+
+// Collections are the collection names, the defaults are used for empty names
+type Collections struct {
+	PriceData     string
+	LastRetrieval string
+	Corrections   string
+	Audit         string
+	Quarantine    string
+	Anomalies     string
+	AlertRules    string
+	AlertOutbox   string // pending webhook deliveries of the firing alerts
+	AssetMetadata string
+	Migrations    string // the lock of the migrations is kept in <Migrations>_lock
+}
+
+var defaultCollections = Collections{
+	PriceData:     "priceData",
+	LastRetrieval: "lastretrival",
+	Corrections:   "priceCorrections",
+	Audit:         "priceAudit",
+	Quarantine:    "priceQuarantine",
+	Anomalies:     "priceAnomalies",
+	AlertRules:    "priceAlertRules",
+	AlertOutbox:   "priceAlertOutbox",
+	AssetMetadata: "assetMetadata",
+	Migrations:    "schema_migrations",
+}
+
+// names returns the collection names paired with their defaults
+func (c *Collections) names() [][2]*string {
+	d := &defaultCollections
+	return [][2]*string{
+		{&c.PriceData, &d.PriceData},
+		{&c.LastRetrieval, &d.LastRetrieval},
+		{&c.Corrections, &d.Corrections},
+		{&c.Audit, &d.Audit},
+		{&c.Quarantine, &d.Quarantine},
+		{&c.Anomalies, &d.Anomalies},
+		{&c.AlertRules, &d.AlertRules},
+		{&c.AlertOutbox, &d.AlertOutbox},
+		{&c.AssetMetadata, &d.AssetMetadata},
+		{&c.Migrations, &d.Migrations},
+	}
+}
+
+func (c *MongoConfig) setDefaults() {
+	if c.Database == "" {
+		c.Database = DEFAULT_DB_NAME
+	}
+	for _, name := range c.Collections.names() {
+		if *name[0] == "" {
+			*name[0] = *name[1]
+		}
+	}
+}
+
+// Tenant config. Without a mode the service is single-tenant and the tenant header is ignored.
+// With a mode, mutual TLS is required and the tenant header must name a tenant of the client certificate.
+type Tenant struct {
+	Mode      string         // database or prefix, see TENANT_MODE_*
+	Header    string         // request metadata key carrying the tenant id, e.g. x-tenant-id
+	Tenants   []TenantConfig // requests of other tenants are rejected
+	RateLimit float64        // requests per second of a tenant, 0 disables the limit
+	Burst     int            // requests a tenant may send at once above the rate, defaults to the rate
+}
+
+type TenantConfig struct {
+	ID         string // lower case letters, digits, - and _, as it becomes part of the database or collection names
+	Asset      string // asset of the loads of the tenant, defaults to assetClient.Asset
+	ServerAddr string // series url of the asset, defaults to assetClient.ServerAddr
+	// Clients are the client certificate identities allowed to call as the tenant, a common name, DNS name or URI
+	Clients []string
+}
+
+// Enabled reports whether the service runs in a tenant mode
+func (t Tenant) Enabled() bool {
+	return t.Mode != ""
+}
+
+// ForTenant returns a copy of the config with the database, collection names and asset of the tenant
+func (c *Config) ForTenant(tenant TenantConfig) *Config {
+	tenantCfg := *c
+	switch c.Tenant.Mode {
+	case TENANT_MODE_DATABASE:
+		tenantCfg.Mongo.Database = c.Mongo.Database + "_" + tenant.ID
+	case TENANT_MODE_PREFIX:
+		for _, name := range tenantCfg.Mongo.Collections.names() {
+			*name[0] = tenant.ID + "_" + *name[0]
+		}
+	}
+	if tenant.Asset != "" {
+		tenantCfg.AssetClient.Asset = tenant.Asset
+	}
+	if tenant.ServerAddr != "" {
+		tenantCfg.AssetClient.ServerAddr = tenant.ServerAddr
+	}
+	return &tenantCfg
+}
@@ -0,0 +1,307 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/erich/pricetracking/model"
+)
+
+// maxDurationValue bounds the durations, which are plain numbers of the unit documented per field.
+// Values parsed from strings like "5s" are nanoseconds and exceed it.
+const maxDurationValue = 100000
+
+var logLevels = []string{"debug", "info", "warn", "error", "dpanic", "panic", "fatal"}
+
+var tenantID = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+var validationRules = []string{"not_finite", "negative", "zero", "out_of_range", "future_timestamp"}
+
+// configProblems collects every problem of the config, so that all of them are reported at once
+type configProblems []string
+
+func (p *configProblems) add(field string, format string, args ...interface{}) {
+	*p = append(*p, field+": "+fmt.Sprintf(format, args...))
+}
+
+func (p *configProblems) required(field string, value string) {
+	if value == "" {
+		p.add(field, "is required")
+	}
+}
+
+func (p *configProblems) address(field string, value string, required bool) {
+	if value == "" {
+		if required {
+			p.add(field, "is required")
+		}
+		return
+	}
+	if _, _, err := net.SplitHostPort(value); err != nil {
+		p.add(field, "%q is not a host:port address", value)
+	}
+}
+
+func (p *configProblems) url(field string, value string) {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		p.add(field, "%q is not a http(s) url", value)
+	}
+}
+
+// duration checks a duration configured as a number of unit, min is the lower bound of the number
+func (p *configProblems) duration(field string, value time.Duration, unit string, min int64) {
+	if int64(value) < min || int64(value) > maxDurationValue {
+		p.add(field, "must be a number of %s within [%d, %d], got %d", unit, min, maxDurationValue, int64(value))
+	}
+}
+
+func (p *configProblems) atLeast(field string, value int, min int) {
+	if value < min {
+		p.add(field, "must be at least %d, got %d", min, value)
+	}
+}
+
+func (p *configProblems) oneOf(field string, value string, allowed []string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	p.add(field, "%q is not one of %s", value, strings.Join(allowed, ", "))
+}
+
+// Validate checks the config and returns an error listing every problem found
+func (c *Config) Validate() error {
+	return c.problems().err()
+}
+
+func (p configProblems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid config:\n  %s", strings.Join(p, "\n  "))
+}
+
+func (c *Config) problems() configProblems {
+	var p configProblems
+
+	p.address("server.Port", c.Server.Port, true)
+	p.address("server.HttpPort", c.Server.HttpPort, false)
+	p.duration("server.ReadTimeout", c.Server.ReadTimeout, "seconds", 1)
+	p.duration("server.WriteTimeout", c.Server.WriteTimeout, "seconds", 1)
+	p.duration("server.MaxConnectionIdle", c.Server.MaxConnectionIdle, "minutes", 1)
+	p.duration("server.Timeout", c.Server.Timeout, "seconds", 1)
+	p.duration("server.MaxConnectionAge", c.Server.MaxConnectionAge, "minutes", 1)
+	p.duration("server.Time", c.Server.Time, "minutes", 1)
+	p.duration("server.ShutdownTimeout", c.Server.ShutdownTimeout, "seconds", 0)
+
+	if !strings.HasPrefix(c.Mongo.Uri, "mongodb://") && !strings.HasPrefix(c.Mongo.Uri, "mongodb+srv://") {
+		p.add("mongo.Uri", "must start with mongodb:// or mongodb+srv://")
+	}
+	if u, err := url.Parse(c.Mongo.Uri); err == nil && u.User != nil && c.Mongo.Username != "" {
+		p.add("mongo.Username", "credentials are set in mongo.Uri already")
+	}
+
+	if strings.ContainsAny(c.Mongo.Database, `/\. "$`) {
+		p.add("mongo.Database", "%q is not a valid database name", c.Mongo.Database)
+	}
+
+	if c.Mongo.TimeSeries.Granularity != "" {
+		p.oneOf("mongo.TimeSeries.Granularity", c.Mongo.TimeSeries.Granularity, []string{"seconds", "minutes", "hours"})
+		if c.Mongo.TimeSeries.BucketSpan > 0 {
+			p.add("mongo.TimeSeries.BucketSpan", "must not be set with Granularity")
+		}
+	}
+	p.duration("mongo.TimeSeries.BucketSpan", c.Mongo.TimeSeries.BucketSpan, "seconds", 0)
+
+	p.oneOf("logger.Level", c.Logger.Level, logLevels)
+	p.oneOf("logger.Encoding", c.Logger.Encoding, []string{"json", "console"})
+	p.address("metrics.URL", c.Metrics.URL, true)
+	p.address("jaeger.Host", c.Jaeger.Host, true)
+	if c.Jaeger.SamplingRatio < 0 || c.Jaeger.SamplingRatio > 1 {
+		p.add("jaeger.SamplingRatio", "must be within [0, 1], got %g", c.Jaeger.SamplingRatio)
+	}
+
+	p.url("assetClient.ServerAddr", c.AssetClient.ServerAddr)
+	p.required("assetClient.Asset", c.AssetClient.Asset)
+	// the unit may carry its currency, e.g. ¢/kWh
+	unit := model.ParsePriceUnit(c.AssetClient.Unit)
+	if _, ok := model.EnergyUnitFactor(unit.Unit); c.AssetClient.Unit != "" && !ok {
+		p.add("assetClient.Unit", "unknown energy unit %q", unit.Unit)
+	}
+	if unit.Currency != "" && c.AssetClient.Currency != "" && unit.Currency != c.AssetClient.Currency {
+		p.add("assetClient.Unit", "currency %q conflicts with assetClient.Currency %q", unit.Currency, c.AssetClient.Currency)
+	}
+	if c.AssetClient.Currency != "" && !model.KnownCurrency(c.AssetClient.Currency) {
+		p.add("assetClient.Currency", "unknown currency %q, expected an ISO 4217 code or a currency symbol", c.AssetClient.Currency)
+	}
+	if unit.Currency != "" && !model.KnownCurrency(unit.Currency) {
+		p.add("assetClient.Unit", "unknown currency %q, expected an ISO 4217 code or a currency symbol", unit.Currency)
+	}
+	p.atLeast("assetClient.CircuitThreshold", c.AssetClient.CircuitThreshold, 0)
+	p.duration("assetClient.CircuitCooldown", c.AssetClient.CircuitCooldown, "seconds", 0)
+
+	p.address("grpcWeb.Port", c.GrpcWeb.Port, false)
+
+	p.oneOf("import.DedupPolicy", c.Import.DedupPolicy,
+		[]string{string(model.DedupPolicy_SKIP), string(model.DedupPolicy_OVERWRITE), string(model.DedupPolicy_ALLOW)})
+	p.atLeast("import.BatchSize", c.Import.BatchSize, 1)
+	p.atLeast("import.MaxRejections", c.Import.MaxRejections, 0)
+
+	for _, rule := range c.Validation.Rules {
+		p.oneOf("validation.Rules", rule, validationRules)
+	}
+	if c.Validation.MinValue > c.Validation.MaxValue {
+		p.add("validation.MinValue", "must not be above MaxValue")
+	}
+	p.duration("validation.MaxFutureSkew", c.Validation.MaxFutureSkew, "seconds", 0)
+
+	for _, rule := range c.Anomaly.Rules {
+		p.oneOf("anomaly.Rules", rule,
+			[]string{string(model.AnomalyRule_ZSCORE), string(model.AnomalyRule_MAD), string(model.AnomalyRule_JUMP)})
+	}
+	if len(c.Anomaly.Rules) > 0 {
+		p.atLeast("anomaly.Window", c.Anomaly.Window, 2)
+	}
+	p.duration("anomaly.Lookback", c.Anomaly.Lookback, "hours", 0)
+
+	p.duration("coverage.Cadence", c.Coverage.Cadence, "minutes", 0)
+	p.duration("coverage.MinGap", c.Coverage.MinGap, "minutes", 0)
+	p.duration("coverage.GaugeWindow", c.Coverage.GaugeWindow, "hours", 0)
+
+	p.atLeast("batch.Workers", c.Batch.Workers, 1)
+	p.atLeast("batch.MaxQueries", c.Batch.MaxQueries, 1)
+
+	for _, webhook := range c.Alert.Webhooks {
+		p.url("alert.Webhooks", webhook)
+	}
+	if len(c.Alert.Webhooks) > 0 {
+		p.required("alert.Secret", c.Alert.Secret)
+		p.duration("alert.Timeout", c.Alert.Timeout, "seconds", 1)
+		p.duration("alert.Interval", c.Alert.Interval, "seconds", 1)
+	}
+	p.atLeast("alert.MaxRetries", c.Alert.MaxRetries, 0)
+	p.duration("alert.RetryBackoff", c.Alert.RetryBackoff, "seconds", 0)
+
+	p.atLeast("forecast.HistorySeasons", c.Forecast.HistorySeasons, 0)
+	p.atLeast("forecast.CacheSize", c.Forecast.CacheSize, 0)
+	p.duration("forecast.CacheTTL", c.Forecast.CacheTTL, "minutes", 0)
+
+	for pair := range c.Fx.Series {
+		if currencies := strings.Split(pair, "/"); len(currencies) != 2 || currencies[0] == "" || currencies[1] == "" {
+			p.add("fx.Series", "%q is not a currency pair like EUR/USD", pair)
+		}
+	}
+	p.duration("fx.MaxStaleness", c.Fx.MaxStaleness, "hours", 0)
+
+	if c.TLS.CertFile != "" {
+		p.required("tls.KeyFile", c.TLS.KeyFile)
+		if c.TLS.ClientAuth != "" {
+			p.oneOf("tls.ClientAuth", c.TLS.ClientAuth, []string{"require", "request"})
+		}
+	} else if c.TLS.ClientCAFile != "" {
+		p.add("tls.ClientCAFile", "needs tls.CertFile")
+	}
+	p.duration("tls.ReloadInterval", c.TLS.ReloadInterval, "seconds", 0)
+
+	p.duration("health.Interval", c.Health.Interval, "seconds", 0)
+	p.duration("health.Timeout", c.Health.Timeout, "seconds", 0)
+	p.duration("health.MaxLoadAge", c.Health.MaxLoadAge, "minutes", 0)
+
+	if c.Tenant.Enabled() {
+		p.oneOf("tenant.Mode", c.Tenant.Mode, []string{TENANT_MODE_DATABASE, TENANT_MODE_PREFIX})
+		p.required("tenant.Header", c.Tenant.Header)
+		if len(c.Tenant.Tenants) == 0 {
+			p.add("tenant.Tenants", "at least one tenant is required")
+		}
+		// the tenant header is set by the client, it is only trusted for the identities of the tenant
+		if c.TLS.ClientCAFile == "" {
+			p.add("tls.ClientCAFile", "is required in tenant mode, the tenants are bound to client certificates")
+		}
+		seen := map[string]bool{}
+		// the series url decides which prices are loaded, the asset only labels them
+		series := map[string]string{}
+		for _, tenant := range c.Tenant.Tenants {
+			if !tenantID.MatchString(tenant.ID) {
+				p.add("tenant.Tenants", "%q is not a tenant id of up to 32 lower case letters, digits, - and _", tenant.ID)
+			}
+			if seen[tenant.ID] {
+				p.add("tenant.Tenants", "duplicate tenant id %q", tenant.ID)
+			}
+			seen[tenant.ID] = true
+			if len(tenant.Clients) == 0 {
+				p.add("tenant.Tenants", "tenant %q needs the client identities allowed to call as the tenant", tenant.ID)
+			}
+
+			addr := c.AssetClient.ServerAddr
+			if tenant.ServerAddr != "" {
+				p.url("tenant.Tenants", tenant.ServerAddr)
+				addr = tenant.ServerAddr
+			} else if tenant.Asset != "" && tenant.Asset != c.AssetClient.Asset {
+				p.add("tenant.Tenants", "tenant %q has its own asset, its ServerAddr is required", tenant.ID)
+				continue
+			}
+			if other, ok := series[addr]; ok {
+				p.add("tenant.Tenants", "tenants %q and %q load the same series %s", other, tenant.ID, addr)
+			}
+			series[addr] = tenant.ID
+		}
+	}
+	if c.Tenant.RateLimit < 0 {
+		p.add("tenant.RateLimit", "must not be negative, got %g", c.Tenant.RateLimit)
+	}
+	p.atLeast("tenant.Burst", c.Tenant.Burst, 0)
+
+	return p
+}
+
+// unknownKeys returns the keys of the config file which do not map to a Config field
+func unknownKeys(v *viper.Viper) []string {
+	known := map[string]bool{}
+	maps := map[string]bool{}
+	collectKeys(reflect.TypeOf(Config{}), "", known, maps)
+	for _, key := range secretFileKeys() {
+		known[key] = true
+	}
+
+	var unknown []string
+	for _, key := range v.AllKeys() {
+		if known[key] {
+			continue
+		}
+		// keys of map fields are free-form
+		parent := key
+		for i := strings.LastIndex(parent, "."); i > 0 && !maps[parent]; i = strings.LastIndex(parent, ".") {
+			parent = parent[:i]
+		}
+		if !maps[parent] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func collectKeys(t reflect.Type, prefix string, known map[string]bool, maps map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := prefix + strings.ToLower(field.Name)
+		switch {
+		case field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Time{}):
+			collectKeys(field.Type, key+".", known, maps)
+		case field.Type.Kind() == reflect.Map:
+			maps[key] = true
+		default:
+			known[key] = true
+		}
+	}
+}
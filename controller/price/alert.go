@@ -0,0 +1,185 @@
+package price
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/erich/pricetracking/helper/app_errors"
+	"github.com/erich/pricetracking/helper/logger"
+	"github.com/erich/pricetracking/model"
+)
+
+// CreateAlertRule implements PriceDataController.
+func (p *priceDataController) CreateAlertRule(ctx context.Context, rule model.AlertRule) (model.AlertRule, error) {
+	ctx, span := p.tracer.Start(ctx, "priceController.CreateAlertRule")
+	defer span.End()
+
+	switch rule.Condition {
+	case model.AlertCondition_CROSSES_ABOVE, model.AlertCondition_CROSSES_BELOW:
+		if math.IsNaN(rule.Level) || math.IsInf(rule.Level, 0) {
+			return model.AlertRule{}, fmt.Errorf("%w: level is not a finite number", app_errors.ErrInvalidRequest)
+		}
+		rule.ChangePercent, rule.Window = 0, 0
+	case model.AlertCondition_CHANGE_PERCENT:
+		if !(rule.ChangePercent > 0) || math.IsInf(rule.ChangePercent, 0) {
+			return model.AlertRule{}, fmt.Errorf("%w: change percent must be positive", app_errors.ErrInvalidRequest)
+		}
+		if rule.Window <= 0 {
+			return model.AlertRule{}, fmt.Errorf("%w: window is required", app_errors.ErrInvalidRequest)
+		}
+		rule.Level = 0
+	default:
+		return model.AlertRule{}, fmt.Errorf("%w: invalid alert condition", app_errors.ErrInvalidRequest)
+	}
+
+	rule.ID = ""
+	rule.Asset = p.resolveAsset(rule.Asset)
+	rule.Firing = false
+	rule.FiredAt = time.Time{}
+	rule.CreatedAt = time.Now().UTC()
+	return p.alertRepo.Create(ctx, rule)
+}
+
+// ListAlertRules implements PriceDataController.
+func (p *priceDataController) ListAlertRules(ctx context.Context, asset string) ([]model.AlertRule, error) {
+	ctx, span := p.tracer.Start(ctx, "priceController.ListAlertRules")
+	defer span.End()
+
+	return p.alertRepo.List(ctx, asset)
+}
+
+// DeleteAlertRule implements PriceDataController.
+func (p *priceDataController) DeleteAlertRule(ctx context.Context, id string) error {
+	ctx, span := p.tracer.Start(ctx, "priceController.DeleteAlertRule")
+	defer span.End()
+
+	return p.alertRepo.Delete(ctx, id)
+}
+
+// evaluateAlerts updates the firing state of the rules of the asset with the latest loaded point,
+// the deliveries of rules which start firing are queued for DeliverAlerts
+func (p *priceDataController) evaluateAlerts(ctx context.Context, asset string, latest model.Entry) error {
+	ctx, span := p.tracer.Start(ctx, "priceController.evaluateAlerts")
+	defer span.End()
+
+	rules, err := p.alertRepo.List(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, rule := range rules {
+		firing, err := p.alertFiring(ctx, rule, latest)
+		if err != nil {
+			logger.ErrorCtx(ctx, "alert rule evaluation failed", zap.String("rule", rule.ID), zap.Error(err))
+			continue
+		}
+		changed, err := p.alertRepo.SetFiring(ctx, rule.ID, firing, now)
+		if err != nil {
+			logger.ErrorCtx(ctx, "alert rule state update failed", zap.String("rule", rule.ID), zap.Error(err))
+			continue
+		}
+		if !changed || !firing {
+			continue
+		}
+
+		event := model.AlertEvent{
+			ID:            fmt.Sprintf("%s-%d", rule.ID, latest.Time.Unix()),
+			RuleID:        rule.ID,
+			Asset:         rule.Asset,
+			Condition:     rule.Condition,
+			Level:         rule.Level,
+			ChangePercent: rule.ChangePercent,
+			Time:          latest.Time,
+			Value:         latest.Value,
+			FiredAt:       now,
+		}
+		if rule.Window > 0 {
+			event.Window = model.FormatWindow(rule.Window)
+		}
+		deliveries := make([]model.AlertDelivery, len(p.cfg.Alert.Webhooks))
+		for i, webhook := range p.cfg.Alert.Webhooks {
+			deliveries[i] = model.AlertDelivery{Event: event, Webhook: webhook, NextAttempt: now}
+		}
+		if err := p.outboxRepo.Enqueue(ctx, deliveries); err != nil {
+			// the rule fires again on the next load instead of losing the alert
+			logger.ErrorCtx(ctx, "alert delivery enqueue failed", zap.String("event", event.ID), zap.Error(err))
+			if _, err := p.alertRepo.SetFiring(ctx, rule.ID, false, now); err != nil {
+				logger.ErrorCtx(ctx, "alert rule state revert failed", zap.String("rule", rule.ID), zap.Error(err))
+			}
+		}
+	}
+	return nil
+}
+
+// DeliverAlerts implements PriceDataController.
+func (p *priceDataController) DeliverAlerts(ctx context.Context) error {
+	ctx, span := p.tracer.Start(ctx, "priceController.DeliverAlerts")
+	defer span.End()
+
+	// a claimed delivery is retried by any dispatcher once the attempt timed out
+	lease := 2 * p.cfg.Alert.Timeout * time.Second
+	for {
+		delivery, ok, err := p.outboxRepo.Claim(ctx, time.Now().UTC(), lease)
+		if err != nil || !ok {
+			return err
+		}
+
+		retry, err := p.webhookGateway.Post(ctx, delivery.Webhook, delivery.Event)
+		if ctx.Err() != nil {
+			// the claim expires, so that the delivery is retried after the restart
+			return ctx.Err()
+		}
+		if err == nil || !retry || delivery.Attempts >= p.cfg.Alert.MaxRetries {
+			if err != nil {
+				logger.ErrorCtx(ctx, "alert delivery failed", zap.String("event", delivery.Event.ID),
+					zap.String("webhook", delivery.Webhook), zap.Int("attempts", delivery.Attempts+1), zap.Error(err))
+			}
+			if err := p.outboxRepo.Delete(ctx, delivery.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// the backoff doubles with every retry
+		next := time.Now().UTC().Add(p.cfg.Alert.RetryBackoff * time.Second << delivery.Attempts)
+		if err := p.outboxRepo.Retry(ctx, delivery.ID, next, err.Error()); err != nil {
+			return err
+		}
+	}
+}
+
+// alertFiring reports whether the latest point meets the condition of the rule
+func (p *priceDataController) alertFiring(ctx context.Context, rule model.AlertRule, latest model.Entry) (bool, error) {
+	switch rule.Condition {
+	case model.AlertCondition_CROSSES_ABOVE:
+		return latest.Value > rule.Level, nil
+	case model.AlertCondition_CROSSES_BELOW:
+		return latest.Value < rule.Level, nil
+	case model.AlertCondition_CHANGE_PERCENT:
+		// the earliest point within the window is the reference
+		query := model.Query{
+			StartTime: latest.Time.Add(-rule.Window),
+			EndTime:   latest.Time,
+			Asset:     rule.Asset,
+		}
+		var ref *model.Entry
+		err := p.priceRepo.IterateRaw(ctx, query, func(entry model.Entry) error {
+			if ref == nil {
+				ref = &entry
+			}
+			return nil
+		})
+		if err != nil || ref == nil || ref.Value == 0 {
+			return false, err
+		}
+		change := (latest.Value - ref.Value) / math.Abs(ref.Value) * 100
+		return math.Abs(change) >= rule.ChangePercent, nil
+	default:
+		return false, fmt.Errorf("unknown alert condition: %s", rule.Condition)
+	}
+}
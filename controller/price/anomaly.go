@@ -0,0 +1,207 @@
+package price
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/erich/pricetracking/config"
+	"github.com/erich/pricetracking/helper/app_errors"
+	"github.com/erich/pricetracking/helper/metric"
+	"github.com/erich/pricetracking/model"
+)
+
+const (
+	defaultAnomalyWindow = 96
+	// rolling statistics need a few points of history before a point can be scored
+	minAnomalyHistory = 3
+	// scales the median absolute deviation to the standard deviation of a normal distribution
+	madScale = 0.6745
+)
+
+// anomalyRule returns the score of the point at i and whether it exceeds the threshold, history are the preceding points
+type anomalyRule func(series []model.Entry, i int, history []float64) (float64, bool)
+
+type namedAnomalyRule struct {
+	name  model.AnomalyRule
+	score anomalyRule
+}
+
+// anomalyDetector runs the configured statistical rules over a series
+type anomalyDetector struct {
+	window int
+	rules  []namedAnomalyRule
+}
+
+func newAnomalyDetector(cfg config.Anomaly) *anomalyDetector {
+	available := map[model.AnomalyRule]anomalyRule{
+		model.AnomalyRule_ZSCORE: func(series []model.Entry, i int, history []float64) (float64, bool) {
+			if len(history) < minAnomalyHistory {
+				return 0, false
+			}
+			mean, std := meanStd(history)
+			if std == 0 {
+				return 0, false
+			}
+			score := math.Abs(series[i].Value-mean) / std
+			return score, score > cfg.ZScoreThreshold
+		},
+		model.AnomalyRule_MAD: func(series []model.Entry, i int, history []float64) (float64, bool) {
+			if len(history) < minAnomalyHistory {
+				return 0, false
+			}
+			median, mad := medianMad(history)
+			if mad == 0 {
+				return 0, false
+			}
+			score := madScale * math.Abs(series[i].Value-median) / mad
+			return score, score > cfg.MadThreshold
+		},
+		model.AnomalyRule_JUMP: func(series []model.Entry, i int, history []float64) (float64, bool) {
+			if i == 0 || series[i-1].Value == 0 {
+				return 0, false
+			}
+			prev := series[i-1].Value
+			score := math.Abs(series[i].Value-prev) / math.Abs(prev) * 100
+			return score, score > cfg.JumpThreshold
+		},
+	}
+
+	d := &anomalyDetector{window: cfg.Window}
+	if d.window <= 0 {
+		d.window = defaultAnomalyWindow
+	}
+	for _, name := range cfg.Rules {
+		rule, ok := available[model.AnomalyRule(name)]
+		if !ok {
+			log.Printf("unknown anomaly rule %q is ignored", name)
+			continue
+		}
+		d.rules = append(d.rules, namedAnomalyRule{name: model.AnomalyRule(name), score: rule})
+	}
+	return d
+}
+
+// Detect scores the points of the time ordered series from `from` on, the earlier points only serve as history
+func (d *anomalyDetector) Detect(series []model.Entry, from time.Time, now time.Time) []model.Anomaly {
+	if len(d.rules) == 0 {
+		return nil
+	}
+
+	var anomalies []model.Anomaly
+	history := make([]float64, 0, d.window)
+	for i, entry := range series {
+		if !entry.Time.Before(from) {
+			for _, rule := range d.rules {
+				score, flagged := rule.score(series, i, history)
+				if !flagged {
+					continue
+				}
+				anomalies = append(anomalies, model.Anomaly{
+					Asset:      entry.Asset,
+					Time:       entry.Time,
+					Value:      entry.Value,
+					Rule:       rule.name,
+					Score:      score,
+					DetectedAt: now,
+				})
+			}
+		}
+
+		if len(history) == d.window {
+			history = history[1:]
+		}
+		history = append(history, entry.Value)
+	}
+	return anomalies
+}
+
+// detectAnomalies flags the points loaded in [start, end), the stored points of the lookback period serve as history
+func (p *priceDataController) detectAnomalies(ctx context.Context, asset string, start time.Time, end time.Time) error {
+	ctx, span := p.tracer.Start(ctx, "priceController.detectAnomalies")
+	defer span.End()
+
+	query := model.Query{
+		StartTime: start.Add(-p.cfg.Anomaly.Lookback * time.Hour),
+		EndTime:   end,
+		Asset:     asset,
+	}
+	var series []model.Entry
+	err := p.priceRepo.IterateRaw(ctx, query, func(entry model.Entry) error {
+		entry.Asset = asset
+		series = append(series, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	anomalies := p.detector.Detect(series, start, time.Now().UTC())
+	if len(anomalies) == 0 {
+		return nil
+	}
+	if err := p.anomalyRepo.Save(ctx, anomalies); err != nil {
+		return err
+	}
+
+	if metrics := metric.GetBusinessMetrics(); metrics != nil {
+		counts := map[model.AnomalyRule]int64{}
+		for _, anomaly := range anomalies {
+			counts[anomaly.Rule]++
+		}
+		for rule, count := range counts {
+			metrics.RecordAnomalies(ctx, string(rule), count)
+		}
+	}
+	return nil
+}
+
+// ListAnomalies implements PriceDataController.
+func (p *priceDataController) ListAnomalies(ctx context.Context, filter model.AnomalyFilter) ([]model.Anomaly, error) {
+	ctx, span := p.tracer.Start(ctx, "priceController.ListAnomalies")
+	defer span.End()
+
+	if !filter.StartTime.IsZero() && !filter.EndTime.IsZero() && !filter.StartTime.Before(filter.EndTime) {
+		return nil, fmt.Errorf("%w: start must be before end", app_errors.ErrInvalidRequest)
+	}
+	filter.Asset = p.resolveAsset(filter.Asset)
+	return p.anomalyRepo.List(ctx, filter)
+}
+
+func meanStd(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(values)))
+}
+
+// medianMad returns the median and the median absolute deviation of the values
+func medianMad(values []float64) (float64, float64) {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	median := medianOfSorted(sorted)
+
+	for i, v := range sorted {
+		sorted[i] = math.Abs(v - median)
+	}
+	sort.Float64s(sorted)
+	return median, medianOfSorted(sorted)
+}
+
+func medianOfSorted(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
@@ -0,0 +1,58 @@
+package price
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/erich/pricetracking/helper/app_errors"
+	"github.com/erich/pricetracking/helper/logger"
+	"github.com/erich/pricetracking/model"
+)
+
+// entryRowReader reads the loaded price points as import rows
+type entryRowReader struct {
+	entries []model.Entry
+	next    int
+}
+
+func (r *entryRowReader) Next() (model.ImportRow, error) {
+	if r.next >= len(r.entries) {
+		return model.ImportRow{}, io.EOF
+	}
+	r.next++
+	return model.ImportRow{Row: int64(r.next), Entry: r.entries[r.next-1]}, nil
+}
+
+// Backfill implements PriceDataController.
+func (p *priceDataController) Backfill(ctx context.Context, asset string, start time.Time, end time.Time) (model.ImportSummary, error) {
+	ctx, span := p.tracer.Start(ctx, "priceController.Backfill")
+	defer span.End()
+
+	if asset = p.resolveAsset(asset); asset != p.cfg.AssetClient.Asset {
+		return model.ImportSummary{}, fmt.Errorf("%w: the asset gateway serves asset %s only", app_errors.ErrInvalidRequest, p.cfg.AssetClient.Asset)
+	}
+	if start.IsZero() || end.IsZero() || !start.Before(end) {
+		return model.ImportSummary{}, fmt.Errorf("%w: start must be before end", app_errors.ErrInvalidRequest)
+	}
+
+	entries, err := p.assetGateway.Load(ctx, start, end)
+	if err != nil {
+		return model.ImportSummary{}, err
+	}
+
+	// the points go through the import, so that stored timestamps are handled by the dedup policy
+	summary, err := p.Import(ctx, asset, &entryRowReader{entries: entries})
+	if err != nil {
+		return summary, err
+	}
+	if summary.Accepted > 0 {
+		if err = p.detectAnomalies(ctx, asset, start, end); err != nil {
+			logger.ErrorCtx(ctx, "anomaly detection failed", zap.Error(err))
+		}
+	}
+	return summary, nil
+}
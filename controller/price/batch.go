@@ -0,0 +1,80 @@
+package price
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/erich/pricetracking/helper/app_errors"
+	"github.com/erich/pricetracking/model"
+)
+
+const (
+	defaultBatchWorkers    = 4
+	defaultBatchMaxQueries = 50
+)
+
+// BatchFind implements PriceDataController.
+func (p *priceDataController) BatchFind(ctx context.Context, queries []model.NamedQuery) (map[string]model.QueryResult, error) {
+	ctx, span := p.tracer.Start(ctx, "priceController.BatchFind")
+	defer span.End()
+
+	maxQueries := p.cfg.Batch.MaxQueries
+	if maxQueries <= 0 {
+		maxQueries = defaultBatchMaxQueries
+	}
+	if len(queries) > maxQueries {
+		return nil, fmt.Errorf("%w: at most %d queries are allowed per batch", app_errors.ErrInvalidRequest, maxQueries)
+	}
+	names := make(map[string]bool, len(queries))
+	for _, q := range queries {
+		if q.Name == "" {
+			return nil, fmt.Errorf("%w: query name is required", app_errors.ErrInvalidRequest)
+		}
+		if names[q.Name] {
+			return nil, fmt.Errorf("%w: duplicate query name %q", app_errors.ErrInvalidRequest, q.Name)
+		}
+		names[q.Name] = true
+	}
+
+	workers := p.cfg.Batch.Workers
+	if workers <= 0 {
+		workers = defaultBatchWorkers
+	}
+
+	results := make([]model.QueryResult, len(queries))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(queries); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = p.findNamed(ctx, queries[i])
+			}
+		}()
+	}
+	for i := range queries {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	byName := make(map[string]model.QueryResult, len(queries))
+	for i, q := range queries {
+		byName[q.Name] = results[i]
+	}
+	return byName, nil
+}
+
+func (p *priceDataController) findNamed(ctx context.Context, q model.NamedQuery) model.QueryResult {
+	if q.Err != nil {
+		return model.QueryResult{Err: q.Err}
+	}
+	if err := ctx.Err(); err != nil {
+		return model.QueryResult{Err: err}
+	}
+
+	entries, unit, err := p.find(ctx, q.Query)
+	return model.QueryResult{Entries: entries, Unit: unit, Err: err}
+}
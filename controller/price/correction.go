@@ -0,0 +1,94 @@
+package price
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/erich/pricetracking/helper/app_errors"
+	"github.com/erich/pricetracking/model"
+)
+
+// Correct implements PriceDataController.
+func (p *priceDataController) Correct(ctx context.Context, req model.CorrectionRequest) (model.AuditEntry, error) {
+	ctx, span := p.tracer.Start(ctx, "priceController.Correct")
+	defer span.End()
+
+	if math.IsNaN(req.Value) || math.IsInf(req.Value, 0) {
+		return model.AuditEntry{}, fmt.Errorf("%w: value is not a finite number", app_errors.ErrInvalidRequest)
+	}
+	return p.saveCorrection(ctx, req, model.CorrectionAction_CORRECT)
+}
+
+// Delete implements PriceDataController.
+func (p *priceDataController) Delete(ctx context.Context, req model.CorrectionRequest) (model.AuditEntry, error) {
+	ctx, span := p.tracer.Start(ctx, "priceController.Delete")
+	defer span.End()
+
+	return p.saveCorrection(ctx, req, model.CorrectionAction_DELETE)
+}
+
+// saveCorrection overrides or tombstones the stored price point and records the previous value in the audit trail
+func (p *priceDataController) saveCorrection(ctx context.Context, req model.CorrectionRequest, action model.CorrectionAction) (model.AuditEntry, error) {
+	if req.Time.IsZero() {
+		return model.AuditEntry{}, fmt.Errorf("%w: time is required", app_errors.ErrInvalidRequest)
+	}
+	if req.User == "" || req.Reason == "" {
+		return model.AuditEntry{}, fmt.Errorf("%w: user and reason are required", app_errors.ErrInvalidRequest)
+	}
+
+	asset := p.resolveAsset(req.Asset)
+	// mongo stores milliseconds
+	t := req.Time.Truncate(time.Millisecond).UTC()
+
+	point, err := p.priceRepo.FindPoint(ctx, asset, t)
+	if err != nil {
+		return model.AuditEntry{}, err
+	}
+	if point == nil {
+		return model.AuditEntry{}, fmt.Errorf("%w: no price point of asset %s at %s", app_errors.ErrNotFound, asset, t.Format(time.RFC3339))
+	}
+
+	// the repository corrects the previous value if the point is corrected concurrently
+	previous, err := p.correctionRepo.Get(ctx, asset, t)
+	if err != nil {
+		return model.AuditEntry{}, err
+	}
+	previousValue := model.CorrectedValue(point.Value, previous)
+	if action == model.CorrectionAction_DELETE && previousValue == nil {
+		return model.AuditEntry{}, fmt.Errorf("%w: price point is already deleted", app_errors.ErrNotFound)
+	}
+
+	now := time.Now().UTC()
+	correction := model.Correction{
+		Asset:      asset,
+		Time:       t,
+		Value:      req.Value,
+		Deleted:    action == model.CorrectionAction_DELETE,
+		User:       req.User,
+		OnBehalfOf: req.OnBehalfOf,
+		Reason:     req.Reason,
+		UpdatedAt:  now,
+	}
+	audit := model.AuditEntry{
+		Asset:         asset,
+		Time:          t,
+		Action:        action,
+		User:          req.User,
+		OnBehalfOf:    req.OnBehalfOf,
+		Reason:        req.Reason,
+		PreviousValue: previousValue,
+		CreatedAt:     now,
+	}
+	if action == model.CorrectionAction_CORRECT {
+		audit.NewValue = &req.Value
+	}
+
+	audit, err = p.correctionRepo.Save(ctx, correction, audit, point.Value)
+	if err != nil {
+		return model.AuditEntry{}, err
+	}
+	p.forecasts.invalidate(asset)
+	return audit, nil
+}
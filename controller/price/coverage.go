@@ -0,0 +1,153 @@
+package price
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/erich/pricetracking/helper/app_errors"
+	"github.com/erich/pricetracking/helper/metric"
+	"github.com/erich/pricetracking/model"
+)
+
+const day = 24 * time.Hour
+
+// GetCoverage implements PriceDataController.
+func (p *priceDataController) GetCoverage(ctx context.Context, req model.CoverageRequest) (model.Coverage, error) {
+	ctx, span := p.tracer.Start(ctx, "priceController.GetCoverage")
+	defer span.End()
+
+	if req.StartTime.IsZero() || req.EndTime.IsZero() || !req.StartTime.Before(req.EndTime) {
+		return model.Coverage{}, fmt.Errorf("%w: start must be before end", app_errors.ErrInvalidRequest)
+	}
+	req.Asset = p.resolveAsset(req.Asset)
+	return p.coverage(ctx, req, time.Now())
+}
+
+// coverage computes the report from the stored vendor points, the range after now is not expected to be covered yet
+func (p *priceDataController) coverage(ctx context.Context, req model.CoverageRequest, now time.Time) (model.Coverage, error) {
+	query := model.Query{
+		StartTime:   req.StartTime,
+		EndTime:     req.EndTime,
+		Asset:       req.Asset,
+		Uncorrected: true,
+	}
+	var times []time.Time
+	err := p.priceRepo.IterateRaw(ctx, query, func(entry model.Entry) error {
+		times = append(times, entry.Time)
+		return nil
+	})
+	if err != nil {
+		return model.Coverage{}, err
+	}
+
+	end := req.EndTime
+	if now.Before(end) {
+		end = now
+	}
+
+	cov := model.Coverage{
+		Asset:   req.Asset,
+		Cadence: p.cfg.Coverage.Cadence * time.Minute,
+	}
+	if cov.Cadence <= 0 {
+		cov.Cadence = inferCadence(times)
+	}
+	minGap := req.MinGap
+	if minGap <= 0 {
+		minGap = p.cfg.Coverage.MinGap * time.Minute
+	}
+	if minGap <= 0 {
+		minGap = 2 * cov.Cadence
+	}
+
+	cov.Gaps = findGaps(times, req.StartTime, end, minGap)
+	cov.Days, cov.Percent = dailyCoverage(times, req.StartTime, end, cov.Cadence)
+	return cov, nil
+}
+
+// updateCoverageGauge sets the coverage gauge of the asset to the coverage of the configured window before end
+func (p *priceDataController) updateCoverageGauge(ctx context.Context, asset string, end time.Time) error {
+	window := p.cfg.Coverage.GaugeWindow * time.Hour
+	metrics := metric.GetBusinessMetrics()
+	if window <= 0 || metrics == nil {
+		return nil
+	}
+
+	cov, err := p.coverage(ctx, model.CoverageRequest{Asset: asset, StartTime: end.Add(-window), EndTime: end}, end)
+	if err != nil {
+		return err
+	}
+	metrics.SetCoverage(asset, cov.Percent)
+	return nil
+}
+
+// inferCadence returns the median time between consecutive points, or 0 if there are less than 2 points
+func inferCadence(times []time.Time) time.Duration {
+	deltas := make([]time.Duration, 0, len(times))
+	for i := 1; i < len(times); i++ {
+		if delta := times[i].Sub(times[i-1]); delta > 0 {
+			deltas = append(deltas, delta)
+		}
+	}
+	if len(deltas) == 0 {
+		return 0
+	}
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i] < deltas[j] })
+	return deltas[len(deltas)/2]
+}
+
+// findGaps returns the periods longer than minGap without points, including the ones at the range bounds
+func findGaps(times []time.Time, start time.Time, end time.Time, minGap time.Duration) []model.Gap {
+	var gaps []model.Gap
+	prev := start
+	for _, t := range times {
+		if t.Sub(prev) > minGap {
+			gaps = append(gaps, model.Gap{Start: prev, End: t})
+		}
+		prev = t
+	}
+	if end.Sub(prev) > minGap {
+		gaps = append(gaps, model.Gap{Start: prev, End: end})
+	}
+	return gaps
+}
+
+// dailyCoverage returns the coverage per UTC day and of the whole range, the expected points are unknown without cadence
+func dailyCoverage(times []time.Time, start time.Time, end time.Time, cadence time.Duration) ([]model.DayCoverage, float64) {
+	var days []model.DayCoverage
+	var points, expected int64
+	i := 0
+	for d := start.UTC().Truncate(day); d.Before(end); d = d.Add(day) {
+		from, to := d, d.Add(day)
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+
+		cov := model.DayCoverage{Day: d}
+		for ; i < len(times) && times[i].Before(to); i++ {
+			cov.Points++
+		}
+		if cadence > 0 {
+			cov.Expected = int64(to.Sub(from) / cadence)
+		}
+		cov.Percent = coveragePercent(cov.Points, cov.Expected)
+
+		days = append(days, cov)
+		points += cov.Points
+		expected += cov.Expected
+	}
+	return days, coveragePercent(points, expected)
+}
+
+func coveragePercent(points int64, expected int64) float64 {
+	if expected == 0 {
+		return 0
+	}
+	return math.Min(100, float64(points)/float64(expected)*100)
+}
@@ -0,0 +1,187 @@
+package price
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/erich/pricetracking/model"
+)
+
+// parquet row groups are flushed to the output every exportRowGroupSize rows
+const exportRowGroupSize = 10000
+
+// exportRow is a single row of the export file
+type exportRow struct {
+	Time        time.Time `json:"time" parquet:"time,timestamp(millisecond)"`
+	Asset       string    `json:"asset" parquet:"asset,dict"`
+	Unit        string    `json:"unit" parquet:"unit,dict"`
+	Window      string    `json:"window" parquet:"window,dict"`
+	Aggregation string    `json:"aggregation" parquet:"aggregation,dict"`
+	Value       float64   `json:"value" parquet:"value"`
+}
+
+var exportColumns = []string{"time", "asset", "unit", "window", "aggregation", "value"}
+
+type exportEncoder interface {
+	Write(row exportRow) error
+	Close() error
+}
+
+// Export implements PriceDataController.
+func (p *priceDataController) Export(ctx context.Context, req model.ExportRequest, w io.Writer) error {
+	ctx, span := p.tracer.Start(ctx, "priceController.Export")
+	defer span.End()
+
+	req.Query.Asset = p.resolveAsset(req.Query.Asset)
+	meta, err := p.exportMetadata(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	// windows are exported like FindData serves them, converted and transformed
+	var entries []model.Entry
+	if !req.Raw {
+		var unit model.PriceUnit
+		entries, unit, err = p.find(ctx, req.Query)
+		if err != nil {
+			return err
+		}
+		meta.Unit = unit.String()
+	}
+
+	enc, err := newExportEncoder(req.Format, meta, w)
+	if err != nil {
+		return err
+	}
+
+	write := func(entry model.Entry) error {
+		return enc.Write(exportRow{
+			Time:        entry.Time,
+			Asset:       meta.Asset,
+			Unit:        meta.Unit,
+			Window:      meta.Window,
+			Aggregation: meta.Aggregation,
+			Value:       entry.Value,
+		})
+	}
+
+	if req.Raw {
+		err = p.priceRepo.IterateRaw(ctx, req.Query, write)
+	} else {
+		for _, entry := range entries {
+			if err = write(entry); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func (p *priceDataController) exportMetadata(ctx context.Context, req model.ExportRequest) (model.ExportMetadata, error) {
+	meta := model.ExportMetadata{
+		Asset:       req.Query.Asset,
+		Window:      "raw",
+		Aggregation: "none",
+	}
+	metadata, err := p.assetMetadata(ctx, req.Query.Asset)
+	if err != nil {
+		return model.ExportMetadata{}, err
+	}
+	if metadata != nil {
+		meta.Unit = metadata.PriceUnit().String()
+	}
+	if !req.Raw {
+		meta.Window = req.Query.Window()
+		meta.Aggregation = string(req.Query.Aggregation)
+	}
+	return meta, nil
+}
+
+func newExportEncoder(format model.ExportFormat, meta model.ExportMetadata, w io.Writer) (exportEncoder, error) {
+	switch format {
+	case model.ExportFormat_CSV:
+		return newCsvEncoder(w)
+	case model.ExportFormat_NDJSON:
+		return &ndjsonEncoder{enc: json.NewEncoder(w)}, nil
+	case model.ExportFormat_PARQUET:
+		return newParquetEncoder(meta, w), nil
+	default:
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func newCsvEncoder(w io.Writer) (*csvEncoder, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportColumns); err != nil {
+		return nil, err
+	}
+	return &csvEncoder{w: cw}, nil
+}
+
+func (e *csvEncoder) Write(row exportRow) error {
+	return e.w.Write([]string{
+		row.Time.UTC().Format(time.RFC3339),
+		row.Asset,
+		row.Unit,
+		row.Window,
+		row.Aggregation,
+		strconv.FormatFloat(row.Value, 'f', -1, 64),
+	})
+}
+
+func (e *csvEncoder) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) Write(row exportRow) error {
+	return e.enc.Encode(row)
+}
+
+func (e *ndjsonEncoder) Close() error {
+	return nil
+}
+
+// parquetEncoder writes the series metadata into the file key/value metadata as well as into the columns
+type parquetEncoder struct {
+	w *parquet.GenericWriter[exportRow]
+}
+
+func newParquetEncoder(meta model.ExportMetadata, w io.Writer) *parquetEncoder {
+	return &parquetEncoder{
+		w: parquet.NewGenericWriter[exportRow](w,
+			parquet.MaxRowsPerRowGroup(exportRowGroupSize),
+			parquet.KeyValueMetadata("asset", meta.Asset),
+			parquet.KeyValueMetadata("unit", meta.Unit),
+			parquet.KeyValueMetadata("window", meta.Window),
+			parquet.KeyValueMetadata("aggregation", meta.Aggregation),
+		),
+	}
+}
+
+func (e *parquetEncoder) Write(row exportRow) error {
+	_, err := e.w.Write([]exportRow{row})
+	return err
+}
+
+func (e *parquetEncoder) Close() error {
+	return e.w.Close()
+}
@@ -0,0 +1,289 @@
+package price
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/erich/pricetracking/config"
+	"github.com/erich/pricetracking/helper/app_errors"
+	"github.com/erich/pricetracking/model"
+)
+
+const (
+	defaultForecastHorizon        = 24 * time.Hour
+	defaultForecastConfidence     = 0.95
+	defaultForecastHistorySeasons = 4
+	maxForecastHorizon            = 1000
+	// bounds the cost of fitting, e.g. a weekly season of 5 minute windows
+	maxForecastSeasonLength = 7 * 24 * 12
+
+	defaultForecastCacheSize = 256
+	// the history of a cached forecast ends at the window it was computed in
+	defaultForecastCacheTTL = time.Hour
+)
+
+// smoothing parameters searched when fitting the model
+var (
+	forecastAlphas = []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9}
+	forecastBetas  = []float64{0.01, 0.05, 0.1, 0.2}
+	forecastGammas = []float64{0.05, 0.1, 0.2, 0.4, 0.6}
+)
+
+// forecastCache keeps the recently used forecasts until the points of their asset change or they expire,
+// the least recently used one is evicted once it is full
+type forecastCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[model.ForecastRequest]*list.Element
+	lru     *list.List // of *cachedForecast, most recently used first
+}
+
+type cachedForecast struct {
+	req      model.ForecastRequest
+	points   []model.ForecastPoint
+	cachedAt time.Time
+}
+
+func newForecastCache(cfg config.Forecast) *forecastCache {
+	size, ttl := cfg.CacheSize, cfg.CacheTTL*time.Minute
+	if size <= 0 {
+		size = defaultForecastCacheSize
+	}
+	if ttl <= 0 {
+		ttl = defaultForecastCacheTTL
+	}
+	return &forecastCache{
+		size:    size,
+		ttl:     ttl,
+		entries: map[model.ForecastRequest]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+func (c *forecastCache) get(req model.ForecastRequest) ([]model.ForecastPoint, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[req]
+	if !ok {
+		return nil, false
+	}
+	cached := element.Value.(*cachedForecast)
+	if time.Since(cached.cachedAt) > c.ttl {
+		c.remove(element)
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return cached.points, true
+}
+
+func (c *forecastCache) put(req model.ForecastRequest, points []model.ForecastPoint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[req]; ok {
+		c.remove(element)
+	}
+	c.entries[req] = c.lru.PushFront(&cachedForecast{req: req, points: points, cachedAt: time.Now()})
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// invalidate drops the forecasts of the asset, after its points changed
+func (c *forecastCache) invalidate(asset string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for req, element := range c.entries {
+		if req.Asset == asset {
+			c.remove(element)
+		}
+	}
+}
+
+func (c *forecastCache) remove(element *list.Element) {
+	delete(c.entries, element.Value.(*cachedForecast).req)
+	c.lru.Remove(element)
+}
+
+// Forecast implements PriceDataController.
+func (p *priceDataController) Forecast(ctx context.Context, req model.ForecastRequest) ([]model.ForecastPoint, error) {
+	ctx, span := p.tracer.Start(ctx, "priceController.Forecast")
+	defer span.End()
+
+	window := req.Window()
+	if req.Seasonality == model.Seasonality_NONE {
+		req.Seasonality = model.Seasonality_DAY
+	}
+	var season time.Duration
+	switch req.Seasonality {
+	case model.Seasonality_DAY:
+		season = day
+	case model.Seasonality_WEEK:
+		season = 7 * day
+	default:
+		return nil, fmt.Errorf("%w: forecasts support daily and weekly seasonality", app_errors.ErrInvalidRequest)
+	}
+	if window <= 0 || season%window != 0 || season/window < 2 {
+		return nil, fmt.Errorf("%w: window must divide the season into at least 2 windows", app_errors.ErrInvalidRequest)
+	}
+	seasonLength := int(season / window)
+	if seasonLength > maxForecastSeasonLength {
+		return nil, fmt.Errorf("%w: window is too small for the seasonality", app_errors.ErrInvalidRequest)
+	}
+
+	if req.Horizon == 0 {
+		req.Horizon = int(math.Max(1, float64(defaultForecastHorizon/window)))
+	}
+	if req.Horizon < 0 || req.Horizon > maxForecastHorizon {
+		return nil, fmt.Errorf("%w: horizon must be within [1, %d]", app_errors.ErrInvalidRequest, maxForecastHorizon)
+	}
+	if req.Confidence == 0 {
+		req.Confidence = defaultForecastConfidence
+	}
+	if !(req.Confidence > 0 && req.Confidence < 1) {
+		return nil, fmt.Errorf("%w: confidence must be within (0, 1)", app_errors.ErrInvalidRequest)
+	}
+	req.Asset = p.resolveAsset(req.Asset)
+
+	if points, ok := p.forecasts.get(req); ok {
+		return points, nil
+	}
+
+	seasons := p.cfg.Forecast.HistorySeasons
+	if seasons < 2 {
+		seasons = defaultForecastHistorySeasons
+	}
+	end := time.Now().UTC().Truncate(window)
+	history, err := p.priceRepo.Find(ctx, model.Query{
+		StartTime:      end.Add(-time.Duration(seasons) * season),
+		EndTime:        end,
+		WindowUnit:     req.WindowUnit,
+		WindowInterval: req.WindowInterval,
+		Aggregation:    model.Aggregation_AVG,
+		Asset:          req.Asset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	values := regularize(history, window)
+	if len(values) < 2*seasonLength {
+		return nil, fmt.Errorf("%w: not enough history, at least 2 seasons are needed", app_errors.ErrInvalidRequest)
+	}
+
+	hw := fitHoltWinters(values, seasonLength)
+	z := math.Sqrt2 * math.Erfinv(req.Confidence)
+	last := history[len(history)-1].Time
+	points := hw.forecast(req.Horizon, z, last, window)
+
+	p.forecasts.put(req, points)
+	return points, nil
+}
+
+// regularize returns the values of the buckets on a regular grid, missing buckets carry the previous value forward
+func regularize(entries []model.Entry, window time.Duration) []float64 {
+	if len(entries) == 0 {
+		return nil
+	}
+	values := []float64{entries[0].Value}
+	next := entries[0].Time.Add(window)
+	for _, entry := range entries[1:] {
+		for ; next.Before(entry.Time); next = next.Add(window) {
+			values = append(values, values[len(values)-1])
+		}
+		values = append(values, entry.Value)
+		next = entry.Time.Add(window)
+	}
+	return values
+}
+
+// holtWinters is an additive Holt-Winters model after fitting
+type holtWinters struct {
+	alpha, beta, gamma float64
+	level, trend       float64
+	seasonal           []float64 // indexed by position within the season
+	n                  int       // number of fitted values
+	sigma              float64   // standard deviation of the one-step errors
+}
+
+// fitHoltWinters searches the smoothing parameters with the lowest one-step squared error
+func fitHoltWinters(values []float64, seasonLength int) *holtWinters {
+	var best *holtWinters
+	bestSSE := math.Inf(1)
+	for _, alpha := range forecastAlphas {
+		for _, beta := range forecastBetas {
+			for _, gamma := range forecastGammas {
+				hw := &holtWinters{alpha: alpha, beta: beta, gamma: gamma}
+				if sse := hw.fit(values, seasonLength); sse < bestSSE {
+					best, bestSSE = hw, sse
+				}
+			}
+		}
+	}
+	return best
+}
+
+// fit initializes the components from the first two seasons, smooths the remaining values and returns the squared error
+func (hw *holtWinters) fit(values []float64, m int) float64 {
+	first, second := mean(values[:m]), mean(values[m:2*m])
+	hw.level = first
+	hw.trend = (second - first) / float64(m)
+	hw.seasonal = make([]float64, m)
+	for i := 0; i < m; i++ {
+		hw.seasonal[i] = values[i] - first
+	}
+
+	var sse float64
+	for t := m; t < len(values); t++ {
+		s := hw.seasonal[t%m]
+		err := values[t] - (hw.level + hw.trend + s)
+		sse += err * err
+
+		level := hw.alpha*(values[t]-s) + (1-hw.alpha)*(hw.level+hw.trend)
+		hw.trend = hw.beta*(level-hw.level) + (1-hw.beta)*hw.trend
+		hw.seasonal[t%m] = hw.gamma*(values[t]-level) + (1-hw.gamma)*s
+		hw.level = level
+	}
+	hw.n = len(values)
+	hw.sigma = math.Sqrt(sse / float64(len(values)-m))
+	return sse
+}
+
+// forecast returns the next horizon windows after last, the interval is z standard deviations of the h-step error
+func (hw *holtWinters) forecast(horizon int, z float64, last time.Time, window time.Duration) []model.ForecastPoint {
+	m := len(hw.seasonal)
+	points := make([]model.ForecastPoint, horizon)
+	variance := 1.0
+	for h := 1; h <= horizon; h++ {
+		if h > 1 {
+			j := h - 1
+			c := hw.alpha * (1 + float64(j)*hw.beta)
+			if j%m == 0 {
+				c += hw.gamma
+			}
+			variance += c * c
+		}
+		value := hw.level + float64(h)*hw.trend + hw.seasonal[(hw.n+h-1)%m]
+		spread := z * hw.sigma * math.Sqrt(variance)
+		points[h-1] = model.ForecastPoint{
+			Time:  last.Add(time.Duration(h) * window),
+			Value: value,
+			Lower: value - spread,
+			Upper: value + spread,
+		}
+	}
+	return points
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,204 @@
+package price
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/erich/pricetracking/model"
+)
+
+const (
+	defaultImportBatchSize     = 1000
+	defaultImportMaxRejections = 100
+)
+
+// importer collects the rows of one import into batches and keeps the summary
+type importer struct {
+	p       *priceDataController
+	asset   string
+	policy  model.DedupPolicy
+	maxRejs int
+	batch   []model.ImportRow
+	summary model.ImportSummary
+}
+
+// Import implements PriceDataController.
+func (p *priceDataController) Import(ctx context.Context, asset string, rows model.ImportRowReader) (model.ImportSummary, error) {
+	ctx, span := p.tracer.Start(ctx, "priceController.Import")
+	defer span.End()
+
+	policy, err := p.dedupPolicy()
+	if err != nil {
+		return model.ImportSummary{}, err
+	}
+
+	batchSize := p.cfg.Import.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultImportBatchSize
+	}
+	maxRejs := p.cfg.Import.MaxRejections
+	if maxRejs <= 0 {
+		maxRejs = defaultImportMaxRejections
+	}
+
+	imp := &importer{
+		p:       p,
+		asset:   p.resolveAsset(asset),
+		policy:  policy,
+		maxRejs: maxRejs,
+	}
+
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imp.summary, err
+		}
+
+		if reason := validateImportRow(row); reason != "" {
+			imp.reject(row.Row, reason)
+			continue
+		}
+		if rule, reason := p.validator.Check(row.Entry, time.Now()); rule != "" {
+			imp.reject(row.Row, rule+": "+reason)
+			continue
+		}
+		// mongo stores milliseconds, truncate so that duplicates are detected
+		row.Entry.Time = row.Entry.Time.Truncate(time.Millisecond).UTC()
+		row.Entry.Asset = imp.asset
+		imp.batch = append(imp.batch, row)
+
+		if len(imp.batch) >= batchSize {
+			if err := imp.flush(ctx); err != nil {
+				return imp.summary, err
+			}
+		}
+	}
+
+	if err := imp.flush(ctx); err != nil {
+		return imp.summary, err
+	}
+	return imp.summary, nil
+}
+
+func (p *priceDataController) dedupPolicy() (model.DedupPolicy, error) {
+	switch policy := model.DedupPolicy(p.cfg.Import.DedupPolicy); policy {
+	case "":
+		return model.DedupPolicy_SKIP, nil
+	case model.DedupPolicy_SKIP, model.DedupPolicy_OVERWRITE, model.DedupPolicy_ALLOW:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown import dedup policy: %s", policy)
+	}
+}
+
+// validateImportRow returns the reason why the row is rejected, or an empty string if it is valid
+func validateImportRow(row model.ImportRow) string {
+	switch {
+	case row.Err != nil:
+		return row.Err.Error()
+	case row.Entry.Time.IsZero():
+		return "missing time"
+	case math.IsNaN(row.Entry.Value) || math.IsInf(row.Entry.Value, 0):
+		return "value is not a finite number"
+	}
+	return ""
+}
+
+func (imp *importer) reject(row int64, reason string) {
+	imp.summary.Rejected++
+	if len(imp.summary.Rejections) < imp.maxRejs {
+		imp.summary.Rejections = append(imp.summary.Rejections, model.ImportRejection{Row: row, Reason: reason})
+	}
+}
+
+// flush applies the dedup policy to the current batch and persists the remaining rows
+func (imp *importer) flush(ctx context.Context) error {
+	if len(imp.batch) == 0 {
+		return nil
+	}
+	rows := imp.dedupBatch()
+	imp.batch = imp.batch[:0]
+
+	times := make([]time.Time, len(rows))
+	for i, row := range rows {
+		times[i] = row.Entry.Time
+	}
+
+	switch imp.policy {
+	case model.DedupPolicy_SKIP:
+		stored, err := imp.p.priceRepo.FindTimes(ctx, imp.asset, times)
+		if err != nil {
+			return err
+		}
+		storedSet := make(map[int64]bool, len(stored))
+		for _, t := range stored {
+			storedSet[t.UnixNano()] = true
+		}
+		kept := rows[:0]
+		for _, row := range rows {
+			if storedSet[row.Entry.Time.UnixNano()] {
+				imp.reject(row.Row, "timestamp already stored")
+				continue
+			}
+			kept = append(kept, row)
+		}
+		rows = kept
+	}
+
+	if len(rows) == 0 {
+		return nil
+	}
+	entries := make([]model.Entry, len(rows))
+	for i, row := range rows {
+		entries[i] = row.Entry
+	}
+	store := imp.p.priceRepo.Create
+	if imp.policy == model.DedupPolicy_OVERWRITE {
+		// the new points are stored before the older ones are deleted
+		store = func(ctx context.Context, entries []model.Entry) error {
+			return imp.p.priceRepo.Replace(ctx, imp.asset, entries)
+		}
+	}
+	if err := store(ctx, entries); err != nil {
+		return err
+	}
+	imp.p.forecasts.invalidate(imp.asset)
+	imp.summary.Accepted += int64(len(entries))
+	return nil
+}
+
+// dedupBatch removes rows with the same timestamp within the batch, skip keeps the first and overwrite the last one
+func (imp *importer) dedupBatch() []model.ImportRow {
+	if imp.policy == model.DedupPolicy_ALLOW {
+		return append([]model.ImportRow(nil), imp.batch...)
+	}
+
+	keep := make(map[int64]int, len(imp.batch))
+	for i, row := range imp.batch {
+		key := row.Entry.Time.UnixNano()
+		prev, seen := keep[key]
+		switch {
+		case !seen:
+			keep[key] = i
+		case imp.policy == model.DedupPolicy_OVERWRITE:
+			imp.reject(imp.batch[prev].Row, "duplicate timestamp within import")
+			keep[key] = i
+		default:
+			imp.reject(row.Row, "duplicate timestamp within import")
+		}
+	}
+
+	rows := make([]model.ImportRow, 0, len(keep))
+	for i, row := range imp.batch {
+		if keep[row.Entry.Time.UnixNano()] == i {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
@@ -2,13 +2,19 @@ package price
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"time"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 
 	"github.com/erich/pricetracking/config"
 	"github.com/erich/pricetracking/gateway"
+	"github.com/erich/pricetracking/helper/app_errors"
+	"github.com/erich/pricetracking/helper/logger"
+	"github.com/erich/pricetracking/helper/metric"
 	"github.com/erich/pricetracking/model"
 	priceData "github.com/erich/pricetracking/repository/pricedata"
 )
@@ -17,25 +23,87 @@ type priceDataController struct {
 	cfg            *config.Config
 	priceRepo      priceData.PriceDataMongoRepo
 	lastUpdateRepo priceData.LastUpdateMongoRepo
+	correctionRepo priceData.CorrectionMongoRepo
+	quarantineRepo priceData.QuarantineMongoRepo
+	anomalyRepo    priceData.AnomalyMongoRepo
+	alertRepo      priceData.AlertRuleMongoRepo
+	outboxRepo     priceData.AlertOutboxMongoRepo
+	metadataRepo   priceData.AssetMetadataMongoRepo
 	assetGateway   gateway.AssetClient
+	webhookGateway gateway.WebhookClient
+	validator      *validator
+	detector       *anomalyDetector
+	forecasts      *forecastCache
 	tracer         trace.Tracer
 }
 
 type PriceDataController interface {
 	Load(ctx context.Context) error
-	Find(ctx context.Context, query model.Query) ([]model.Entry, error)
+	// Backfill loads the range from the asset gateway and stores it like an import, without moving the last update
+	Backfill(ctx context.Context, asset string, start time.Time, end time.Time) (model.ImportSummary, error)
+	// Find returns the windowed series of the query and its unit
+	Find(ctx context.Context, query model.Query) ([]model.Entry, model.PriceUnit, error)
+	// BatchFind executes the named queries concurrently and returns the result or error of every query by name
+	BatchFind(ctx context.Context, queries []model.NamedQuery) (map[string]model.QueryResult, error)
+	// Summarize returns the statistics of the price points in the query range, the window and aggregation are ignored
+	Summarize(ctx context.Context, query model.Query) (model.Summary, error)
+	// Export streams the result of the export request to w as a file in the requested format
+	Export(ctx context.Context, req model.ExportRequest, w io.Writer) error
+	// Import validates the price points read from rows and persists them with the configured dedup policy
+	Import(ctx context.Context, asset string, rows model.ImportRowReader) (model.ImportSummary, error)
+	// Correct overrides the value of a stored price point
+	Correct(ctx context.Context, req model.CorrectionRequest) (model.AuditEntry, error)
+	// Delete tombstones a stored price point
+	Delete(ctx context.Context, req model.CorrectionRequest) (model.AuditEntry, error)
+	// ListQuarantined returns a page of the loaded price points which failed validation and the token of the next page
+	ListQuarantined(ctx context.Context, filter model.QuarantineFilter) ([]model.QuarantinedEntry, string, error)
+	// ReleaseQuarantined stores the quarantined price points, or drops them if discard is set
+	ReleaseQuarantined(ctx context.Context, ids []string, discard bool) (int64, error)
+	// ListAnomalies returns the stored price points flagged by the anomaly detection
+	ListAnomalies(ctx context.Context, filter model.AnomalyFilter) ([]model.Anomaly, error)
+	// GetCoverage reports the sampling cadence, the gaps and the daily coverage of the stored series
+	GetCoverage(ctx context.Context, req model.CoverageRequest) (model.Coverage, error)
+	// CreateAlertRule stores a rule which is evaluated after every load
+	CreateAlertRule(ctx context.Context, rule model.AlertRule) (model.AlertRule, error)
+	// ListAlertRules returns the alert rules of the asset, or of all assets if asset is empty
+	ListAlertRules(ctx context.Context, asset string) ([]model.AlertRule, error)
+	DeleteAlertRule(ctx context.Context, id string) error
+	// DeliverAlerts posts the due webhook deliveries of the firing alerts, failed ones are retried by later calls
+	DeliverAlerts(ctx context.Context) error
+	// Forecast returns the forecast of the next windows with prediction intervals, cached until the points of the asset change
+	Forecast(ctx context.Context, req model.ForecastRequest) ([]model.ForecastPoint, error)
+	// GetAssetMetadata returns the currency and energy unit the prices of the asset are stored in
+	GetAssetMetadata(ctx context.Context, asset string) (model.AssetMetadata, error)
+	SetAssetMetadata(ctx context.Context, metadata model.AssetMetadata) (model.AssetMetadata, error)
 }
 
 func NewPriceDataController(cfg *config.Config,
 	priceRepo priceData.PriceDataMongoRepo,
 	lastUpdateRepo priceData.LastUpdateMongoRepo,
+	correctionRepo priceData.CorrectionMongoRepo,
+	quarantineRepo priceData.QuarantineMongoRepo,
+	anomalyRepo priceData.AnomalyMongoRepo,
+	alertRepo priceData.AlertRuleMongoRepo,
+	outboxRepo priceData.AlertOutboxMongoRepo,
+	metadataRepo priceData.AssetMetadataMongoRepo,
 	assetGateway gateway.AssetClient,
+	webhookGateway gateway.WebhookClient,
 ) PriceDataController {
 	return &priceDataController{
 		cfg:            cfg,
 		priceRepo:      priceRepo,
 		lastUpdateRepo: lastUpdateRepo,
+		correctionRepo: correctionRepo,
+		quarantineRepo: quarantineRepo,
+		anomalyRepo:    anomalyRepo,
+		alertRepo:      alertRepo,
+		outboxRepo:     outboxRepo,
+		metadataRepo:   metadataRepo,
 		assetGateway:   assetGateway,
+		webhookGateway: webhookGateway,
+		validator:      newValidator(cfg.Validation),
+		detector:       newAnomalyDetector(cfg.Anomaly),
+		forecasts:      newForecastCache(cfg.Forecast),
 		tracer:         otel.Tracer(cfg.GetTracerName()),
 	}
 }
@@ -60,22 +128,80 @@ func (p *priceDataController) Load(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	//2. persist into mongo
+	for i := range assets {
+		assets[i].Asset = p.cfg.AssetClient.Asset
+	}
+
+	//2. persist into mongo, points failing validation are quarantined
 	if len(assets) > 0 {
-		err = p.priceRepo.Create(ctx, assets)
-		if err != nil {
-			return err
+		valid, quarantined := p.validator.Split(assets, end)
+		if len(quarantined) > 0 {
+			if err = p.quarantineRepo.Create(ctx, quarantined); err != nil {
+				return err
+			}
+			logger.WarnCtx(ctx, "price points quarantined", zap.Int("count", len(quarantined)))
+			if metrics := metric.GetBusinessMetrics(); metrics != nil {
+				metrics.RecordQuarantined(ctx, int64(len(quarantined)))
+			}
+		}
+		if len(valid) > 0 {
+			if err = p.priceRepo.Create(ctx, valid); err != nil {
+				return err
+			}
+			p.forecasts.invalidate(p.cfg.AssetClient.Asset)
 		}
 		//3. update lastUpdate
-		return p.lastUpdateRepo.Update(ctx, end)
+		if err = p.lastUpdateRepo.Update(ctx, end); err != nil {
+			return err
+		}
+		//4. flag anomalies, the loaded points are stored already so a failure does not fail the load
+		if err = p.detectAnomalies(ctx, p.cfg.AssetClient.Asset, start, end); err != nil {
+			logger.ErrorCtx(ctx, "anomaly detection failed", zap.Error(err))
+		}
+		//5. refresh the coverage gauge
+		if err = p.updateCoverageGauge(ctx, p.cfg.AssetClient.Asset, end); err != nil {
+			logger.ErrorCtx(ctx, "coverage gauge update failed", zap.Error(err))
+		}
+		//6. evaluate the alert rules with the latest loaded point
+		if len(valid) > 0 {
+			latest := valid[0]
+			for _, entry := range valid[1:] {
+				if entry.Time.After(latest.Time) {
+					latest = entry
+				}
+			}
+			if err = p.evaluateAlerts(ctx, p.cfg.AssetClient.Asset, latest); err != nil {
+				logger.ErrorCtx(ctx, "alert evaluation failed", zap.Error(err))
+			}
+		}
 	}
 	return nil
 }
 
 // Find implements PriceDataController.
-func (p *priceDataController) Find(ctx context.Context, query model.Query) ([]model.Entry, error) {
+func (p *priceDataController) Find(ctx context.Context, query model.Query) ([]model.Entry, model.PriceUnit, error) {
 	ctx, span := p.tracer.Start(ctx, "priceController.Find")
 	defer span.End()
 
-	return p.priceRepo.Find(ctx, query)
+	return p.find(ctx, query)
+}
+
+// Summarize implements PriceDataController.
+func (p *priceDataController) Summarize(ctx context.Context, query model.Query) (model.Summary, error) {
+	ctx, span := p.tracer.Start(ctx, "priceController.Summarize")
+	defer span.End()
+
+	if query.StartTime.IsZero() || query.EndTime.IsZero() || !query.StartTime.Before(query.EndTime) {
+		return model.Summary{}, fmt.Errorf("%w: start must be before end", app_errors.ErrInvalidRequest)
+	}
+	query.Asset = p.resolveAsset(query.Asset)
+	return p.priceRepo.Summarize(ctx, query)
+}
+
+// resolveAsset defaults an empty asset to the asset loaded from the asset gateway
+func (p *priceDataController) resolveAsset(asset string) string {
+	if asset == "" {
+		return p.cfg.AssetClient.Asset
+	}
+	return asset
 }
@@ -0,0 +1,163 @@
+package price
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/erich/pricetracking/helper/app_errors"
+	"github.com/erich/pricetracking/model"
+)
+
+const (
+	defaultQuarantinePageSize = 100
+	maxQuarantinePageSize     = 1000
+)
+
+// ListQuarantined implements PriceDataController.
+func (p *priceDataController) ListQuarantined(ctx context.Context, filter model.QuarantineFilter) ([]model.QuarantinedEntry, string, error) {
+	ctx, span := p.tracer.Start(ctx, "priceController.ListQuarantined")
+	defer span.End()
+
+	if filter.PageSize <= 0 {
+		filter.PageSize = defaultQuarantinePageSize
+	}
+	if filter.PageSize > maxQuarantinePageSize {
+		filter.PageSize = maxQuarantinePageSize
+	}
+
+	entries, err := p.quarantineRepo.List(ctx, filter)
+	if err != nil {
+		return nil, "", err
+	}
+	var nextPageToken string
+	if len(entries) == filter.PageSize {
+		nextPageToken = entries[len(entries)-1].ID
+	}
+	return entries, nextPageToken, nil
+}
+
+// ReleaseQuarantined implements PriceDataController.
+func (p *priceDataController) ReleaseQuarantined(ctx context.Context, ids []string, discard bool) (int64, error) {
+	ctx, span := p.tracer.Start(ctx, "priceController.ReleaseQuarantined")
+	defer span.End()
+
+	if len(ids) == 0 {
+		return 0, fmt.Errorf("%w: ids are required", app_errors.ErrInvalidRequest)
+	}
+
+	quarantined, err := p.quarantineRepo.Get(ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+	if len(quarantined) == 0 {
+		return 0, fmt.Errorf("%w: no quarantined price points with the given ids", app_errors.ErrNotFound)
+	}
+
+	// the ids increase, within the release the points of a timestamp are deduplicated in quarantine order
+	sort.Slice(quarantined, func(i, j int) bool { return quarantined[i].ID < quarantined[j].ID })
+	released := make([]string, len(quarantined))
+	for i, q := range quarantined {
+		released[i] = q.ID
+	}
+	if !discard {
+		if err := p.releaseQuarantined(ctx, quarantined); err != nil {
+			return 0, err
+		}
+	}
+	if err := p.quarantineRepo.Delete(ctx, released); err != nil {
+		return 0, err
+	}
+	return int64(len(released)), nil
+}
+
+// releaseQuarantined stores the quarantined points through the import dedup policy, the points it skips are
+// released without being stored. Skip and overwrite store the points of a retried release once, allow skips the
+// points a failed release already stored.
+func (p *priceDataController) releaseQuarantined(ctx context.Context, quarantined []model.QuarantinedEntry) error {
+	policy, err := p.dedupPolicy()
+	if err != nil {
+		return err
+	}
+
+	var assets []string
+	byAsset := map[string][]model.QuarantinedEntry{}
+	var invalid []string
+	for _, q := range quarantined {
+		if math.IsNaN(q.Value) || math.IsInf(q.Value, 0) {
+			invalid = append(invalid, q.ID)
+			continue
+		}
+		asset := p.resolveAsset(q.Asset)
+		if _, ok := byAsset[asset]; !ok {
+			assets = append(assets, asset)
+		}
+		byAsset[asset] = append(byAsset[asset], q)
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("%w: the values of the quarantined points %s are not finite numbers, discard them instead",
+			app_errors.ErrInvalidRequest, strings.Join(invalid, ", "))
+	}
+
+	for _, asset := range assets {
+		entries := byAsset[asset]
+		if policy == model.DedupPolicy_ALLOW {
+			if entries, err = p.withoutReleased(ctx, asset, entries); err != nil {
+				return err
+			}
+			if len(entries) == 0 {
+				continue
+			}
+			ids := make([]string, len(entries))
+			for i, q := range entries {
+				ids[i] = q.ID
+			}
+			if err := p.quarantineRepo.MarkReleasing(ctx, ids); err != nil {
+				return err
+			}
+		}
+
+		imp := &importer{p: p, asset: asset, policy: policy}
+		for i, q := range entries {
+			entry := q.ToEntry()
+			entry.Asset = asset
+			imp.batch = append(imp.batch, model.ImportRow{Row: int64(i), Entry: entry})
+		}
+		if err := imp.flush(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withoutReleased removes the points whose release was interrupted after they were stored at their timestamp
+func (p *priceDataController) withoutReleased(ctx context.Context, asset string, entries []model.QuarantinedEntry) ([]model.QuarantinedEntry, error) {
+	var times []time.Time
+	for _, q := range entries {
+		if q.Releasing {
+			times = append(times, q.Time)
+		}
+	}
+	if len(times) == 0 {
+		return entries, nil
+	}
+	stored, err := p.priceRepo.FindTimes(ctx, asset, times)
+	if err != nil {
+		return nil, err
+	}
+	storedSet := make(map[int64]bool, len(stored))
+	for _, t := range stored {
+		storedSet[t.UnixNano()] = true
+	}
+
+	kept := make([]model.QuarantinedEntry, 0, len(entries))
+	for _, q := range entries {
+		if !q.Releasing || !storedSet[q.Time.UnixNano()] {
+			kept = append(kept, q)
+		}
+	}
+	return kept, nil
+}
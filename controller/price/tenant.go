@@ -0,0 +1,226 @@
+package price
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/erich/pricetracking/helper/app_errors"
+	"github.com/erich/pricetracking/helper/tenant"
+	"github.com/erich/pricetracking/model"
+)
+
+// tenantController routes every call to the controller of the tenant in the context. The tenant controllers
+// have their own repositories, gateway and caches, so that the data of the tenants stays apart.
+type tenantController struct {
+	tenants map[string]PriceDataController
+	ids     []string
+}
+
+// NewTenantController returns a controller routing by the tenant id of the context, see tenant.WithTenant
+func NewTenantController(tenants map[string]PriceDataController) PriceDataController {
+	ids := make([]string, 0, len(tenants))
+	for id := range tenants {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return &tenantController{tenants: tenants, ids: ids}
+}
+
+func (t *tenantController) controller(ctx context.Context) (PriceDataController, error) {
+	id, ok := tenant.FromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("%w: the tenant is required", app_errors.ErrInvalidRequest)
+	}
+	ctl, ok := t.tenants[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: tenant %q", app_errors.ErrNotFound, id)
+	}
+	return ctl, nil
+}
+
+// Load loads the tenant of the context, or every tenant one after the other without one,
+// e.g. for the bootstrap load. A failed tenant does not stop the loads of the others.
+func (t *tenantController) Load(ctx context.Context) error {
+	if _, ok := tenant.FromContext(ctx); ok {
+		ctl, err := t.controller(ctx)
+		if err != nil {
+			return err
+		}
+		return ctl.Load(ctx)
+	}
+
+	var errs []error
+	for _, id := range t.ids {
+		if err := t.tenants[id].Load(tenant.WithTenant(ctx, id)); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DeliverAlerts delivers the alerts of the tenant of the context, or of every tenant one after the other without one
+func (t *tenantController) DeliverAlerts(ctx context.Context) error {
+	if _, ok := tenant.FromContext(ctx); ok {
+		ctl, err := t.controller(ctx)
+		if err != nil {
+			return err
+		}
+		return ctl.DeliverAlerts(ctx)
+	}
+
+	var errs []error
+	for _, id := range t.ids {
+		if err := t.tenants[id].DeliverAlerts(tenant.WithTenant(ctx, id)); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *tenantController) Backfill(ctx context.Context, asset string, start time.Time, end time.Time) (model.ImportSummary, error) {
+	ctl, err := t.controller(ctx)
+	if err != nil {
+		return model.ImportSummary{}, err
+	}
+	return ctl.Backfill(ctx, asset, start, end)
+}
+
+func (t *tenantController) Find(ctx context.Context, query model.Query) ([]model.Entry, model.PriceUnit, error) {
+	ctl, err := t.controller(ctx)
+	if err != nil {
+		return nil, model.PriceUnit{}, err
+	}
+	return ctl.Find(ctx, query)
+}
+
+func (t *tenantController) BatchFind(ctx context.Context, queries []model.NamedQuery) (map[string]model.QueryResult, error) {
+	ctl, err := t.controller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ctl.BatchFind(ctx, queries)
+}
+
+func (t *tenantController) Summarize(ctx context.Context, query model.Query) (model.Summary, error) {
+	ctl, err := t.controller(ctx)
+	if err != nil {
+		return model.Summary{}, err
+	}
+	return ctl.Summarize(ctx, query)
+}
+
+func (t *tenantController) Export(ctx context.Context, req model.ExportRequest, w io.Writer) error {
+	ctl, err := t.controller(ctx)
+	if err != nil {
+		return err
+	}
+	return ctl.Export(ctx, req, w)
+}
+
+func (t *tenantController) Import(ctx context.Context, asset string, rows model.ImportRowReader) (model.ImportSummary, error) {
+	ctl, err := t.controller(ctx)
+	if err != nil {
+		return model.ImportSummary{}, err
+	}
+	return ctl.Import(ctx, asset, rows)
+}
+
+func (t *tenantController) Correct(ctx context.Context, req model.CorrectionRequest) (model.AuditEntry, error) {
+	ctl, err := t.controller(ctx)
+	if err != nil {
+		return model.AuditEntry{}, err
+	}
+	return ctl.Correct(ctx, req)
+}
+
+func (t *tenantController) Delete(ctx context.Context, req model.CorrectionRequest) (model.AuditEntry, error) {
+	ctl, err := t.controller(ctx)
+	if err != nil {
+		return model.AuditEntry{}, err
+	}
+	return ctl.Delete(ctx, req)
+}
+
+func (t *tenantController) ListQuarantined(ctx context.Context, filter model.QuarantineFilter) ([]model.QuarantinedEntry, string, error) {
+	ctl, err := t.controller(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return ctl.ListQuarantined(ctx, filter)
+}
+
+func (t *tenantController) ReleaseQuarantined(ctx context.Context, ids []string, discard bool) (int64, error) {
+	ctl, err := t.controller(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return ctl.ReleaseQuarantined(ctx, ids, discard)
+}
+
+func (t *tenantController) ListAnomalies(ctx context.Context, filter model.AnomalyFilter) ([]model.Anomaly, error) {
+	ctl, err := t.controller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ctl.ListAnomalies(ctx, filter)
+}
+
+func (t *tenantController) GetCoverage(ctx context.Context, req model.CoverageRequest) (model.Coverage, error) {
+	ctl, err := t.controller(ctx)
+	if err != nil {
+		return model.Coverage{}, err
+	}
+	return ctl.GetCoverage(ctx, req)
+}
+
+func (t *tenantController) CreateAlertRule(ctx context.Context, rule model.AlertRule) (model.AlertRule, error) {
+	ctl, err := t.controller(ctx)
+	if err != nil {
+		return model.AlertRule{}, err
+	}
+	return ctl.CreateAlertRule(ctx, rule)
+}
+
+func (t *tenantController) ListAlertRules(ctx context.Context, asset string) ([]model.AlertRule, error) {
+	ctl, err := t.controller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ctl.ListAlertRules(ctx, asset)
+}
+
+func (t *tenantController) DeleteAlertRule(ctx context.Context, id string) error {
+	ctl, err := t.controller(ctx)
+	if err != nil {
+		return err
+	}
+	return ctl.DeleteAlertRule(ctx, id)
+}
+
+func (t *tenantController) Forecast(ctx context.Context, req model.ForecastRequest) ([]model.ForecastPoint, error) {
+	ctl, err := t.controller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ctl.Forecast(ctx, req)
+}
+
+func (t *tenantController) GetAssetMetadata(ctx context.Context, asset string) (model.AssetMetadata, error) {
+	ctl, err := t.controller(ctx)
+	if err != nil {
+		return model.AssetMetadata{}, err
+	}
+	return ctl.GetAssetMetadata(ctx, asset)
+}
+
+func (t *tenantController) SetAssetMetadata(ctx context.Context, metadata model.AssetMetadata) (model.AssetMetadata, error) {
+	ctl, err := t.controller(ctx)
+	if err != nil {
+		return model.AssetMetadata{}, err
+	}
+	return ctl.SetAssetMetadata(ctx, metadata)
+}
@@ -0,0 +1,184 @@
+package price
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/erich/pricetracking/model"
+)
+
+// find executes the query, converts it into the query unit and applies its transforms,
+// the lead-in buckets the transforms need are fetched before start
+func (p *priceDataController) find(ctx context.Context, query model.Query) ([]model.Entry, model.PriceUnit, error) {
+	query.Asset = p.resolveAsset(query.Asset)
+	start := query.StartTime
+	window := query.WindowDuration()
+	query.StartTime = start.Add(-transformLeadIn(query.Transforms, window))
+
+	entries, unit, err := p.findConverted(ctx, query)
+	if err != nil || len(query.Transforms) == 0 {
+		return entries, unit, err
+	}
+
+	entries = applyTransforms(entries, query.Transforms, start, window)
+	// drop the lead-in, keeping the bucket start falls into
+	for len(entries) > 0 && !entries[0].Time.Add(window).After(start) {
+		entries = entries[1:]
+	}
+	return entries, transformedUnit(unit, query.Transforms), nil
+}
+
+// transformLeadIn returns how far before start the chained transforms look back
+func transformLeadIn(transforms []model.Transform, window time.Duration) time.Duration {
+	var leadIn time.Duration
+	for _, t := range transforms {
+		switch {
+		case t.Seasonality != model.Seasonality_NONE:
+			leadIn += t.Seasonality.MaxDuration()
+		case t.Type.IsReturn():
+			leadIn += time.Duration(t.Periods) * window
+		case t.Type == model.TransformType_CUMULATIVE_RETURN:
+			// relative to the first bucket of the range
+		default:
+			leadIn += time.Duration(t.Periods-1) * window
+		}
+	}
+	return leadIn
+}
+
+// applyTransforms derives the series from the windowed one. Empty windows are missing from the series, so that the
+// references and rolling windows are found by time; the first rolling buckets are computed over the available buckets.
+func applyTransforms(entries []model.Entry, transforms []model.Transform, start time.Time, window time.Duration) []model.Entry {
+	for _, t := range transforms {
+		values := make([]float64, len(entries))
+		for i, entry := range entries {
+			values[i] = entry.Value
+		}
+
+		switch {
+		case t.Type.IsReturn():
+			entries = returns(entries, t, window)
+		case t.Type == model.TransformType_CUMULATIVE_RETURN:
+			entries = cumulativeReturns(entries, start, window)
+		case t.Type == model.TransformType_EMA:
+			entries = append([]model.Entry(nil), entries...)
+			ema(entries, values, t.Periods)
+		default:
+			derived := append([]model.Entry(nil), entries...)
+			from := 0
+			for i, entry := range derived {
+				// the rolling window covers the buckets of the last periods windows
+				for !entries[from].Time.After(entry.Time.Add(-time.Duration(t.Periods) * window)) {
+					from++
+				}
+				derived[i].Value = transformValue(t, values[from:i+1])
+			}
+			entries = derived
+		}
+	}
+	return entries
+}
+
+// returns compares every bucket against its reference bucket, periods windows or the season earlier.
+// Buckets without a reference or an undefined return are dropped.
+func returns(entries []model.Entry, t model.Transform, window time.Duration) []model.Entry {
+	byTime := make(map[int64]float64, len(entries))
+	for _, entry := range entries {
+		byTime[entry.Time.UnixNano()] = entry.Value
+	}
+
+	derived := make([]model.Entry, 0, len(entries))
+	for _, entry := range entries {
+		reference := entry.Time.Add(-time.Duration(t.Periods) * window)
+		if t.Seasonality != model.Seasonality_NONE {
+			reference = t.Seasonality.Reference(entry.Time)
+		}
+		ref, ok := byTime[reference.UnixNano()]
+		if !ok {
+			continue
+		}
+
+		value, ok := periodReturn(t.Type, entry.Value, ref)
+		if !ok {
+			continue
+		}
+		entry.Value = value
+		derived = append(derived, entry)
+	}
+	return derived
+}
+
+// periodReturn returns the change of value against ref, percent returns need a non zero and log returns a positive reference
+func periodReturn(transformType model.TransformType, value float64, ref float64) (float64, bool) {
+	switch transformType {
+	case model.TransformType_DIFF:
+		return value - ref, true
+	case model.TransformType_PCT_RETURN:
+		if ref == 0 {
+			return 0, false
+		}
+		return (value - ref) / math.Abs(ref) * 100, true
+	case model.TransformType_LOG_RETURN:
+		if value <= 0 || ref <= 0 {
+			return 0, false
+		}
+		return math.Log(value / ref), true
+	default:
+		return 0, false
+	}
+}
+
+// cumulativeReturns returns the percent change of every bucket against the first bucket of the range with a non
+// zero value, the buckets before it have no defined return and are dropped
+func cumulativeReturns(entries []model.Entry, start time.Time, window time.Duration) []model.Entry {
+	first := 0
+	for first < len(entries) && (!entries[first].Time.Add(window).After(start) || entries[first].Value == 0) {
+		first++
+	}
+
+	derived := make([]model.Entry, 0, len(entries)-first)
+	for _, entry := range entries[first:] {
+		entry.Value, _ = periodReturn(model.TransformType_PCT_RETURN, entry.Value, entries[first].Value)
+		derived = append(derived, entry)
+	}
+	return derived
+}
+
+// transformValue computes the rolling transform over the values of the window, the last one is the bucket's
+func transformValue(t model.Transform, window []float64) float64 {
+	switch t.Type {
+	case model.TransformType_SMA:
+		mean, _ := meanStd(window)
+		return mean
+	case model.TransformType_ROLLING_MIN:
+		min := window[0]
+		for _, v := range window[1:] {
+			min = math.Min(min, v)
+		}
+		return min
+	case model.TransformType_ROLLING_MAX:
+		max := window[0]
+		for _, v := range window[1:] {
+			max = math.Max(max, v)
+		}
+		return max
+	case model.TransformType_ROLLING_STDDEV:
+		_, std := meanStd(window)
+		return std
+	default:
+		return window[len(window)-1]
+	}
+}
+
+// ema replaces the values of the entries with the exponential moving average, seeded with the first value
+func ema(entries []model.Entry, values []float64, periods int) {
+	alpha := 2 / float64(periods+1)
+	for i := range entries {
+		if i == 0 {
+			entries[i].Value = values[0]
+			continue
+		}
+		entries[i].Value = alpha*values[i] + (1-alpha)*entries[i-1].Value
+	}
+}
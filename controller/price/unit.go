@@ -0,0 +1,257 @@
+package price
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/erich/pricetracking/helper/app_errors"
+	"github.com/erich/pricetracking/model"
+)
+
+const defaultFxMaxStaleness = 72 * time.Hour
+
+// GetAssetMetadata implements PriceDataController.
+func (p *priceDataController) GetAssetMetadata(ctx context.Context, asset string) (model.AssetMetadata, error) {
+	ctx, span := p.tracer.Start(ctx, "priceController.GetAssetMetadata")
+	defer span.End()
+
+	asset = p.resolveAsset(asset)
+	metadata, err := p.assetMetadata(ctx, asset)
+	if err != nil {
+		return model.AssetMetadata{}, err
+	}
+	if metadata == nil {
+		return model.AssetMetadata{}, fmt.Errorf("%w: no metadata of asset %s", app_errors.ErrNotFound, asset)
+	}
+	return *metadata, nil
+}
+
+// SetAssetMetadata implements PriceDataController.
+func (p *priceDataController) SetAssetMetadata(ctx context.Context, metadata model.AssetMetadata) (model.AssetMetadata, error) {
+	ctx, span := p.tracer.Start(ctx, "priceController.SetAssetMetadata")
+	defer span.End()
+
+	unit, err := withPriceUnit(metadata.Currency, metadata.Unit)
+	if err != nil {
+		return model.AssetMetadata{}, err
+	}
+	metadata.Currency, metadata.Unit = unit.Currency, unit.Unit
+	if metadata.Currency == "" {
+		return model.AssetMetadata{}, fmt.Errorf("%w: currency is required", app_errors.ErrInvalidRequest)
+	}
+	if !model.KnownCurrency(metadata.Currency) {
+		return model.AssetMetadata{}, fmt.Errorf("%w: unknown currency %q, expected an ISO 4217 code or a currency symbol", app_errors.ErrInvalidRequest, metadata.Currency)
+	}
+	if _, ok := model.EnergyUnitFactor(metadata.Unit); !ok {
+		return model.AssetMetadata{}, fmt.Errorf("%w: unknown energy unit %q", app_errors.ErrInvalidRequest, metadata.Unit)
+	}
+	metadata.Asset = p.resolveAsset(metadata.Asset)
+
+	if err := p.metadataRepo.Save(ctx, metadata); err != nil {
+		return model.AssetMetadata{}, err
+	}
+	p.forecasts.invalidate(metadata.Asset)
+	return metadata, nil
+}
+
+// assetMetadata returns the stored metadata of the asset, the loaded asset falls back to the configured unit
+func (p *priceDataController) assetMetadata(ctx context.Context, asset string) (*model.AssetMetadata, error) {
+	metadata, err := p.metadataRepo.Get(ctx, asset)
+	if err != nil || metadata != nil {
+		return metadata, err
+	}
+	if asset == p.cfg.AssetClient.Asset && p.cfg.AssetClient.Unit != "" {
+		unit, err := withPriceUnit(p.cfg.AssetClient.Currency, p.cfg.AssetClient.Unit)
+		if err != nil {
+			return nil, err
+		}
+		return &model.AssetMetadata{
+			Asset:    asset,
+			Currency: unit.Currency,
+			Unit:     unit.Unit,
+		}, nil
+	}
+	return nil, nil
+}
+
+// conversion converts the prices of an asset into the currency and unit of a query
+type conversion struct {
+	target model.PriceUnit
+	factor float64
+	// from and to are the ISO currencies of the stored and the target unit, they differ if an fx series is needed
+	from, to string
+}
+
+// conversion returns the conversion of the query, the stored unit is kept without a currency and unit in the query
+func (p *priceDataController) conversion(ctx context.Context, query model.Query) (conversion, error) {
+	metadata, err := p.assetMetadata(ctx, query.Asset)
+	if err != nil {
+		return conversion{}, err
+	}
+	var unit model.PriceUnit
+	if metadata != nil {
+		unit = metadata.PriceUnit()
+	}
+	if query.Currency == "" && query.Unit == "" {
+		return conversion{target: unit, factor: 1}, nil
+	}
+	if metadata == nil {
+		return conversion{}, fmt.Errorf("%w: unit of asset %s is unknown", app_errors.ErrInvalidRequest, query.Asset)
+	}
+
+	target := unit
+	if query.Unit != "" {
+		parsed, err := withPriceUnit(query.Currency, query.Unit)
+		if err != nil {
+			return conversion{}, err
+		}
+		target.Unit = parsed.Unit
+		if parsed.Currency != "" {
+			target.Currency = parsed.Currency
+		}
+	} else {
+		target.Currency = query.Currency
+	}
+
+	// prices are per energy unit, so the factor is inverse to the size of the unit
+	from, ok := model.EnergyUnitFactor(unit.Unit)
+	if !ok {
+		return conversion{}, fmt.Errorf("unknown energy unit %q of asset %s", unit.Unit, query.Asset)
+	}
+	to, ok := model.EnergyUnitFactor(target.Unit)
+	if !ok {
+		return conversion{}, fmt.Errorf("%w: unknown energy unit %q", app_errors.ErrInvalidRequest, target.Unit)
+	}
+	if !model.KnownCurrency(target.Currency) {
+		return conversion{}, fmt.Errorf("%w: unknown currency %q", app_errors.ErrInvalidRequest, target.Currency)
+	}
+	fromCurrency, fromScale := model.CurrencyBase(unit.Currency)
+	toCurrency, toScale := model.CurrencyBase(target.Currency)
+	return conversion{
+		target: target,
+		factor: to / from * fromScale / toScale,
+		from:   fromCurrency,
+		to:     toCurrency,
+	}, nil
+}
+
+// withPriceUnit returns the price unit of a unit which may carry its currency, e.g. ¢/kWh, and the currency.
+// A currency given both ways must agree.
+func withPriceUnit(currency string, unit string) (model.PriceUnit, error) {
+	parsed := model.ParsePriceUnit(unit)
+	switch {
+	case parsed.Currency == "":
+		parsed.Currency = currency
+	case currency != "" && currency != parsed.Currency:
+		return model.PriceUnit{}, fmt.Errorf("%w: currency %q conflicts with the unit %q", app_errors.ErrInvalidRequest, currency, unit)
+	}
+	return parsed, nil
+}
+
+// findConverted returns the windows of the query in the query currency and unit, and the unit of the result.
+// Scaling commutes with the aggregations, so the windows are scaled. A conversion through an fx series converts
+// the points with the rate at their time before they are windowed, as the rate changes within a window.
+func (p *priceDataController) findConverted(ctx context.Context, query model.Query) ([]model.Entry, model.PriceUnit, error) {
+	conv, err := p.conversion(ctx, query)
+	if err != nil {
+		return nil, model.PriceUnit{}, err
+	}
+
+	if conv.from == conv.to {
+		entries, err := p.priceRepo.Find(ctx, query)
+		if err != nil {
+			return nil, model.PriceUnit{}, err
+		}
+		for i := range entries {
+			entries[i].Value *= conv.factor
+		}
+		return entries, conv.target, nil
+	}
+
+	var points []model.Entry
+	err = p.priceRepo.IterateRaw(ctx, query, func(entry model.Entry) error {
+		points = append(points, entry)
+		return nil
+	})
+	if err != nil || len(points) == 0 {
+		return nil, conv.target, err
+	}
+	rates, err := p.fxRates(ctx, query, points, conv.from, conv.to)
+	if err != nil {
+		return nil, model.PriceUnit{}, err
+	}
+	for i := range points {
+		points[i].Value *= conv.factor * rates[i]
+	}
+	entries, err := windows(points, query)
+	if err != nil {
+		return nil, model.PriceUnit{}, err
+	}
+	return entries, conv.target, nil
+}
+
+// fxRates returns the rate from one currency into the other for every entry, taken from the latest point of the
+// fx series at or before the entry
+func (p *priceDataController) fxRates(ctx context.Context, query model.Query, entries []model.Entry, from string, to string) ([]float64, error) {
+	series, invert := "", false
+	for pair, asset := range p.cfg.Fx.Series {
+		switch {
+		case strings.EqualFold(pair, from+"/"+to):
+			series, invert = asset, false
+		case strings.EqualFold(pair, to+"/"+from):
+			series, invert = asset, true
+		}
+	}
+	if series == "" {
+		return nil, fmt.Errorf("%w: no fx series for %s/%s", app_errors.ErrInvalidRequest, from, to)
+	}
+
+	staleness := p.cfg.Fx.MaxStaleness * time.Hour
+	if staleness <= 0 {
+		staleness = defaultFxMaxStaleness
+	}
+	rateQuery := model.Query{
+		StartTime: entries[0].Time.Add(-staleness),
+		EndTime:   query.EndTime,
+		Asset:     series,
+	}
+	var fx []model.Entry
+	err := p.priceRepo.IterateRaw(ctx, rateQuery, func(entry model.Entry) error {
+		fx = append(fx, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rates := make([]float64, len(entries))
+	j := -1
+	for i, entry := range entries {
+		for j+1 < len(fx) && !fx[j+1].Time.After(entry.Time) {
+			j++
+		}
+		if j < 0 || entry.Time.Sub(fx[j].Time) > staleness || fx[j].Value == 0 {
+			return nil, fmt.Errorf("%w: no %s/%s rate at %s", app_errors.ErrNotFound, from, to, entry.Time.Format(time.RFC3339))
+		}
+		rates[i] = fx[j].Value
+		if invert {
+			rates[i] = 1 / fx[j].Value
+		}
+	}
+	return rates, nil
+}
+
+// transformedUnit returns the unit of the series after the transforms
+func transformedUnit(unit model.PriceUnit, transforms []model.Transform) model.PriceUnit {
+	for _, t := range transforms {
+		switch t.Type {
+		case model.TransformType_PCT_RETURN, model.TransformType_CUMULATIVE_RETURN:
+			unit = model.PercentUnit
+		case model.TransformType_LOG_RETURN:
+			unit = model.PriceUnit{}
+		}
+	}
+	return unit
+}
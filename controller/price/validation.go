@@ -0,0 +1,111 @@
+package price
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/erich/pricetracking/config"
+	"github.com/erich/pricetracking/model"
+)
+
+// names of the validation rules, they are recorded with the quarantined points
+const (
+	RuleNotFinite       = "not_finite"
+	RuleNegative        = "negative"
+	RuleZero            = "zero"
+	RuleOutOfRange      = "out_of_range"
+	RuleFutureTimestamp = "future_timestamp"
+)
+
+// validationRule returns the reason why the entry is rejected, or an empty string if it passes
+type validationRule func(entry model.Entry, now time.Time) string
+
+type namedRule struct {
+	name  string
+	check validationRule
+}
+
+// validator runs the configured data quality rules on loaded price points
+type validator struct {
+	rules []namedRule
+}
+
+func newValidator(cfg config.Validation) *validator {
+	available := map[string]validationRule{
+		RuleNotFinite: func(entry model.Entry, now time.Time) string {
+			if math.IsNaN(entry.Value) || math.IsInf(entry.Value, 0) {
+				return "value is not a finite number"
+			}
+			return ""
+		},
+		RuleNegative: func(entry model.Entry, now time.Time) string {
+			if entry.Value < 0 {
+				return "value is negative"
+			}
+			return ""
+		},
+		RuleZero: func(entry model.Entry, now time.Time) string {
+			if entry.Value == 0 {
+				return "value is zero"
+			}
+			return ""
+		},
+		RuleOutOfRange: func(entry model.Entry, now time.Time) string {
+			if entry.Value < cfg.MinValue || entry.Value > cfg.MaxValue {
+				return fmt.Sprintf("value %g is outside of [%g, %g]", entry.Value, cfg.MinValue, cfg.MaxValue)
+			}
+			return ""
+		},
+		RuleFutureTimestamp: func(entry model.Entry, now time.Time) string {
+			if entry.Time.After(now.Add(cfg.MaxFutureSkew * time.Second)) {
+				return fmt.Sprintf("timestamp %s is in the future", entry.Time.Format(time.RFC3339))
+			}
+			return ""
+		},
+	}
+
+	v := &validator{}
+	for _, name := range cfg.Rules {
+		check, ok := available[name]
+		if !ok {
+			log.Printf("unknown validation rule %q is ignored", name)
+			continue
+		}
+		v.rules = append(v.rules, namedRule{name: name, check: check})
+	}
+	return v
+}
+
+// Check returns the first rule which rejects the entry and the reason, or empty strings if the entry is valid
+func (v *validator) Check(entry model.Entry, now time.Time) (string, string) {
+	for _, rule := range v.rules {
+		if reason := rule.check(entry, now); reason != "" {
+			return rule.name, reason
+		}
+	}
+	return "", ""
+}
+
+// Split separates the valid entries from the ones which are quarantined
+func (v *validator) Split(entries []model.Entry, now time.Time) ([]model.Entry, []model.QuarantinedEntry) {
+	valid := make([]model.Entry, 0, len(entries))
+	var quarantined []model.QuarantinedEntry
+	for _, entry := range entries {
+		rule, reason := v.Check(entry, now)
+		if rule == "" {
+			valid = append(valid, entry)
+			continue
+		}
+		quarantined = append(quarantined, model.QuarantinedEntry{
+			Asset:         entry.Asset,
+			Time:          entry.Time,
+			Value:         entry.Value,
+			Rule:          rule,
+			Reason:        reason,
+			QuarantinedAt: now,
+		})
+	}
+	return valid, quarantined
+}
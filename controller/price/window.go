@@ -0,0 +1,72 @@
+package price
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/erich/pricetracking/helper/app_errors"
+	"github.com/erich/pricetracking/model"
+)
+
+// windowOrigin is the reference the windows are aligned to, the one of $dateTrunc
+var windowOrigin = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// windows groups the points, sorted by time, into the windows of the query and aggregates them the way the
+// aggregation pipeline of the repository does. Windows without points are missing.
+func windows(points []model.Entry, query model.Query) ([]model.Entry, error) {
+	window := query.WindowDuration()
+	if window <= 0 {
+		return nil, fmt.Errorf("%w: invalid window %s", app_errors.ErrInvalidRequest, query.Window())
+	}
+
+	var entries []model.Entry
+	var values [][]float64
+	for _, point := range points {
+		offset := point.Time.Sub(windowOrigin)
+		bin := offset / window * window
+		if offset < 0 && bin != offset {
+			bin -= window
+		}
+		start := windowOrigin.Add(bin)
+		if len(entries) == 0 || !entries[len(entries)-1].Time.Equal(start) {
+			entries = append(entries, model.Entry{Time: start})
+			values = append(values, nil)
+		}
+		values[len(values)-1] = append(values[len(values)-1], point.Value)
+	}
+
+	for i := range entries {
+		value, err := aggregate(query.Aggregation, values[i])
+		if err != nil {
+			return nil, err
+		}
+		entries[i].Value = value
+	}
+	return entries, nil
+}
+
+// aggregate returns the aggregation of the values of a window
+func aggregate(aggregation model.Aggregation, values []float64) (float64, error) {
+	result := values[0]
+	switch aggregation {
+	case model.Aggregation_MIN:
+		for _, v := range values[1:] {
+			result = math.Min(result, v)
+		}
+	case model.Aggregation_MAX:
+		for _, v := range values[1:] {
+			result = math.Max(result, v)
+		}
+	case model.Aggregation_SUM, model.Aggregation_AVG:
+		for _, v := range values[1:] {
+			result += v
+		}
+		if aggregation == model.Aggregation_AVG {
+			result /= float64(len(values))
+		}
+	default:
+		return 0, fmt.Errorf("%w: unknown aggregation %q", app_errors.ErrInvalidRequest, aggregation)
+	}
+	return result, nil
+}
@@ -3,10 +3,12 @@ package gateway
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/erich/pricetracking/config"
@@ -25,13 +27,31 @@ type TempEntry struct {
 
 const DATE_FORMAT = "2006-01-02T15:04:05"
 
+// ErrCircuitOpen is returned by Load while the asset api is considered down
+var ErrCircuitOpen = errors.New("asset api circuit is open")
+
+// CircuitState is the state of the circuit breaker in front of the asset api
+type CircuitState string
+
+const (
+	CircuitState_CLOSED    CircuitState = "closed"
+	CircuitState_OPEN      CircuitState = "open"
+	CircuitState_HALF_OPEN CircuitState = "half_open" // the cooldown passed, the next load probes the api
+)
+
 type assetClient struct {
 	cfg    *config.Config
 	client *http.Client
+
+	mu       sync.Mutex
+	failures int // consecutive failed loads
+	openedAt time.Time
 }
 
 type AssetClient interface {
 	Load(ctx context.Context, startTime time.Time, endTime time.Time) ([]model.Entry, error)
+	// CircuitState reports whether loads reach the asset api
+	CircuitState() CircuitState
 }
 
 func NewAssetClient(cfg *config.Config) (AssetClient, error) {
@@ -49,6 +69,46 @@ func NewAssetClient(cfg *config.Config) (AssetClient, error) {
 }
 
 func (ac *assetClient) Load(ctx context.Context, startTime time.Time, endTime time.Time) ([]model.Entry, error) {
+	if ac.CircuitState() == CircuitState_OPEN {
+		return []model.Entry{}, ErrCircuitOpen
+	}
+	entries, err := ac.load(ctx, startTime, endTime)
+	ac.record(err)
+	return entries, err
+}
+
+// CircuitState implements AssetClient.
+func (ac *assetClient) CircuitState() CircuitState {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	threshold := ac.cfg.AssetClient.CircuitThreshold
+	switch {
+	case threshold <= 0 || ac.failures < threshold:
+		return CircuitState_CLOSED
+	case time.Since(ac.openedAt) < ac.cfg.AssetClient.CircuitCooldown*time.Second:
+		return CircuitState_OPEN
+	default:
+		return CircuitState_HALF_OPEN
+	}
+}
+
+// record counts the consecutive failures, the circuit opens again if the probe of a half open circuit fails
+func (ac *assetClient) record(err error) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	if err == nil {
+		ac.failures = 0
+		return
+	}
+	ac.failures++
+	if threshold := ac.cfg.AssetClient.CircuitThreshold; threshold > 0 && ac.failures >= threshold {
+		ac.openedAt = time.Now()
+	}
+}
+
+func (ac *assetClient) load(ctx context.Context, startTime time.Time, endTime time.Time) ([]model.Entry, error) {
 	result := TempResult{}
 
 	params := url.Values{
@@ -56,7 +116,12 @@ func (ac *assetClient) Load(ctx context.Context, startTime time.Time, endTime ti
 		"end":   {endTime.Format(DATE_FORMAT)},
 	}
 	reqUrl := ac.cfg.AssetClient.ServerAddr + "?" + params.Encode()
-	resp, err := ac.client.Get(reqUrl)
+	// the request is cancelled with the load, e.g. on shutdown
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqUrl, nil)
+	if err != nil {
+		return []model.Entry{}, err
+	}
+	resp, err := ac.client.Do(req)
 	if err != nil {
 		return []model.Entry{}, err
 	}
@@ -0,0 +1,87 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/erich/pricetracking/config"
+	"github.com/erich/pricetracking/model"
+)
+
+const (
+	SIGNATURE_HEADER = "X-Signature"
+	TIMESTAMP_HEADER = "X-Signature-Timestamp"
+	EVENT_ID_HEADER  = "X-Event-Id"
+)
+
+type webhookClient struct {
+	cfg    *config.Config
+	client *http.Client
+}
+
+// WebhookClient posts alert events to the configured webhooks
+type WebhookClient interface {
+	// Post sends one delivery attempt of the event to the webhook and reports whether a failure may be retried
+	Post(ctx context.Context, url string, event model.AlertEvent) (bool, error)
+}
+
+func NewWebhookClient(cfg *config.Config) (WebhookClient, error) {
+	return &webhookClient{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Alert.Timeout * time.Second},
+	}, nil
+}
+
+// Post implements WebhookClient.
+func (wc *webhookClient) Post(ctx context.Context, url string, event model.AlertEvent) (bool, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return false, err
+	}
+	return wc.post(ctx, url, event.ID, body)
+}
+
+// post sends one delivery attempt and reports whether a failure may be retried
+func (wc *webhookClient) post(ctx context.Context, url string, eventID string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EVENT_ID_HEADER, eventID)
+	req.Header.Set(TIMESTAMP_HEADER, timestamp)
+	req.Header.Set(SIGNATURE_HEADER, "sha256="+wc.sign(timestamp, body))
+
+	resp, err := wc.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+}
+
+// sign returns the hex HMAC-SHA256 of "<timestamp>.<body>", receivers verify it with the shared secret
+func (wc *webhookClient) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(wc.cfg.Alert.Secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	priceDataApi "github.com/erich/api/pricedata/price_data/v1"
+	"github.com/erich/pricetracking/helper/app_errors"
+	"github.com/erich/pricetracking/mapper"
+	"github.com/erich/pricetracking/model"
+)
+
+var errMixedImportPayload = fmt.Errorf("%w: csv chunks and price data batches cannot be mixed", app_errors.ErrInvalidRequest)
+
+func (u *priceDataApiServer) ImportData(stream priceDataApi.PriceDataService_ImportDataServer) error {
+	ctx, span := u.tracer.Start(stream.Context(), "handler.ImportData")
+	defer span.End()
+
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return stream.SendAndClose(&priceDataApi.ImportDataResponse{})
+	}
+	if err != nil {
+		return err
+	}
+
+	rows := &importRowReader{stream: stream, next: first}
+	summary, err := u.priceCtl.Import(ctx, first.Asset, rows)
+	if err != nil {
+		return app_errors.ToGRPCError(err)
+	}
+	return stream.SendAndClose(mapper.ToImportDataResponse(summary))
+}
+
+// importRowReader reads the rows of an ImportData stream, either from price data batches or from csv chunks
+type importRowReader struct {
+	stream priceDataApi.PriceDataService_ImportDataServer
+	next   *priceDataApi.ImportDataRequest // received message which is not consumed yet
+
+	prices []*priceDataApi.PriceData
+	row    int64
+
+	csv       *csv.Reader
+	csvHeader bool // the header line was checked
+}
+
+// Next implements model.ImportRowReader.
+func (r *importRowReader) Next() (model.ImportRow, error) {
+	for {
+		if r.csv != nil {
+			return r.nextCsvRow()
+		}
+		if len(r.prices) > 0 {
+			price := r.prices[0]
+			r.prices = r.prices[1:]
+			r.row++
+			return model.ImportRow{Row: r.row, Entry: mapper.ToImportEntryModel(price)}, nil
+		}
+
+		msg, err := r.recv()
+		if err != nil {
+			return model.ImportRow{}, err
+		}
+		switch payload := msg.Payload.(type) {
+		case *priceDataApi.ImportDataRequest_Batch:
+			r.prices = payload.Batch.GetPrices()
+		case *priceDataApi.ImportDataRequest_CsvChunk:
+			if r.row > 0 {
+				return model.ImportRow{}, errMixedImportPayload
+			}
+			r.csv = csv.NewReader(&csvChunkReader{rows: r, buf: payload.CsvChunk})
+			r.csv.FieldsPerRecord = -1
+			r.csv.TrimLeadingSpace = true
+		}
+	}
+}
+
+func (r *importRowReader) recv() (*priceDataApi.ImportDataRequest, error) {
+	if r.next != nil {
+		msg := r.next
+		r.next = nil
+		return msg, nil
+	}
+	return r.stream.Recv()
+}
+
+// nextCsvRow parses the next "time,value" record, the optional header line is skipped
+func (r *importRowReader) nextCsvRow() (model.ImportRow, error) {
+	for {
+		record, err := r.csv.Read()
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return model.ImportRow{Row: int64(parseErr.Line), Err: parseErr.Err}, nil
+		}
+		if err != nil {
+			return model.ImportRow{}, err
+		}
+		line, _ := r.csv.FieldPos(0)
+
+		if !r.csvHeader {
+			r.csvHeader = true
+			if strings.EqualFold(strings.TrimSpace(record[0]), "time") {
+				continue
+			}
+		}
+
+		row := model.ImportRow{Row: int64(line)}
+		row.Entry, row.Err = parseCsvRecord(record)
+		return row, nil
+	}
+}
+
+func parseCsvRecord(record []string) (model.Entry, error) {
+	if len(record) != 2 {
+		return model.Entry{}, fmt.Errorf("expected 2 columns (time,value), got %d", len(record))
+	}
+
+	t, err := parseCsvTime(strings.TrimSpace(record[0]))
+	if err != nil {
+		return model.Entry{}, err
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+	if err != nil {
+		return model.Entry{}, fmt.Errorf("invalid value %q", record[1])
+	}
+	return model.Entry{Time: t, Value: value}, nil
+}
+
+// parseCsvTime accepts RFC3339 timestamps and unix seconds
+func parseCsvTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+	return t, nil
+}
+
+// csvChunkReader concatenates the csv chunks of the ImportData stream
+type csvChunkReader struct {
+	rows *importRowReader
+	buf  []byte
+}
+
+func (c *csvChunkReader) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		msg, err := c.rows.recv()
+		if err != nil {
+			return 0, err
+		}
+		chunk, ok := msg.Payload.(*priceDataApi.ImportDataRequest_CsvChunk)
+		if !ok {
+			return 0, errMixedImportPayload
+		}
+		c.buf = chunk.CsvChunk
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
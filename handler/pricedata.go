@@ -1,10 +1,14 @@
 package handler
 
 import (
+	"bufio"
 	"context"
 
 	priceDataApi "github.com/erich/api/pricedata/price_data/v1"
+	"github.com/erich/pricetracking/helper/app_errors"
+	"github.com/erich/pricetracking/helper/auth"
 	"github.com/erich/pricetracking/mapper"
+	"github.com/erich/pricetracking/model"
 )
 
 // Create implements v1.PhotographerServiceServer.
@@ -14,7 +18,7 @@ func (u *priceDataApiServer) LoadData(ctx context.Context, req *priceDataApi.Loa
 
 	err := u.priceCtl.Load(ctx)
 	if err != nil {
-		return nil, err
+		return nil, app_errors.ToGRPCError(err)
 	}
 	return &priceDataApi.LoadDataResponse{}, nil
 }
@@ -25,14 +29,229 @@ func (u *priceDataApiServer) FindData(ctx context.Context, req *priceDataApi.Fin
 
 	query, err := mapper.ToQueryModel(req.Query)
 	if err != nil {
-		return nil, err
+		return nil, app_errors.ToGRPCError(err)
 	}
-	entries, err := u.priceCtl.Find(ctx, query)
+	entries, unit, err := u.priceCtl.Find(ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, app_errors.ToGRPCError(err)
 	}
 
 	return &priceDataApi.FindDataResponse{
-		Prices: mapper.ToPriceDataProto(entries),
+		Prices:   mapper.ToPriceDataProto(entries),
+		Currency: unit.Currency,
+		Unit:     unit.Unit,
 	}, nil
 }
+
+func (u *priceDataApiServer) BatchFindData(ctx context.Context, req *priceDataApi.BatchFindDataRequest) (*priceDataApi.BatchFindDataResponse, error) {
+	ctx, span := u.tracer.Start(ctx, "handler.BatchFindData")
+	defer span.End()
+
+	results, err := u.priceCtl.BatchFind(ctx, mapper.ToNamedQueriesModel(req))
+	if err != nil {
+		return nil, app_errors.ToGRPCError(err)
+	}
+	return mapper.ToBatchFindDataResponse(results), nil
+}
+
+// size of the chunks streamed by ExportData
+const exportChunkSize = 64 * 1024
+
+func (u *priceDataApiServer) ExportData(req *priceDataApi.ExportDataRequest, stream priceDataApi.PriceDataService_ExportDataServer) error {
+	ctx, span := u.tracer.Start(stream.Context(), "handler.ExportData")
+	defer span.End()
+
+	exportReq, err := mapper.ToExportRequestModel(req)
+	if err != nil {
+		return app_errors.ToGRPCError(err)
+	}
+
+	w := bufio.NewWriterSize(&exportChunkWriter{stream: stream}, exportChunkSize)
+	if err := u.priceCtl.Export(ctx, exportReq, w); err != nil {
+		return app_errors.ToGRPCError(err)
+	}
+	return w.Flush()
+}
+
+// exportChunkWriter sends every write as an ExportDataResponse chunk
+type exportChunkWriter struct {
+	stream priceDataApi.PriceDataService_ExportDataServer
+}
+
+func (w *exportChunkWriter) Write(p []byte) (int, error) {
+	if err := w.stream.Send(&priceDataApi.ExportDataResponse{Chunk: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (u *priceDataApiServer) CorrectPrice(ctx context.Context, req *priceDataApi.CorrectPriceRequest) (*priceDataApi.CorrectPriceResponse, error) {
+	ctx, span := u.tracer.Start(ctx, "handler.CorrectPrice")
+	defer span.End()
+
+	audit, err := u.priceCtl.Correct(ctx, withAuditUser(ctx, mapper.ToCorrectionRequestModel(req.Asset, req.Time, req.Value, req.User, req.Reason)))
+	if err != nil {
+		return nil, app_errors.ToGRPCError(err)
+	}
+	return &priceDataApi.CorrectPriceResponse{Audit: mapper.ToAuditEntryProto(audit)}, nil
+}
+
+func (u *priceDataApiServer) DeletePrice(ctx context.Context, req *priceDataApi.DeletePriceRequest) (*priceDataApi.DeletePriceResponse, error) {
+	ctx, span := u.tracer.Start(ctx, "handler.DeletePrice")
+	defer span.End()
+
+	audit, err := u.priceCtl.Delete(ctx, withAuditUser(ctx, mapper.ToCorrectionRequestModel(req.Asset, req.Time, 0, req.User, req.Reason)))
+	if err != nil {
+		return nil, app_errors.ToGRPCError(err)
+	}
+	return &priceDataApi.DeletePriceResponse{Audit: mapper.ToAuditEntryProto(audit)}, nil
+}
+
+// withAuditUser records the identity of the client certificate as the user of the change, the user of the
+// request is kept as the user the change is made on behalf of. Without mutual TLS the user of the request is recorded.
+func withAuditUser(ctx context.Context, req model.CorrectionRequest) model.CorrectionRequest {
+	identity, ok := auth.ClientIdentityFromContext(ctx)
+	if !ok || identity.Name() == "" {
+		return req
+	}
+	req.User, req.OnBehalfOf = identity.Name(), req.User
+	return req
+}
+
+func (u *priceDataApiServer) ListQuarantined(ctx context.Context, req *priceDataApi.ListQuarantinedRequest) (*priceDataApi.ListQuarantinedResponse, error) {
+	ctx, span := u.tracer.Start(ctx, "handler.ListQuarantined")
+	defer span.End()
+
+	entries, nextPageToken, err := u.priceCtl.ListQuarantined(ctx, mapper.ToQuarantineFilterModel(req))
+	if err != nil {
+		return nil, app_errors.ToGRPCError(err)
+	}
+	return &priceDataApi.ListQuarantinedResponse{
+		Prices:        mapper.ToQuarantinedPriceProto(entries),
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+func (u *priceDataApiServer) ReleaseQuarantined(ctx context.Context, req *priceDataApi.ReleaseQuarantinedRequest) (*priceDataApi.ReleaseQuarantinedResponse, error) {
+	ctx, span := u.tracer.Start(ctx, "handler.ReleaseQuarantined")
+	defer span.End()
+
+	released, err := u.priceCtl.ReleaseQuarantined(ctx, req.Ids, req.Discard)
+	if err != nil {
+		return nil, app_errors.ToGRPCError(err)
+	}
+	return &priceDataApi.ReleaseQuarantinedResponse{Released: released}, nil
+}
+
+func (u *priceDataApiServer) ListAnomalies(ctx context.Context, req *priceDataApi.ListAnomaliesRequest) (*priceDataApi.ListAnomaliesResponse, error) {
+	ctx, span := u.tracer.Start(ctx, "handler.ListAnomalies")
+	defer span.End()
+
+	anomalies, err := u.priceCtl.ListAnomalies(ctx, mapper.ToAnomalyFilterModel(req))
+	if err != nil {
+		return nil, app_errors.ToGRPCError(err)
+	}
+	return &priceDataApi.ListAnomaliesResponse{Anomalies: mapper.ToAnomalyProto(anomalies)}, nil
+}
+
+func (u *priceDataApiServer) GetCoverage(ctx context.Context, req *priceDataApi.GetCoverageRequest) (*priceDataApi.GetCoverageResponse, error) {
+	ctx, span := u.tracer.Start(ctx, "handler.GetCoverage")
+	defer span.End()
+
+	coverageReq, err := mapper.ToCoverageRequestModel(req)
+	if err != nil {
+		return nil, app_errors.ToGRPCError(err)
+	}
+	cov, err := u.priceCtl.GetCoverage(ctx, coverageReq)
+	if err != nil {
+		return nil, app_errors.ToGRPCError(err)
+	}
+	return mapper.ToCoverageProto(cov), nil
+}
+
+func (u *priceDataApiServer) GetSummary(ctx context.Context, req *priceDataApi.GetSummaryRequest) (*priceDataApi.GetSummaryResponse, error) {
+	ctx, span := u.tracer.Start(ctx, "handler.GetSummary")
+	defer span.End()
+
+	summary, err := u.priceCtl.Summarize(ctx, mapper.ToSummaryQueryModel(req))
+	if err != nil {
+		return nil, app_errors.ToGRPCError(err)
+	}
+	return mapper.ToSummaryProto(summary), nil
+}
+
+func (u *priceDataApiServer) CreateAlertRule(ctx context.Context, req *priceDataApi.CreateAlertRuleRequest) (*priceDataApi.CreateAlertRuleResponse, error) {
+	ctx, span := u.tracer.Start(ctx, "handler.CreateAlertRule")
+	defer span.End()
+
+	rule, err := mapper.ToAlertRuleModel(req)
+	if err != nil {
+		return nil, app_errors.ToGRPCError(err)
+	}
+	rule, err = u.priceCtl.CreateAlertRule(ctx, rule)
+	if err != nil {
+		return nil, app_errors.ToGRPCError(err)
+	}
+	return &priceDataApi.CreateAlertRuleResponse{Rule: mapper.ToAlertRuleProto(rule)}, nil
+}
+
+func (u *priceDataApiServer) ListAlertRules(ctx context.Context, req *priceDataApi.ListAlertRulesRequest) (*priceDataApi.ListAlertRulesResponse, error) {
+	ctx, span := u.tracer.Start(ctx, "handler.ListAlertRules")
+	defer span.End()
+
+	rules, err := u.priceCtl.ListAlertRules(ctx, req.Asset)
+	if err != nil {
+		return nil, app_errors.ToGRPCError(err)
+	}
+	return &priceDataApi.ListAlertRulesResponse{Rules: mapper.ToAlertRulesProto(rules)}, nil
+}
+
+func (u *priceDataApiServer) DeleteAlertRule(ctx context.Context, req *priceDataApi.DeleteAlertRuleRequest) (*priceDataApi.DeleteAlertRuleResponse, error) {
+	ctx, span := u.tracer.Start(ctx, "handler.DeleteAlertRule")
+	defer span.End()
+
+	if err := u.priceCtl.DeleteAlertRule(ctx, req.Id); err != nil {
+		return nil, app_errors.ToGRPCError(err)
+	}
+	return &priceDataApi.DeleteAlertRuleResponse{}, nil
+}
+
+func (u *priceDataApiServer) Forecast(ctx context.Context, req *priceDataApi.ForecastRequest) (*priceDataApi.ForecastResponse, error) {
+	ctx, span := u.tracer.Start(ctx, "handler.Forecast")
+	defer span.End()
+
+	forecastReq, err := mapper.ToForecastRequestModel(req)
+	if err != nil {
+		return nil, app_errors.ToGRPCError(err)
+	}
+	points, err := u.priceCtl.Forecast(ctx, forecastReq)
+	if err != nil {
+		return nil, app_errors.ToGRPCError(err)
+	}
+	return &priceDataApi.ForecastResponse{
+		Asset:  forecastReq.Asset,
+		Points: mapper.ToForecastPointProto(points),
+	}, nil
+}
+
+func (u *priceDataApiServer) GetAssetMetadata(ctx context.Context, req *priceDataApi.GetAssetMetadataRequest) (*priceDataApi.AssetMetadata, error) {
+	ctx, span := u.tracer.Start(ctx, "handler.GetAssetMetadata")
+	defer span.End()
+
+	metadata, err := u.priceCtl.GetAssetMetadata(ctx, req.Asset)
+	if err != nil {
+		return nil, app_errors.ToGRPCError(err)
+	}
+	return mapper.ToAssetMetadataProto(metadata), nil
+}
+
+func (u *priceDataApiServer) SetAssetMetadata(ctx context.Context, req *priceDataApi.SetAssetMetadataRequest) (*priceDataApi.AssetMetadata, error) {
+	ctx, span := u.tracer.Start(ctx, "handler.SetAssetMetadata")
+	defer span.End()
+
+	metadata, err := u.priceCtl.SetAssetMetadata(ctx, mapper.ToAssetMetadataModel(req.Metadata))
+	if err != nil {
+		return nil, app_errors.ToGRPCError(err)
+	}
+	return mapper.ToAssetMetadataProto(metadata), nil
+}
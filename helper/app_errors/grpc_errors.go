@@ -9,6 +9,7 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/pkg/errors"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // GetStatusCode Parse error and get code
@@ -28,6 +29,10 @@ func GetStatusCode(err error) codes.Code {
 		return codes.Unauthenticated
 	case errors.Is(err, ErrInvalidSessionId):
 		return codes.PermissionDenied
+	case errors.Is(err, ErrInvalidRequest):
+		return codes.InvalidArgument
+	case errors.Is(err, ErrNotFound):
+		return codes.NotFound
 	case strings.Contains(err.Error(), "Validate"):
 		return codes.InvalidArgument
 	case strings.Contains(err.Error(), "redis"):
@@ -36,6 +41,17 @@ func GetStatusCode(err error) codes.Code {
 	return codes.Internal
 }
 
+// ToGRPCError converts an app error into a GRPC status error, errors which already carry a status are returned as is
+func ToGRPCError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(GetStatusCode(err), err.Error())
+}
+
 // Map GRPC app_errors codes to http status
 func MapGRPCErrCodeToHttpStatus(code codes.Code) int {
 	switch code {
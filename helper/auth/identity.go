@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ForwardedCertificateKey is the metadata carrying the verified client certificate of a REST gateway caller
+const ForwardedCertificateKey = "x-forwarded-client-cert-bin"
+
+type identityKey struct{}
+
+// ClientIdentity is the identity of a client taken from its verified TLS certificate
+type ClientIdentity struct {
+	CommonName    string
+	Organizations []string
+	DNSNames      []string
+	URIs          []string // e.g. spiffe ids
+	// Certificate is the verified leaf certificate
+	Certificate *x509.Certificate
+}
+
+// IdentityFromPeer returns the identity of the verified client certificate of the connection
+func IdentityFromPeer(ctx context.Context) (*ClientIdentity, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+
+	return identityOf(tlsInfo.State.VerifiedChains[0][0]), true
+}
+
+// ForwardCertificate returns the metadata forwarding the verified client certificate of the request,
+// nil without one
+func ForwardCertificate(r *http.Request) metadata.MD {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return metadata.Pairs(ForwardedCertificateKey, string(r.TLS.VerifiedChains[0][0].Raw))
+}
+
+// ForwardedIdentity returns the identity of the client certificate forwarded with the call. The metadata is only
+// to be trusted on calls of the service itself.
+func ForwardedIdentity(ctx context.Context) (*ClientIdentity, bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(ForwardedCertificateKey)
+	if len(values) != 1 {
+		return nil, false
+	}
+	cert, err := x509.ParseCertificate([]byte(values[0]))
+	if err != nil {
+		return nil, false
+	}
+	return identityOf(cert), true
+}
+
+func identityOf(cert *x509.Certificate) *ClientIdentity {
+	identity := &ClientIdentity{
+		CommonName:    cert.Subject.CommonName,
+		Organizations: cert.Subject.Organization,
+		DNSNames:      cert.DNSNames,
+		Certificate:   cert,
+	}
+	for _, uri := range cert.URIs {
+		identity.URIs = append(identity.URIs, uri.String())
+	}
+	return identity
+}
+
+// Names returns the common name and the subject alternative names of the identity
+func (i *ClientIdentity) Names() []string {
+	names := append([]string{i.CommonName}, i.DNSNames...)
+	return append(names, i.URIs...)
+}
+
+// Name returns the first name of the identity, the common name or else a subject alternative name
+func (i *ClientIdentity) Name() string {
+	for _, name := range i.Names() {
+		if name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// WithClientIdentity returns a copy of ctx carrying the identity
+func WithClientIdentity(ctx context.Context, identity *ClientIdentity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// ClientIdentityFromContext returns the identity set by the auth interceptor, it is missing without mutual TLS
+func ClientIdentityFromContext(ctx context.Context) (*ClientIdentity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*ClientIdentity)
+	return identity, ok
+}
@@ -0,0 +1,130 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const defaultShutdownTimeout = 5 * time.Second
+
+// Hook is a component of the service. Start must not block, long running work is started in a goroutine
+// which reports a failure with Manager.Fail. Either function may be nil.
+type Hook struct {
+	Name  string
+	Start func(ctx context.Context) error
+	Stop  func(ctx context.Context) error
+}
+
+// Manager starts the components in the order they are appended and stops the started ones in reverse order
+type Manager struct {
+	shutdownTimeout time.Duration
+
+	mu      sync.Mutex
+	hooks   []Hook
+	started int // hooks[:started] were started
+	failed  chan error
+}
+
+func NewManager(shutdownTimeout time.Duration) *Manager {
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+	return &Manager{
+		shutdownTimeout: shutdownTimeout,
+		failed:          make(chan error, 1),
+	}
+}
+
+// Append adds a component, components without Start count as started already,
+// e.g. clients connected before the manager was created
+func (m *Manager) Append(hook Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook)
+	if hook.Start == nil && m.started == len(m.hooks)-1 {
+		m.started++
+	}
+}
+
+// Fail reports that a running component failed, the service shuts down. Only the first failure is kept.
+func (m *Manager) Fail(err error) {
+	select {
+	case m.failed <- err:
+	default:
+	}
+}
+
+// Run starts the components, waits until ctx is done or a component fails and stops the started components.
+// It returns the start or run failure joined with the shutdown errors.
+func (m *Manager) Run(ctx context.Context) error {
+	var err error
+	if err = m.start(ctx); err == nil {
+		select {
+		case <-ctx.Done():
+			log.Println("shutdown requested")
+		case err = <-m.failed:
+			log.Printf("shutdown after failure. %v", err)
+		}
+	}
+	return errors.Join(err, m.Shutdown())
+}
+
+func (m *Manager) start(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for ; m.started < len(m.hooks); m.started++ {
+		hook := m.hooks[m.started]
+		if hook.Start == nil {
+			continue
+		}
+		if err := hook.Start(ctx); err != nil {
+			return fmt.Errorf("%s failed to start: %w", hook.Name, err)
+		}
+		log.Printf("%s started", hook.Name)
+	}
+	return nil
+}
+
+// Shutdown stops the started components in reverse order within the shutdown timeout, every component is stopped
+// even if others fail. Half of the timeout is reserved in equal shares for the components stopped later, so that
+// a component draining until its deadline leaves time to stop the others.
+func (m *Manager) Shutdown() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stops := 0
+	for _, hook := range m.hooks[:m.started] {
+		if hook.Stop != nil {
+			stops++
+		}
+	}
+	deadline := time.Now().Add(m.shutdownTimeout)
+	var reserve time.Duration
+	if stops > 0 {
+		reserve = m.shutdownTimeout / time.Duration(2*stops)
+	}
+
+	var errs []error
+	for ; m.started > 0; m.started-- {
+		hook := m.hooks[m.started-1]
+		if hook.Stop == nil {
+			continue
+		}
+		stops--
+		ctx, cancel := context.WithDeadline(context.Background(), deadline.Add(-time.Duration(stops)*reserve))
+		err := hook.Stop(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("%s failed to stop. %v", hook.Name, err)
+			errs = append(errs, fmt.Errorf("%s failed to stop: %w", hook.Name, err))
+			continue
+		}
+		log.Printf("%s stopped", hook.Name)
+	}
+	return errors.Join(errs...)
+}
@@ -2,6 +2,7 @@ package logger
 
 import (
 	"context"
+	"io"
 	"os"
 	"sync"
 
@@ -27,6 +28,8 @@ var once sync.Once
 
 var (
 	instance *logger
+	// level of the logger, changed by SetLevel without a restart
+	level = zap.NewAtomicLevel()
 )
 
 type logger struct {
@@ -60,7 +63,7 @@ func getLoggerLevel(cfg *config.Config) zapcore.Level {
 func initLogger(cfg *config.Config) *zap.Logger {
 	logLevel := getLoggerLevel(cfg)
 
-	logWriter := zapcore.AddSync(os.Stderr)
+	logWriter := zapcore.AddSync(NewRedactWriter(os.Stderr, cfg.Secrets()))
 
 	var encoderCfg zapcore.EncoderConfig
 	if cfg.Server.Mode == "Development" {
@@ -83,12 +86,36 @@ func initLogger(cfg *config.Config) *zap.Logger {
 	}
 
 	encoderCfg.EncodeTime = zapcore.ISO8601TimeEncoder
-	core := zapcore.NewCore(encoder, logWriter, zap.NewAtomicLevelAt(logLevel))
+	level.SetLevel(logLevel)
+	core := zapcore.NewCore(encoder, logWriter, level)
 	logger := zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1))
 
 	return logger
 }
 
+// SetLevel changes the level of the logger, unknown levels fall back to debug like the startup config
+func SetLevel(cfg *config.Config) {
+	level.SetLevel(getLoggerLevel(cfg))
+}
+
+// redactWriter replaces the secrets in every line written to w
+type redactWriter struct {
+	w       io.Writer
+	secrets []string
+}
+
+// NewRedactWriter returns a writer which redacts the secrets and connection string passwords before writing to w
+func NewRedactWriter(w io.Writer, secrets []string) io.Writer {
+	return &redactWriter{w: w, secrets: secrets}
+}
+
+func (rw *redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, config.Redact(string(p), rw.secrets)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
 func SyncLogger(logger *zap.Logger) {
 	if err := logger.Sync(); err != nil {
 		logger.Error(err.Error())
@@ -2,9 +2,12 @@ package metric
 
 import (
 	"context"
+	"sync"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric/global"
 	"go.opentelemetry.io/otel/metric/instrument"
+	"go.opentelemetry.io/otel/metric/instrument/asyncfloat64"
 	"go.opentelemetry.io/otel/metric/instrument/syncfloat64"
 	"go.opentelemetry.io/otel/metric/instrument/syncint64"
 )
@@ -16,6 +19,8 @@ type BusinessMetrics struct {
 	PriceDataQueriesTotal syncint64.Counter
 	PriceDataLoadErrors   syncint64.Counter
 	PriceDataQueryErrors  syncint64.Counter
+	PriceDataQuarantined  syncint64.Counter
+	PriceDataAnomalies    syncint64.Counter
 
 	// Histograms
 	PriceDataLoadDuration  syncfloat64.Histogram
@@ -24,6 +29,11 @@ type BusinessMetrics struct {
 
 	// Gauges (using UpDownCounter)
 	LastUpdateTimestamp syncint64.UpDownCounter
+	// PriceDataCoverage reports the last coverage set per asset
+	PriceDataCoverage asyncfloat64.Gauge
+
+	coverageMu sync.Mutex
+	coverage   map[string]float64
 }
 
 var businessMetrics *BusinessMetrics
@@ -68,6 +78,22 @@ func InitBusinessMetrics() error {
 		return err
 	}
 
+	bm.PriceDataQuarantined, err = meter.SyncInt64().Counter(
+		MetricsPrefix+"price_data_quarantined_total",
+		instrument.WithDescription("Total number of loaded price points which failed validation"),
+	)
+	if err != nil {
+		return err
+	}
+
+	bm.PriceDataAnomalies, err = meter.SyncInt64().Counter(
+		MetricsPrefix+"price_data_anomalies_total",
+		instrument.WithDescription("Total number of stored price points flagged by the anomaly detection"),
+	)
+	if err != nil {
+		return err
+	}
+
 	// Histograms
 	bm.PriceDataLoadDuration, err = meter.SyncFloat64().Histogram(
 		MetricsPrefix+"price_data_load_duration_seconds",
@@ -102,6 +128,19 @@ func InitBusinessMetrics() error {
 		return err
 	}
 
+	bm.coverage = map[string]float64{}
+	bm.PriceDataCoverage, err = meter.AsyncFloat64().Gauge(
+		MetricsPrefix+"price_data_coverage_percent",
+		instrument.WithDescription("Percentage of the expected price points stored within the coverage gauge window"),
+	)
+	if err != nil {
+		return err
+	}
+	err = meter.RegisterCallback([]instrument.Asynchronous{bm.PriceDataCoverage}, bm.observeCoverage)
+	if err != nil {
+		return err
+	}
+
 	businessMetrics = bm
 	return nil
 }
@@ -122,6 +161,16 @@ func (bm *BusinessMetrics) RecordLoadError(ctx context.Context) {
 	bm.PriceDataLoadErrors.Add(ctx, 1)
 }
 
+// RecordQuarantined records loaded price points which failed validation
+func (bm *BusinessMetrics) RecordQuarantined(ctx context.Context, count int64) {
+	bm.PriceDataQuarantined.Add(ctx, count)
+}
+
+// RecordAnomalies records price points flagged by an anomaly rule
+func (bm *BusinessMetrics) RecordAnomalies(ctx context.Context, rule string, count int64) {
+	bm.PriceDataAnomalies.Add(ctx, count, attribute.String("rule", rule))
+}
+
 // RecordQuerySuccess records a successful price data query
 func (bm *BusinessMetrics) RecordQuerySuccess(ctx context.Context, duration float64, recordCount int64) {
 	bm.PriceDataQueriesTotal.Add(ctx, 1)
@@ -138,3 +187,18 @@ func (bm *BusinessMetrics) RecordQueryError(ctx context.Context) {
 func (bm *BusinessMetrics) UpdateLastUpdateTimestamp(ctx context.Context, timestamp int64) {
 	bm.LastUpdateTimestamp.Add(ctx, timestamp)
 }
+
+// SetCoverage sets the coverage gauge of the asset
+func (bm *BusinessMetrics) SetCoverage(asset string, percent float64) {
+	bm.coverageMu.Lock()
+	defer bm.coverageMu.Unlock()
+	bm.coverage[asset] = percent
+}
+
+func (bm *BusinessMetrics) observeCoverage(ctx context.Context) {
+	bm.coverageMu.Lock()
+	defer bm.coverageMu.Unlock()
+	for asset, percent := range bm.coverage {
+		bm.PriceDataCoverage.Observe(ctx, percent, attribute.String("asset", asset))
+	}
+}
@@ -27,13 +27,13 @@ func UnaryServerMetricsInterceptor() grpc.UnaryServerInterceptor {
 
 			// You can add method-specific logic here
 			switch info.FullMethod {
-			case "/pricedata.price_data.v1.PriceDataService/LoadData":
+			case "/data_api.v1.PriceDataService/LoadData":
 				if err != nil {
 					metrics.RecordLoadError(ctx)
 				} else {
 					metrics.RecordLoadSuccess(ctx, duration)
 				}
-			case "/pricedata.price_data.v1.PriceDataService/FindData":
+			case "/data_api.v1.PriceDataService/FindData":
 				if err != nil {
 					metrics.RecordQueryError(ctx)
 				} else {
@@ -67,7 +67,7 @@ func StreamServerMetricsInterceptor() grpc.StreamServerInterceptor {
 			duration := time.Since(startTime).Seconds()
 
 			switch info.FullMethod {
-			case "/pricedata.price_data.v1.PriceDataService/StreamData":
+			case "/data_api.v1.PriceDataService/ExportData":
 				if err != nil {
 					metrics.RecordQueryError(ss.Context())
 				} else {
@@ -2,6 +2,7 @@ package metric
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 
@@ -73,7 +74,9 @@ func InitMetrics(cfg *config.Config) (func(context.Context) error, error) {
 	}
 
 	// Set up metrics HTTP endpoint
-	http.HandleFunc("/metrics", exporter.ServeHTTP)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", exporter.ServeHTTP)
+	server := &http.Server{Addr: cfg.Metrics.URL, Handler: mux}
 
 	// Start HTTP server in goroutine with proper error handling
 	go func() {
@@ -82,13 +85,13 @@ func InitMetrics(cfg *config.Config) (func(context.Context) error, error) {
 			cfg.Metrics.URL,
 			cfg.Metrics.ServiceName,
 		)
-		if err := http.ListenAndServe(cfg.Metrics.URL, nil); err != nil {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Printf("Metrics HTTP server error: %v", err)
 		}
 	}()
 
-	// Return shutdown function
+	// Return shutdown function, stopping the endpoint before the controller
 	return func(ctx context.Context) error {
-		return ctrl.Stop(ctx)
+		return errors.Join(server.Shutdown(ctx), ctrl.Stop(ctx))
 	}, nil
 }
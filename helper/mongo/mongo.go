@@ -3,7 +3,6 @@ package mongo
 import (
 	"context"
 	"errors"
-	"fmt"
 	"log"
 
 	"github.com/erich/pricetracking/config"
@@ -11,53 +10,43 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-const (
-	DB_NAME = "zeonology"
-)
-
 // Returns new mongo client
 func NewMongoClient(cfg *config.Config) (*mongo.Client, error) {
 
 	if cfg.Mongo.Uri == "" {
 		return nil, errors.New("the mongo uri is not set")
 	}
-	log.Printf("Mongo Uri: %s", cfg.Mongo.Uri)
-	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(cfg.Mongo.Uri))
+	log.Printf("Mongo Uri: %s", config.RedactURI(cfg.Mongo.Uri))
+	opts := options.Client().ApplyURI(cfg.Mongo.Uri)
+	if cfg.Mongo.Username != "" {
+		authSource := cfg.Mongo.AuthSource
+		if authSource == "" {
+			authSource = "admin"
+		}
+		opts.SetAuth(options.Credential{
+			Username:   cfg.Mongo.Username,
+			Password:   cfg.Mongo.Password,
+			AuthSource: authSource,
+		})
+	}
+	client, err := mongo.Connect(context.Background(), opts)
 	if err != nil {
 		return nil, err
 	}
 	return client, nil
 }
 
-func Close(client *mongo.Client) {
-	if err := client.Disconnect(context.Background()); err != nil {
-		panic(err)
-	}
+// Close disconnects the client, waiting for in-use connections until ctx is done
+func Close(ctx context.Context, client *mongo.Client) error {
+	return client.Disconnect(ctx)
 }
 
-func CreateTimeSeriesCollection(client *mongo.Client, collecName string) (*mongo.Collection, error) {
-	db := client.Database(DB_NAME)
-
-	// Creates a time series collection that stores "price" values over time
-	tso := options.TimeSeries().SetTimeField("timestamp")
-	opts := options.CreateCollection().SetTimeSeriesOptions(tso)
-
-	err := db.CreateCollection(context.TODO(), collecName, opts)
-	if err != nil {
-		// Check if the error is not due to the collection already existing
-		if mongoErr, ok := err.(mongo.CommandError); ok && mongoErr.Code != 48 {
-			// Handle other errors
-			return nil, fmt.Errorf("failed to create collection: %w", err)
-		}
-		fmt.Println("Collection already exists, ignoring the error.")
-	}
-
-	collection := db.Collection(collecName)
-	return collection, nil
+// Database returns the configured database, which is the database of the tenant for a tenant config
+func Database(client *mongo.Client, cfg *config.Config) *mongo.Database {
+	return client.Database(cfg.Mongo.Database)
 }
 
-func CreateCollection(client *mongo.Client, collecName string) (*mongo.Collection, error) {
-	db := client.Database(DB_NAME)
-	collec := db.Collection(collecName)
-	return collec, nil
+// Collection returns the collection of the configured database, collections are created by the schema migrations
+func Collection(client *mongo.Client, cfg *config.Config, name string) *mongo.Collection {
+	return Database(client, cfg).Collection(name)
 }
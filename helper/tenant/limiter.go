@@ -0,0 +1,66 @@
+package tenant
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket per tenant, so that a tenant exceeding its rate does not slow down the others
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second, the limit is disabled if 0
+	burst   float64
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter of rate requests per second, burst defaults to the rate
+func NewLimiter(rate float64, burst int) *Limiter {
+	l := &Limiter{buckets: map[string]*bucket{}}
+	l.SetRate(rate, burst)
+	return l
+}
+
+// SetRate changes the limit of every tenant, the tokens left are kept up to the new burst
+func (l *Limiter) SetRate(rate float64, burst int) {
+	b := float64(burst)
+	if b <= 0 {
+		b = rate
+	}
+	if b < 1 {
+		b = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate, l.burst = rate, b
+}
+
+// Allow takes a token of the tenant and reports whether one was left
+func (l *Limiter) Allow(id string) bool {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return true
+	}
+
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[id] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
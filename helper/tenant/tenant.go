@@ -0,0 +1,16 @@
+package tenant
+
+import "context"
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx carrying the tenant id
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, id)
+}
+
+// FromContext returns the tenant id set by the tenant interceptor, it is missing in the single-tenant mode
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(tenantKey{}).(string)
+	return id, ok
+}
@@ -0,0 +1,211 @@
+package tlsconfig
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/erich/pricetracking/config"
+)
+
+const defaultReloadInterval = 30 * time.Second
+
+// Reloader keeps the server certificate and the client CA bundle, and reloads them when the files change on disk
+type Reloader struct {
+	cfg        config.TLS
+	clientAuth tls.ClientAuthType
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+	// every certificate loaded, loopback connections keep the certificate of their handshake
+	loaded map[string]bool
+	// reload intervals of a reloaded config, applied by Run
+	intervals chan time.Duration
+}
+
+func NewReloader(cfg config.TLS) (*Reloader, error) {
+	r := &Reloader{cfg: cfg, clientAuth: tls.NoClientCert, loaded: map[string]bool{}, intervals: make(chan time.Duration, 1)}
+	if cfg.ClientCAFile != "" {
+		r.clientAuth = tls.RequireAndVerifyClientCert
+		if cfg.ClientAuth == "request" {
+			r.clientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+// load reads the files, the current certificate is kept if they are invalid
+func (r *Reloader) load() error {
+	modTimes := map[string]time.Time{}
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+	var clientCAs *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %s", r.cfg.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.clientCAs, r.modTimes = &cert, clientCAs, modTimes
+	r.loaded[string(cert.Certificate[0])] = true
+	return nil
+}
+
+// IsOwnCertificate reports whether cert is a server certificate loaded by the reloader, as presented by
+// the loopback connections. Its holder is the service itself, not an authenticated client.
+func (r *Reloader) IsOwnCertificate(cert *x509.Certificate) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return cert != nil && r.loaded[string(cert.Raw)]
+}
+
+// changed reports whether any file was modified since the last load
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetReloadInterval changes the seconds between the checks of the files, e.g. of a reloaded config.
+// It is not safe for concurrent use.
+func (r *Reloader) SetReloadInterval(interval time.Duration) {
+	select {
+	case <-r.intervals:
+	default:
+	}
+	r.intervals <- interval
+}
+
+func reloadInterval(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return defaultReloadInterval
+	}
+	return interval * time.Second
+}
+
+// Run checks the files every reload interval until ctx is done, a changed interval restarts the ticker
+func (r *Reloader) Run(ctx context.Context) {
+	ticker := time.NewTicker(reloadInterval(r.cfg.ReloadInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case interval := <-r.intervals:
+			ticker.Reset(reloadInterval(interval))
+			continue
+		}
+		if !r.changed() {
+			continue
+		}
+		if err := r.load(); err != nil {
+			log.Printf("TLS certificate reload failed, the current certificate is kept. %v", err)
+			continue
+		}
+		log.Printf("TLS certificate reloaded from %s", r.cfg.CertFile)
+	}
+}
+
+func (r *Reloader) certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// ServerConfig returns the config of the GRPC server, every handshake uses the latest loaded files
+func (r *Reloader) ServerConfig() *tls.Config {
+	return r.serverConfig(r.clientAuth, "h2")
+}
+
+// HTTPServerConfig returns the config of the REST gateway and the gRPC-Web server, which serve HTTP/1.1 as well.
+// Client certificates are verified if given, so that the probes pass without one; the calls which require
+// a certificate are rejected by the auth interceptor.
+func (r *Reloader) HTTPServerConfig() *tls.Config {
+	clientAuth := r.clientAuth
+	if clientAuth == tls.RequireAndVerifyClientCert {
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+	return r.serverConfig(clientAuth, "h2", "http/1.1")
+}
+
+func (r *Reloader) serverConfig(clientAuth tls.ClientAuthType, nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				ClientCAs:    r.clientCAs,
+				ClientAuth:   clientAuth,
+				NextProtos:   nextProtos,
+			}, nil
+		},
+	}
+}
+
+// LoopbackConfig returns the config of connections from the service to its own GRPC port, e.g. the REST gateway.
+// The server is verified against the loaded certificate instead of a CA, and the certificate is presented
+// as client certificate to pass the handshake of mutual TLS. It does not authenticate the callers of the
+// gateway, whose verified certificates are forwarded with their calls, see IsOwnCertificate.
+func (r *Reloader) LoopbackConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true, // replaced by VerifyPeerCertificate
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], r.certificate().Certificate[0]) {
+				return errors.New("server certificate does not match the loaded certificate")
+			}
+			return nil
+		},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.certificate(), nil
+		},
+	}
+}
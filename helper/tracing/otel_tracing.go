@@ -3,6 +3,7 @@ package tracing
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/erich/pricetracking/config"
@@ -19,6 +20,30 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
+// sampler delegates to the sampler of the current config, so that the sampling ratio is changed without a restart
+var sampler = &reloadableSampler{}
+
+type reloadableSampler struct {
+	current atomic.Value // sdktrace.Sampler
+}
+
+func (s *reloadableSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	return s.current.Load().(sdktrace.Sampler).ShouldSample(p)
+}
+
+func (s *reloadableSampler) Description() string {
+	return s.current.Load().(sdktrace.Sampler).Description()
+}
+
+// SetSamplingRatio applies Jaeger.SamplingRatio, every span is sampled outside of production
+func SetSamplingRatio(cfg *config.Config) {
+	smpl := sdktrace.TraceIDRatioBased(cfg.Jaeger.SamplingRatio)
+	if cfg.Server.Mode != "Production" {
+		smpl = sdktrace.AlwaysSample()
+	}
+	sampler.current.Store(smpl)
+}
+
 // InitOpenTelemetryCollector initializes a telemetry collector using GRPC or stdout based on the environment
 func InitOpenTelemetryCollector(cfg *config.Config) (func(context.Context) error, error) {
 	ctx := context.Background()
@@ -39,13 +64,10 @@ func InitOpenTelemetryCollector(cfg *config.Config) (func(context.Context) error
 
 	bsp := sdktrace.NewBatchSpanProcessor(exporter)
 
-	smpl := sdktrace.TraceIDRatioBased(cfg.Jaeger.SamplingRatio)
-	if cfg.Server.Mode != "Production" {
-		smpl = sdktrace.AlwaysSample()
-	}
+	SetSamplingRatio(cfg)
 
 	tracerProvider := sdktrace.NewTracerProvider(
-		sdktrace.WithSampler(smpl),
+		sdktrace.WithSampler(sampler),
 		sdktrace.WithResource(res),
 		sdktrace.WithSpanProcessor(bsp),
 	)
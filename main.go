@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 
 	server "github.com/erich/pricetracking/app"
 	"github.com/erich/pricetracking/config"
+	"github.com/erich/pricetracking/helper/lifecycle"
 	"github.com/erich/pricetracking/helper/logger"
 	"github.com/erich/pricetracking/helper/metric"
 	"github.com/erich/pricetracking/helper/mongo"
@@ -21,58 +25,76 @@ import (
 // - mongo Client
 // - Jaeger tracing
 // - prometheus metrics
+// They are stopped by the lifecycle manager in reverse order on SIGINT/SIGTERM.
 func main() {
+	if err := run(); err != nil {
+		log.Printf("Exited with errors: %v", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
 	log.Println("Starting auth microservice......")
 
 	//load config
 	log.Println("Load configuration......")
 	cfg, err := config.GetServiceConfig()
 	if err != nil {
-		log.Fatalf("Loading config failed: %v", err)
-		return
+		return err
 	}
 
+	// the secrets are redacted from every log line
+	log.SetOutput(logger.NewRedactWriter(os.Stderr, cfg.Secrets()))
+
 	//initiate zapLogger
 	zapLogger := logger.NewLogger(cfg)
 	defer logger.SyncLogger(zapLogger)
 	log.Println("Success initiated zap logger")
 
+	// admin subcommands run against the same config and exit
+	if len(os.Args) > 1 && server.IsCommand(os.Args[1]) {
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+		return server.RunCommand(ctx, cfg, os.Args[1:])
+	}
+
+	// the root context is cancelled on the first signal
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	lc := lifecycle.NewManager(cfg.Server.ShutdownTimeout * time.Second)
+
 	//init mongodb client
 	mongoClient, err := mongo.NewMongoClient(cfg)
 	if err != nil {
-		log.Fatalf("MongoDB init failed: %v", err)
-		return
+		return err
 	}
-	defer mongo.Close(mongoClient)
+	lc.Append(lifecycle.Hook{Name: "MongoDB client", Stop: func(ctx context.Context) error {
+		return mongo.Close(ctx, mongoClient)
+	}})
 	log.Println("MongoDB connected")
 
-	// initiate tracing
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer cancel()
+	if err := server.CheckServerVersion(ctx, cfg, mongoClient); err != nil {
+		return errors.Join(err, lc.Shutdown())
+	}
+	if err := server.InitiateSchema(ctx, cfg, mongoClient); err != nil {
+		return errors.Join(err, lc.Shutdown())
+	}
 
-	shutdown, err := tracing.InitOpenTelemetryCollector(cfg)
+	// initiate tracing
+	tracingShutdown, err := tracing.InitOpenTelemetryCollector(cfg)
 	if err != nil {
-		log.Fatalf("failed to initiate open telemetry %v", err)
+		return errors.Join(err, lc.Shutdown())
 	}
-
-	defer func() {
-		if err := shutdown(ctx); err != nil {
-			log.Fatalf("failed to shutdown TracerProvider %v", err)
-		}
-	}()
+	lc.Append(lifecycle.Hook{Name: "OpenTelemetry tracing", Stop: tracingShutdown})
 	log.Println("OpenTelemetry tracing connected")
 
 	//init metrics
 	metricsShutdown, err := metric.InitMetrics(cfg)
 	if err != nil {
-		log.Fatalf("failed to initiate open telemetry metrics %v", err)
+		return errors.Join(err, lc.Shutdown())
 	}
-
-	defer func() {
-		if err := metricsShutdown(ctx); err != nil {
-			log.Fatalf("failed to shutdown MeterProvider %v", err)
-		}
-	}()
+	lc.Append(lifecycle.Hook{Name: "OpenTelemetry metrics", Stop: metricsShutdown})
 	log.Println("OpenTelemetry metrics connected")
 
 	//start grpc server
@@ -84,5 +106,24 @@ func main() {
 		cfg.Server.Port,
 	)
 	myServer := server.NewGrpcServer(zapLogger, cfg, mongoClient)
-	log.Fatal(myServer.Run())
+	if err := myServer.Register(lc); err != nil {
+		return errors.Join(err, lc.Shutdown())
+	}
+
+	// only the log level, the sampling ratio, the tenant rate limits and the intervals of the TLS reload,
+	// the health checks and the alert deliveries are applied on change, other settings need a restart
+	err = config.WatchServiceConfig(func(newCfg *config.Config) {
+		logger.SetLevel(newCfg)
+		tracing.SetSamplingRatio(newCfg)
+		myServer.Reload(newCfg)
+		log.Printf("config reloaded, LogLevel: %s, SamplingRatio: %g, RateLimit: %g", newCfg.Logger.Level, newCfg.Jaeger.SamplingRatio, newCfg.Tenant.RateLimit)
+	})
+	if err != nil {
+		return errors.Join(err, lc.Shutdown())
+	}
+	if err := lc.Run(ctx); err != nil {
+		return err
+	}
+	log.Println("Server Exited Properly")
+	return nil
 }
@@ -1,29 +1,494 @@
 package mapper
 
 import (
-	"errors"
+	"fmt"
 	"strconv"
+	"time"
 
 	price_data_api "github.com/erich/api/pricedata/price_data/v1"
+	"github.com/erich/pricetracking/helper/app_errors"
 	"github.com/erich/pricetracking/model"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func ToQueryModel(protoQuery *price_data_api.Query) (model.Query, error) {
+	if protoQuery == nil {
+		return model.Query{}, fmt.Errorf("%w: query is required", app_errors.ErrInvalidRequest)
+	}
 	unit, interval, err := parse(protoQuery.Window)
 	if err != nil {
 		return model.Query{}, err
 	}
 
+	aggregation := toAggregationModel(protoQuery.Aggregation)
+	if aggregation == model.Aggregation_INVALID {
+		return model.Query{}, fmt.Errorf("%w: invalid aggregation", app_errors.ErrInvalidRequest)
+	}
+
+	transforms, err := toTransformsModel(protoQuery.Transforms)
+	if err != nil {
+		return model.Query{}, err
+	}
+
 	return model.Query{
-		StartTime:      protoQuery.Start.AsTime(),
-		EndTime:        protoQuery.End.AsTime(),
+		StartTime:        protoQuery.Start.AsTime(),
+		EndTime:          protoQuery.End.AsTime(),
+		WindowUnit:       unit,
+		WindowInterval:   interval,
+		Aggregation:      aggregation,
+		Asset:            protoQuery.Asset,
+		Uncorrected:      protoQuery.Uncorrected,
+		ExcludeAnomalies: protoQuery.ExcludeAnomalies,
+		Transforms:       transforms,
+		Currency:         protoQuery.Currency,
+		Unit:             protoQuery.Unit,
+	}, nil
+}
+
+// maxTransformPeriods bounds the lead-in fetched for a transform
+const maxTransformPeriods = 1000
+
+func toTransformsModel(protoTransforms []*price_data_api.Transform) ([]model.Transform, error) {
+	var transforms []model.Transform
+	for _, v := range protoTransforms {
+		transformType := toTransformTypeModel(v.Type)
+		if transformType == model.TransformType_INVALID {
+			return nil, fmt.Errorf("%w: invalid transform type", app_errors.ErrInvalidRequest)
+		}
+		periods := v.Periods
+		if periods == 0 && (transformType.IsReturn() || transformType == model.TransformType_CUMULATIVE_RETURN) {
+			periods = 1
+		}
+		if periods < 1 || periods > maxTransformPeriods {
+			return nil, fmt.Errorf("%w: transform periods must be within [1, %d]", app_errors.ErrInvalidRequest, maxTransformPeriods)
+		}
+		seasonality := toSeasonalityModel(v.Seasonality)
+		if seasonality != model.Seasonality_NONE && !transformType.IsReturn() {
+			return nil, fmt.Errorf("%w: seasonality is only supported by diff and returns", app_errors.ErrInvalidRequest)
+		}
+		transforms = append(transforms, model.Transform{Type: transformType, Periods: int(periods), Seasonality: seasonality})
+	}
+	return transforms, nil
+}
+
+func toTransformTypeModel(transformType price_data_api.TransformType) model.TransformType {
+	switch transformType {
+	case price_data_api.TransformType_TRANSFORM_SMA:
+		return model.TransformType_SMA
+	case price_data_api.TransformType_TRANSFORM_EMA:
+		return model.TransformType_EMA
+	case price_data_api.TransformType_TRANSFORM_ROLLING_MIN:
+		return model.TransformType_ROLLING_MIN
+	case price_data_api.TransformType_TRANSFORM_ROLLING_MAX:
+		return model.TransformType_ROLLING_MAX
+	case price_data_api.TransformType_TRANSFORM_ROLLING_STDDEV:
+		return model.TransformType_ROLLING_STDDEV
+	case price_data_api.TransformType_TRANSFORM_DIFF:
+		return model.TransformType_DIFF
+	case price_data_api.TransformType_TRANSFORM_PCT_RETURN:
+		return model.TransformType_PCT_RETURN
+	case price_data_api.TransformType_TRANSFORM_LOG_RETURN:
+		return model.TransformType_LOG_RETURN
+	case price_data_api.TransformType_TRANSFORM_CUMULATIVE_RETURN:
+		return model.TransformType_CUMULATIVE_RETURN
+	default:
+		return model.TransformType_INVALID
+	}
+}
+
+func toSeasonalityModel(seasonality price_data_api.Seasonality) model.Seasonality {
+	switch seasonality {
+	case price_data_api.Seasonality_SEASONALITY_DAY:
+		return model.Seasonality_DAY
+	case price_data_api.Seasonality_SEASONALITY_WEEK:
+		return model.Seasonality_WEEK
+	case price_data_api.Seasonality_SEASONALITY_YEAR:
+		return model.Seasonality_YEAR
+	default:
+		return model.Seasonality_NONE
+	}
+}
+
+func ToNamedQueriesModel(req *price_data_api.BatchFindDataRequest) []model.NamedQuery {
+	queries := make([]model.NamedQuery, len(req.Queries))
+	for i, v := range req.Queries {
+		queries[i].Name = v.Name
+		queries[i].Query, queries[i].Err = ToQueryModel(v.Query)
+	}
+	return queries
+}
+
+func ToBatchFindDataResponse(results map[string]model.QueryResult) *price_data_api.BatchFindDataResponse {
+	resp := &price_data_api.BatchFindDataResponse{
+		Results: make(map[string]*price_data_api.BatchFindDataResult, len(results)),
+	}
+	for name, result := range results {
+		if result.Err != nil {
+			st := status.Convert(app_errors.ToGRPCError(result.Err))
+			resp.Results[name] = &price_data_api.BatchFindDataResult{
+				Code:    int32(st.Code()),
+				Message: st.Message(),
+			}
+			continue
+		}
+		resp.Results[name] = &price_data_api.BatchFindDataResult{
+			Prices:   ToPriceDataProto(result.Entries),
+			Currency: result.Unit.Currency,
+			Unit:     result.Unit.Unit,
+		}
+	}
+	return resp
+}
+
+func ToExportRequestModel(req *price_data_api.ExportDataRequest) (model.ExportRequest, error) {
+	format := toExportFormatModel(req.Format)
+	if format == model.ExportFormat_INVALID {
+		return model.ExportRequest{}, fmt.Errorf("%w: invalid export format", app_errors.ErrInvalidRequest)
+	}
+
+	if req.Raw {
+		if req.Query == nil {
+			return model.ExportRequest{}, fmt.Errorf("%w: query is required", app_errors.ErrInvalidRequest)
+		}
+		// the stored points are exported as they are
+		if req.Query.Window != "" || req.Query.Aggregation != price_data_api.Aggregation_AGGREGATION_INVALID ||
+			len(req.Query.Transforms) > 0 || req.Query.Currency != "" || req.Query.Unit != "" {
+			return model.ExportRequest{}, fmt.Errorf("%w: raw exports support no window, aggregation, transforms or unit conversion", app_errors.ErrInvalidRequest)
+		}
+		return model.ExportRequest{
+			Query: model.Query{
+				StartTime:        req.Query.Start.AsTime(),
+				EndTime:          req.Query.End.AsTime(),
+				Asset:            req.Query.Asset,
+				Uncorrected:      req.Query.Uncorrected,
+				ExcludeAnomalies: req.Query.ExcludeAnomalies,
+			},
+			Format: format,
+			Raw:    true,
+		}, nil
+	}
+
+	query, err := ToQueryModel(req.Query)
+	if err != nil {
+		return model.ExportRequest{}, err
+	}
+	return model.ExportRequest{
+		Query:  query,
+		Format: format,
+	}, nil
+}
+
+func toExportFormatModel(format price_data_api.ExportFormat) model.ExportFormat {
+	switch format {
+	case price_data_api.ExportFormat_EXPORT_FORMAT_CSV:
+		return model.ExportFormat_CSV
+	case price_data_api.ExportFormat_EXPORT_FORMAT_NDJSON:
+		return model.ExportFormat_NDJSON
+	case price_data_api.ExportFormat_EXPORT_FORMAT_PARQUET:
+		return model.ExportFormat_PARQUET
+	default:
+		return model.ExportFormat_INVALID
+	}
+}
+
+func ToImportEntryModel(price *price_data_api.PriceData) model.Entry {
+	entry := model.Entry{Value: price.GetValue()}
+	if price.GetTime() != nil {
+		entry.Time = price.Time.AsTime()
+	}
+	return entry
+}
+
+func ToImportDataResponse(summary model.ImportSummary) *price_data_api.ImportDataResponse {
+	rejections := make([]*price_data_api.ImportRejection, len(summary.Rejections))
+	for i, v := range summary.Rejections {
+		rejections[i] = &price_data_api.ImportRejection{
+			Row:    v.Row,
+			Reason: v.Reason,
+		}
+	}
+	return &price_data_api.ImportDataResponse{
+		Accepted:   summary.Accepted,
+		Rejected:   summary.Rejected,
+		Rejections: rejections,
+	}
+}
+
+func ToCorrectionRequestModel(asset string, t *timestamppb.Timestamp, value float64, user string, reason string) model.CorrectionRequest {
+	req := model.CorrectionRequest{
+		Asset:  asset,
+		Value:  value,
+		User:   user,
+		Reason: reason,
+	}
+	if t != nil {
+		req.Time = t.AsTime()
+	}
+	return req
+}
+
+func ToAuditEntryProto(audit model.AuditEntry) *price_data_api.AuditEntry {
+	return &price_data_api.AuditEntry{
+		Asset:         audit.Asset,
+		Time:          timestamppb.New(audit.Time),
+		Action:        string(audit.Action),
+		User:          audit.User,
+		OnBehalfOf:    audit.OnBehalfOf,
+		Reason:        audit.Reason,
+		PreviousValue: audit.PreviousValue,
+		NewValue:      audit.NewValue,
+		CreatedAt:     timestamppb.New(audit.CreatedAt),
+	}
+}
+
+func ToQuarantineFilterModel(req *price_data_api.ListQuarantinedRequest) model.QuarantineFilter {
+	return model.QuarantineFilter{
+		Asset:     req.Asset,
+		Rule:      req.Rule,
+		PageSize:  int(req.PageSize),
+		PageToken: req.PageToken,
+	}
+}
+
+func ToQuarantinedPriceProto(entries []model.QuarantinedEntry) []*price_data_api.QuarantinedPrice {
+	prices := make([]*price_data_api.QuarantinedPrice, len(entries))
+	for i, v := range entries {
+		prices[i] = &price_data_api.QuarantinedPrice{
+			Id:            v.ID,
+			Asset:         v.Asset,
+			Time:          timestamppb.New(v.Time),
+			Value:         v.Value,
+			Rule:          v.Rule,
+			Reason:        v.Reason,
+			QuarantinedAt: timestamppb.New(v.QuarantinedAt),
+		}
+	}
+	return prices
+}
+
+func ToAnomalyFilterModel(req *price_data_api.ListAnomaliesRequest) model.AnomalyFilter {
+	filter := model.AnomalyFilter{
+		Asset: req.Asset,
+		Rule:  model.AnomalyRule(req.Rule),
+	}
+	if req.Start != nil {
+		filter.StartTime = req.Start.AsTime()
+	}
+	if req.End != nil {
+		filter.EndTime = req.End.AsTime()
+	}
+	return filter
+}
+
+func ToAnomalyProto(anomalies []model.Anomaly) []*price_data_api.Anomaly {
+	protoAnomalies := make([]*price_data_api.Anomaly, len(anomalies))
+	for i, v := range anomalies {
+		protoAnomalies[i] = &price_data_api.Anomaly{
+			Asset:      v.Asset,
+			Time:       timestamppb.New(v.Time),
+			Value:      v.Value,
+			Rule:       string(v.Rule),
+			Score:      v.Score,
+			DetectedAt: timestamppb.New(v.DetectedAt),
+		}
+	}
+	return protoAnomalies
+}
+
+func ToCoverageRequestModel(req *price_data_api.GetCoverageRequest) (model.CoverageRequest, error) {
+	coverageReq := model.CoverageRequest{Asset: req.Asset}
+	if req.Start != nil {
+		coverageReq.StartTime = req.Start.AsTime()
+	}
+	if req.End != nil {
+		coverageReq.EndTime = req.End.AsTime()
+	}
+	if req.MinGap != "" {
+		unit, interval, err := parse(req.MinGap)
+		if err != nil {
+			return model.CoverageRequest{}, err
+		}
+		coverageReq.MinGap = time.Duration(interval) * unit.Duration()
+	}
+	return coverageReq, nil
+}
+
+func ToCoverageProto(cov model.Coverage) *price_data_api.GetCoverageResponse {
+	gaps := make([]*price_data_api.Gap, len(cov.Gaps))
+	for i, v := range cov.Gaps {
+		gaps[i] = &price_data_api.Gap{
+			Start: timestamppb.New(v.Start),
+			End:   timestamppb.New(v.End),
+		}
+	}
+	days := make([]*price_data_api.DayCoverage, len(cov.Days))
+	for i, v := range cov.Days {
+		days[i] = &price_data_api.DayCoverage{
+			Day:      timestamppb.New(v.Day),
+			Points:   v.Points,
+			Expected: v.Expected,
+			Coverage: v.Percent,
+		}
+	}
+	return &price_data_api.GetCoverageResponse{
+		Asset:    cov.Asset,
+		Cadence:  durationpb.New(cov.Cadence),
+		Coverage: cov.Percent,
+		Gaps:     gaps,
+		Days:     days,
+	}
+}
+
+func ToSummaryQueryModel(req *price_data_api.GetSummaryRequest) model.Query {
+	query := model.Query{
+		Asset:            req.Asset,
+		Uncorrected:      req.Uncorrected,
+		ExcludeAnomalies: req.ExcludeAnomalies,
+	}
+	if req.Start != nil {
+		query.StartTime = req.Start.AsTime()
+	}
+	if req.End != nil {
+		query.EndTime = req.End.AsTime()
+	}
+	return query
+}
+
+func ToSummaryProto(summary model.Summary) *price_data_api.GetSummaryResponse {
+	resp := &price_data_api.GetSummaryResponse{Count: summary.Count}
+	if summary.Count == 0 {
+		return resp
+	}
+	resp.Min = toPriceDataProto(summary.Min)
+	resp.Max = toPriceDataProto(summary.Max)
+	resp.Mean = summary.Mean
+	resp.Stddev = summary.StdDev
+	resp.First = toPriceDataProto(summary.First)
+	resp.Last = toPriceDataProto(summary.Last)
+	resp.Change = summary.Change
+	resp.ChangePercent = summary.ChangePercent
+	return resp
+}
+
+func ToAlertRuleModel(req *price_data_api.CreateAlertRuleRequest) (model.AlertRule, error) {
+	rule := model.AlertRule{
+		Asset:         req.Asset,
+		Condition:     toAlertConditionModel(req.Condition),
+		Level:         req.Level,
+		ChangePercent: req.ChangePercent,
+	}
+	if req.Window != "" {
+		unit, interval, err := parse(req.Window)
+		if err != nil {
+			return model.AlertRule{}, err
+		}
+		rule.Window = time.Duration(interval) * unit.Duration()
+	}
+	return rule, nil
+}
+
+func ToAlertRuleProto(rule model.AlertRule) *price_data_api.AlertRule {
+	protoRule := &price_data_api.AlertRule{
+		Id:            rule.ID,
+		Asset:         rule.Asset,
+		Condition:     toAlertConditionProto(rule.Condition),
+		Level:         rule.Level,
+		ChangePercent: rule.ChangePercent,
+		Firing:        rule.Firing,
+		CreatedAt:     timestamppb.New(rule.CreatedAt),
+	}
+	if rule.Window > 0 {
+		protoRule.Window = model.FormatWindow(rule.Window)
+	}
+	if !rule.FiredAt.IsZero() {
+		protoRule.FiredAt = timestamppb.New(rule.FiredAt)
+	}
+	return protoRule
+}
+
+func ToAlertRulesProto(rules []model.AlertRule) []*price_data_api.AlertRule {
+	protoRules := make([]*price_data_api.AlertRule, len(rules))
+	for i, v := range rules {
+		protoRules[i] = ToAlertRuleProto(v)
+	}
+	return protoRules
+}
+
+func toAlertConditionModel(condition price_data_api.AlertCondition) model.AlertCondition {
+	switch condition {
+	case price_data_api.AlertCondition_ALERT_CONDITION_CROSSES_ABOVE:
+		return model.AlertCondition_CROSSES_ABOVE
+	case price_data_api.AlertCondition_ALERT_CONDITION_CROSSES_BELOW:
+		return model.AlertCondition_CROSSES_BELOW
+	case price_data_api.AlertCondition_ALERT_CONDITION_CHANGE_PERCENT:
+		return model.AlertCondition_CHANGE_PERCENT
+	default:
+		return model.AlertCondition_INVALID
+	}
+}
+
+func toAlertConditionProto(condition model.AlertCondition) price_data_api.AlertCondition {
+	switch condition {
+	case model.AlertCondition_CROSSES_ABOVE:
+		return price_data_api.AlertCondition_ALERT_CONDITION_CROSSES_ABOVE
+	case model.AlertCondition_CROSSES_BELOW:
+		return price_data_api.AlertCondition_ALERT_CONDITION_CROSSES_BELOW
+	case model.AlertCondition_CHANGE_PERCENT:
+		return price_data_api.AlertCondition_ALERT_CONDITION_CHANGE_PERCENT
+	default:
+		return price_data_api.AlertCondition_ALERT_CONDITION_INVALID
+	}
+}
+
+func ToForecastRequestModel(req *price_data_api.ForecastRequest) (model.ForecastRequest, error) {
+	unit, interval, err := parse(req.Window)
+	if err != nil {
+		return model.ForecastRequest{}, err
+	}
+	return model.ForecastRequest{
+		Asset:          req.Asset,
 		WindowUnit:     unit,
 		WindowInterval: interval,
-		Aggregation:    toAggregationModel(protoQuery.Aggregation),
+		Horizon:        int(req.Horizon),
+		Seasonality:    toSeasonalityModel(req.Seasonality),
+		Confidence:     req.Confidence,
 	}, nil
 }
 
+func ToForecastPointProto(points []model.ForecastPoint) []*price_data_api.ForecastPoint {
+	protoPoints := make([]*price_data_api.ForecastPoint, len(points))
+	for i, v := range points {
+		protoPoints[i] = &price_data_api.ForecastPoint{
+			Time:  timestamppb.New(v.Time),
+			Value: v.Value,
+			Lower: v.Lower,
+			Upper: v.Upper,
+		}
+	}
+	return protoPoints
+}
+
+func ToAssetMetadataModel(metadata *price_data_api.AssetMetadata) model.AssetMetadata {
+	if metadata == nil {
+		return model.AssetMetadata{}
+	}
+	return model.AssetMetadata{
+		Asset:    metadata.Asset,
+		Currency: metadata.Currency,
+		Unit:     metadata.Unit,
+	}
+}
+
+func ToAssetMetadataProto(metadata model.AssetMetadata) *price_data_api.AssetMetadata {
+	return &price_data_api.AssetMetadata{
+		Asset:    metadata.Asset,
+		Currency: metadata.Currency,
+		Unit:     metadata.Unit,
+	}
+}
+
 func toAggregationModel(aggregation price_data_api.Aggregation) model.Aggregation {
 	switch aggregation {
 	case price_data_api.Aggregation_AGGREGATION_MIN:
@@ -40,6 +505,9 @@ func toAggregationModel(aggregation price_data_api.Aggregation) model.Aggregatio
 }
 
 func parse(s string) (model.TimeUnit, int, error) {
+	if len(s) < 2 {
+		return model.TimeUnit_INVALID, 0, fmt.Errorf("%w: invalid window %q", app_errors.ErrInvalidRequest, s)
+	}
 	unit := s[len(s)-1:]
 	var timeUnit model.TimeUnit
 	if unit == "m" {
@@ -49,12 +517,12 @@ func parse(s string) (model.TimeUnit, int, error) {
 	} else if unit == "h" {
 		timeUnit = model.TimeUnit_HOUR
 	} else {
-		return model.TimeUnit_INVALID, 0, errors.New("invalid window unit")
+		return model.TimeUnit_INVALID, 0, fmt.Errorf("%w: invalid window unit", app_errors.ErrInvalidRequest)
 	}
 	interv := s[0 : len(s)-1]
 	num, err := strconv.Atoi(interv)
-	if err != nil {
-		return model.TimeUnit_INVALID, 0, err
+	if err != nil || num <= 0 {
+		return model.TimeUnit_INVALID, 0, fmt.Errorf("%w: invalid window interval %q", app_errors.ErrInvalidRequest, interv)
 	}
 	return timeUnit, num, nil
 }
@@ -62,10 +530,14 @@ func parse(s string) (model.TimeUnit, int, error) {
 func ToPriceDataProto(entries []model.Entry) []*price_data_api.PriceData {
 	protoPd := make([]*price_data_api.PriceData, len(entries))
 	for i, v := range entries {
-		protoPd[i] = &price_data_api.PriceData{
-			Time:  timestamppb.New(v.Time),
-			Value: float64(v.Value),
-		}
+		protoPd[i] = toPriceDataProto(v)
 	}
 	return protoPd
 }
+
+func toPriceDataProto(entry model.Entry) *price_data_api.PriceData {
+	return &price_data_api.PriceData{
+		Time:  timestamppb.New(entry.Time),
+		Value: float64(entry.Value),
+	}
+}
@@ -0,0 +1,49 @@
+package model
+
+import "time"
+
+type AlertCondition string
+
+const (
+	AlertCondition_INVALID        AlertCondition = "INVALID"
+	AlertCondition_CROSSES_ABOVE  AlertCondition = "crosses_above"
+	AlertCondition_CROSSES_BELOW  AlertCondition = "crosses_below"
+	AlertCondition_CHANGE_PERCENT AlertCondition = "change_percent"
+)
+
+// AlertRule fires when the latest loaded price of the asset meets the condition, it fires again only after it resolved
+type AlertRule struct {
+	ID            string         `bson:"_id,omitempty"`
+	Asset         string         `bson:"asset"`
+	Condition     AlertCondition `bson:"condition"`
+	Level         float64        `bson:"level"`
+	ChangePercent float64        `bson:"changePercent"`
+	Window        time.Duration  `bson:"window"`
+	Firing        bool           `bson:"firing"`
+	FiredAt       time.Time      `bson:"firedAt,omitempty"`
+	CreatedAt     time.Time      `bson:"createdAt"`
+}
+
+// AlertEvent is the webhook payload of a firing alert rule, ID is the same for every delivery of the event
+type AlertEvent struct {
+	ID            string         `json:"id"`
+	RuleID        string         `json:"rule_id"`
+	Asset         string         `json:"asset"`
+	Condition     AlertCondition `json:"condition"`
+	Level         float64        `json:"level,omitempty"`
+	ChangePercent float64        `json:"change_percent,omitempty"`
+	Window        string         `json:"window,omitempty"`
+	Time          time.Time      `json:"time"`
+	Value         float64        `json:"value"`
+	FiredAt       time.Time      `json:"fired_at"`
+}
+
+// AlertDelivery is a pending delivery of an event to a webhook, it is removed once delivered or given up
+type AlertDelivery struct {
+	ID          string     `bson:"_id,omitempty"`
+	Event       AlertEvent `bson:"event"`
+	Webhook     string     `bson:"webhook"`
+	Attempts    int        `bson:"attempts"`
+	NextAttempt time.Time  `bson:"nextAttempt"`
+	LastError   string     `bson:"lastError,omitempty"`
+}
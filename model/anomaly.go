@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+type AnomalyRule string
+
+const (
+	AnomalyRule_ZSCORE AnomalyRule = "zscore"
+	AnomalyRule_MAD    AnomalyRule = "mad"
+	AnomalyRule_JUMP   AnomalyRule = "jump"
+)
+
+// Anomaly is a stored price point flagged by a statistical anomaly rule
+type Anomaly struct {
+	Asset      string      `bson:"asset"`
+	Time       time.Time   `bson:"timestamp"`
+	Value      float64     `bson:"price"`
+	Rule       AnomalyRule `bson:"rule"`
+	Score      float64     `bson:"score"` // the statistic which exceeded the threshold of the rule
+	DetectedAt time.Time   `bson:"detectedAt"`
+}
+
+type AnomalyFilter struct {
+	Asset     string
+	StartTime time.Time // unbounded if zero
+	EndTime   time.Time // unbounded if zero
+	Rule      AnomalyRule
+}
@@ -5,4 +5,5 @@ import "time"
 type Entry struct {
 	Time  time.Time `bson:"timestamp"`
 	Value float64   `bson:"price"`
+	Asset string    `bson:"asset,omitempty"`
 }
@@ -0,0 +1,13 @@
+package model
+
+type NamedQuery struct {
+	Name  string
+	Query Query
+	Err   error // set if the query could not be parsed, the query is not executed then
+}
+
+type QueryResult struct {
+	Entries []Entry
+	Unit    PriceUnit
+	Err     error
+}
@@ -0,0 +1,58 @@
+package model
+
+import "time"
+
+type CorrectionAction string
+
+const (
+	CorrectionAction_CORRECT CorrectionAction = "correct"
+	CorrectionAction_DELETE  CorrectionAction = "delete"
+)
+
+// Correction overrides the value of a stored price point, or tombstones it if Deleted is set
+type Correction struct {
+	Asset      string    `bson:"asset"`
+	Time       time.Time `bson:"timestamp"`
+	Value      float64   `bson:"value"`
+	Deleted    bool      `bson:"deleted"`
+	User       string    `bson:"user"`
+	OnBehalfOf string    `bson:"onBehalfOf,omitempty"`
+	Reason     string    `bson:"reason"`
+	UpdatedAt  time.Time `bson:"updatedAt"`
+}
+
+// CorrectedValue returns the value of a price point with its correction applied, nil if it is deleted
+func CorrectedValue(value float64, correction *Correction) *float64 {
+	switch {
+	case correction == nil:
+		return &value
+	case correction.Deleted:
+		return nil
+	default:
+		return &correction.Value
+	}
+}
+
+// CorrectionRequest is a manual change of a price point. User is the authenticated client if there is one,
+// OnBehalfOf the user it names.
+type CorrectionRequest struct {
+	Asset      string
+	Time       time.Time
+	Value      float64
+	User       string
+	OnBehalfOf string
+	Reason     string
+}
+
+// AuditEntry records a manual change of a price point
+type AuditEntry struct {
+	Asset         string           `bson:"asset"`
+	Time          time.Time        `bson:"timestamp"`
+	Action        CorrectionAction `bson:"action"`
+	User          string           `bson:"user"`
+	OnBehalfOf    string           `bson:"onBehalfOf,omitempty"`
+	Reason        string           `bson:"reason"`
+	PreviousValue *float64         `bson:"previousValue"` // nil if the point was deleted before
+	NewValue      *float64         `bson:"newValue"`      // nil if the point is deleted
+	CreatedAt     time.Time        `bson:"createdAt"`
+}
@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+type CoverageRequest struct {
+	Asset     string
+	StartTime time.Time
+	EndTime   time.Time
+	MinGap    time.Duration // defaults to the configured gap if 0
+}
+
+// Gap is a period without price points, Start and End are the surrounding points or the range bounds
+type Gap struct {
+	Start time.Time
+	End   time.Time
+}
+
+type DayCoverage struct {
+	Day      time.Time
+	Points   int64
+	Expected int64
+	Percent  float64
+}
+
+type Coverage struct {
+	Asset   string
+	Cadence time.Duration
+	Percent float64
+	Gaps    []Gap
+	Days    []DayCoverage
+}
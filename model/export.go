@@ -0,0 +1,25 @@
+package model
+
+type ExportFormat string
+
+const (
+	ExportFormat_INVALID ExportFormat = "INVALID"
+	ExportFormat_CSV     ExportFormat = "csv"
+	ExportFormat_NDJSON  ExportFormat = "ndjson"
+	ExportFormat_PARQUET ExportFormat = "parquet"
+)
+
+// ExportRequest is a query whose result is exported as a file
+type ExportRequest struct {
+	Query  Query
+	Format ExportFormat
+	Raw    bool // export stored price points instead of aggregated windows
+}
+
+// ExportMetadata describes the exported series, it is written into the column headers
+type ExportMetadata struct {
+	Asset       string
+	Unit        string
+	Window      string
+	Aggregation string
+}
@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+type ForecastRequest struct {
+	Asset          string
+	WindowUnit     TimeUnit
+	WindowInterval int
+	Horizon        int // number of forecast windows
+	Seasonality    Seasonality
+	Confidence     float64 // probability covered by the prediction interval
+}
+
+// Window returns the length of one forecast window
+func (f ForecastRequest) Window() time.Duration {
+	return time.Duration(f.WindowInterval) * f.WindowUnit.Duration()
+}
+
+type ForecastPoint struct {
+	Time  time.Time
+	Value float64
+	Lower float64
+	Upper float64
+}
@@ -0,0 +1,33 @@
+package model
+
+// DedupPolicy decides what happens to an imported point whose timestamp is already stored for the asset
+type DedupPolicy string
+
+const (
+	DedupPolicy_SKIP      DedupPolicy = "skip"      // keep the stored point, the imported one is rejected
+	DedupPolicy_OVERWRITE DedupPolicy = "overwrite" // replace the stored point
+	DedupPolicy_ALLOW     DedupPolicy = "allow"     // store both points
+)
+
+// ImportRow is a single price point of an import, Err is set if the row could not be parsed
+type ImportRow struct {
+	Row   int64
+	Entry Entry
+	Err   error
+}
+
+// ImportRowReader returns the next row of an import, or io.EOF after the last row
+type ImportRowReader interface {
+	Next() (ImportRow, error)
+}
+
+type ImportRejection struct {
+	Row    int64
+	Reason string
+}
+
+type ImportSummary struct {
+	Accepted   int64
+	Rejected   int64
+	Rejections []ImportRejection
+}
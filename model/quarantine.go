@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// QuarantinedEntry is a loaded price point which failed a validation rule
+type QuarantinedEntry struct {
+	ID            string    `bson:"_id,omitempty"`
+	Asset         string    `bson:"asset"`
+	Time          time.Time `bson:"timestamp"`
+	Value         float64   `bson:"price"`
+	Rule          string    `bson:"rule"`
+	Reason        string    `bson:"reason"`
+	QuarantinedAt time.Time `bson:"quarantinedAt"`
+	// set before the point is stored by a release, a retried release does not store it twice
+	Releasing bool `bson:"releasing,omitempty"`
+}
+
+type QuarantineFilter struct {
+	Asset     string // all assets if empty
+	Rule      string // all rules if empty
+	PageSize  int
+	PageToken string // id of the last entry of the previous page
+}
+
+func (q QuarantinedEntry) ToEntry() Entry {
+	return Entry{
+		Time:  q.Time,
+		Value: q.Value,
+		Asset: q.Asset,
+	}
+}
@@ -1,6 +1,9 @@
 package model
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type Query struct {
 	StartTime time.Time
@@ -9,6 +12,35 @@ type Query struct {
 	WindowUnit     TimeUnit
 	WindowInterval int
 	Aggregation    Aggregation
+	Asset          string
+	Uncorrected    bool // serve the vendor data without manual corrections
+	// ExcludeAnomalies drops the points flagged by the anomaly detection before aggregating
+	ExcludeAnomalies bool
+	// Transforms are applied in order to the windowed series
+	Transforms []Transform
+	// Currency and Unit convert the series, the stored ones are kept if empty. Unit may carry the currency, e.g. ¢/kWh
+	Currency string
+	Unit     string
+}
+
+// WindowDuration returns the length of one window
+func (q Query) WindowDuration() time.Duration {
+	return time.Duration(q.WindowInterval) * q.WindowUnit.Duration()
+}
+
+// Window returns the window of the query in the api format, e.g. "15m"
+func (q Query) Window() string {
+	return fmt.Sprintf("%d%s", q.WindowInterval, q.WindowUnit.Abbreviation())
+}
+
+// FormatWindow formats the duration in the api window format with the largest unit dividing it, e.g. "15m"
+func FormatWindow(d time.Duration) string {
+	for _, unit := range []TimeUnit{TimeUnit_DAY, TimeUnit_HOUR} {
+		if d%unit.Duration() == 0 {
+			return fmt.Sprintf("%d%s", d/unit.Duration(), unit.Abbreviation())
+		}
+	}
+	return fmt.Sprintf("%d%s", d/time.Minute, TimeUnit_MINUTE.Abbreviation())
 }
 
 type TimeUnit string
@@ -20,6 +52,98 @@ const (
 	TimeUnit_DAY     TimeUnit = "day"
 )
 
+// Abbreviation returns the unit suffix used by the api window format
+func (t TimeUnit) Abbreviation() string {
+	switch t {
+	case TimeUnit_MINUTE:
+		return "m"
+	case TimeUnit_HOUR:
+		return "h"
+	case TimeUnit_DAY:
+		return "d"
+	default:
+		return ""
+	}
+}
+
+// Duration returns the length of one unit
+func (t TimeUnit) Duration() time.Duration {
+	switch t {
+	case TimeUnit_MINUTE:
+		return time.Minute
+	case TimeUnit_HOUR:
+		return time.Hour
+	case TimeUnit_DAY:
+		return 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+type TransformType string
+
+const (
+	TransformType_INVALID        TransformType = "INVALID"
+	TransformType_SMA            TransformType = "sma"
+	TransformType_EMA            TransformType = "ema"
+	TransformType_ROLLING_MIN    TransformType = "rolling_min"
+	TransformType_ROLLING_MAX    TransformType = "rolling_max"
+	TransformType_ROLLING_STDDEV TransformType = "rolling_stddev"
+
+	TransformType_DIFF              TransformType = "diff"
+	TransformType_PCT_RETURN        TransformType = "pct_return"
+	TransformType_LOG_RETURN        TransformType = "log_return"
+	TransformType_CUMULATIVE_RETURN TransformType = "cumulative_return"
+)
+
+// IsReturn reports whether the transform compares a bucket against a reference bucket
+func (t TransformType) IsReturn() bool {
+	return t == TransformType_DIFF || t == TransformType_PCT_RETURN || t == TransformType_LOG_RETURN
+}
+
+type Seasonality string
+
+const (
+	Seasonality_NONE Seasonality = ""
+	Seasonality_DAY  Seasonality = "day"
+	Seasonality_WEEK Seasonality = "week"
+	Seasonality_YEAR Seasonality = "year"
+)
+
+// Reference returns the time of the bucket one season before t
+func (s Seasonality) Reference(t time.Time) time.Time {
+	switch s {
+	case Seasonality_DAY:
+		return t.AddDate(0, 0, -1)
+	case Seasonality_WEEK:
+		return t.AddDate(0, 0, -7)
+	case Seasonality_YEAR:
+		return t.AddDate(-1, 0, 0)
+	default:
+		return t
+	}
+}
+
+// MaxDuration returns the longest possible length of the season
+func (s Seasonality) MaxDuration() time.Duration {
+	switch s {
+	case Seasonality_DAY:
+		return 25 * time.Hour
+	case Seasonality_WEEK:
+		return 7*24*time.Hour + time.Hour
+	case Seasonality_YEAR:
+		return 366 * 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+type Transform struct {
+	Type        TransformType
+	Periods     int         // number of buckets
+	Seasonality Seasonality // reference bucket of returns, Periods is ignored if set
+}
+
 type Aggregation string
 
 const (
@@ -0,0 +1,14 @@
+package model
+
+// Summary holds the statistics of the price points of a query range, the points are zero if Count is 0
+type Summary struct {
+	Count         int64
+	Min           Entry
+	Max           Entry
+	Mean          float64
+	StdDev        float64
+	First         Entry
+	Last          Entry
+	Change        float64
+	ChangePercent float64
+}
@@ -0,0 +1,119 @@
+package model
+
+import "strings"
+
+// PriceUnit is the currency and energy unit of prices, e.g. USD/MWh
+type PriceUnit struct {
+	Currency string
+	Unit     string
+}
+
+func (u PriceUnit) String() string {
+	if u.Currency == "" {
+		return u.Unit
+	}
+	return u.Currency + "/" + u.Unit
+}
+
+// PercentUnit is the unit of percent returns
+var PercentUnit = PriceUnit{Unit: "%"}
+
+// AssetMetadata is the unit the prices of an asset are stored in
+type AssetMetadata struct {
+	Asset    string `bson:"_id"`
+	Currency string `bson:"currency"`
+	Unit     string `bson:"unit"`
+}
+
+// ParsePriceUnit splits a price unit such as ¢/kWh into its currency and energy unit, a unit without a currency
+// is returned as the energy unit
+func ParsePriceUnit(s string) PriceUnit {
+	i := strings.LastIndex(s, "/")
+	if i < 0 {
+		return PriceUnit{Unit: strings.TrimSpace(s)}
+	}
+	return PriceUnit{Currency: strings.TrimSpace(s[:i]), Unit: strings.TrimSpace(s[i+1:])}
+}
+
+func (m AssetMetadata) PriceUnit() PriceUnit {
+	return PriceUnit{Currency: m.Currency, Unit: m.Unit}
+}
+
+// energyUnits are the supported energy units in Wh
+var energyUnits = map[string]float64{
+	"wh":  1,
+	"kwh": 1e3,
+	"mwh": 1e6,
+	"gwh": 1e9,
+}
+
+// EnergyUnitFactor returns the size of the energy unit in Wh
+func EnergyUnitFactor(unit string) (float64, bool) {
+	factor, ok := energyUnits[strings.ToLower(unit)]
+	return factor, ok
+}
+
+// minorCurrencies are the subunit codes and symbols which may be used as currency, they are case sensitive.
+// The bare cent sign is the US cent, as in the US retail tariffs quoted in ¢/kWh.
+var minorCurrencies = map[string]string{
+	"USc":  "USD",
+	"CAc":  "CAD",
+	"EURc": "EUR",
+	"GBp":  "GBP",
+	"¢":    "USD",
+	"US¢":  "USD",
+	"CA¢":  "CAD",
+	"€c":   "EUR",
+	"p":    "GBP",
+}
+
+// currencySymbols are the symbols which may be used instead of the ISO code, they are case sensitive.
+// The bare dollar sign is the US dollar.
+var currencySymbols = map[string]string{
+	"$":   "USD",
+	"US$": "USD",
+	"C$":  "CAD",
+	"CA$": "CAD",
+	"€":   "EUR",
+	"£":   "GBP",
+}
+
+// isoCurrencies are the active ISO 4217 currency codes
+var isoCurrencies = map[string]bool{
+	"AED": true, "AFN": true, "ALL": true, "AMD": true, "ANG": true, "AOA": true, "ARS": true, "AUD": true, "AWG": true,
+	"AZN": true, "BAM": true, "BBD": true, "BDT": true, "BGN": true, "BHD": true, "BIF": true, "BMD": true, "BND": true,
+	"BOB": true, "BRL": true, "BSD": true, "BTN": true, "BWP": true, "BYN": true, "BZD": true, "CAD": true, "CDF": true,
+	"CHF": true, "CLP": true, "CNY": true, "COP": true, "CRC": true, "CUP": true, "CVE": true, "CZK": true, "DJF": true,
+	"DKK": true, "DOP": true, "DZD": true, "EGP": true, "ERN": true, "ETB": true, "EUR": true, "FJD": true, "FKP": true,
+	"GBP": true, "GEL": true, "GHS": true, "GIP": true, "GMD": true, "GNF": true, "GTQ": true, "GYD": true, "HKD": true,
+	"HNL": true, "HTG": true, "HUF": true, "IDR": true, "ILS": true, "INR": true, "IQD": true, "IRR": true, "ISK": true,
+	"JMD": true, "JOD": true, "JPY": true, "KES": true, "KGS": true, "KHR": true, "KMF": true, "KPW": true, "KRW": true,
+	"KWD": true, "KYD": true, "KZT": true, "LAK": true, "LBP": true, "LKR": true, "LRD": true, "LSL": true, "LYD": true,
+	"MAD": true, "MDL": true, "MGA": true, "MKD": true, "MMK": true, "MNT": true, "MOP": true, "MRU": true, "MUR": true,
+	"MVR": true, "MWK": true, "MXN": true, "MYR": true, "MZN": true, "NAD": true, "NGN": true, "NIO": true, "NOK": true,
+	"NPR": true, "NZD": true, "OMR": true, "PAB": true, "PEN": true, "PGK": true, "PHP": true, "PKR": true, "PLN": true,
+	"PYG": true, "QAR": true, "RON": true, "RSD": true, "RUB": true, "RWF": true, "SAR": true, "SBD": true, "SCR": true,
+	"SDG": true, "SEK": true, "SGD": true, "SHP": true, "SLE": true, "SOS": true, "SRD": true, "SSP": true, "STN": true,
+	"SVC": true, "SYP": true, "SZL": true, "THB": true, "TJS": true, "TMT": true, "TND": true, "TOP": true, "TRY": true,
+	"TTD": true, "TWD": true, "TZS": true, "UAH": true, "UGX": true, "USD": true, "UYU": true, "UZS": true, "VES": true,
+	"VND": true, "VUV": true, "WST": true, "XAF": true, "XCD": true, "XOF": true, "XPF": true, "YER": true, "ZAR": true,
+	"ZMW": true, "ZWG": true,
+}
+
+// CurrencyBase returns the ISO currency of the code and the size of the code in that currency, e.g. USc is 0.01 USD
+// and $ is 1 USD
+func CurrencyBase(code string) (string, float64) {
+	if currency, ok := minorCurrencies[code]; ok {
+		return currency, 0.01
+	}
+	if currency, ok := currencySymbols[code]; ok {
+		return currency, 1
+	}
+	return strings.ToUpper(code), 1
+}
+
+// KnownCurrency reports whether the code is an ISO 4217 code, a currency symbol or a minor unit of a currency
+func KnownCurrency(code string) bool {
+	currency, _ := CurrencyBase(code)
+	return isoCurrencies[currency]
+}
@@ -0,0 +1,160 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/erich/pricetracking/config"
+)
+
+// timeSeriesOptions are the options of a time series collection as returned by listCollections
+type timeSeriesOptions struct {
+	TimeField            string `bson:"timeField"`
+	MetaField            string `bson:"metaField"`
+	Granularity          string `bson:"granularity"`
+	BucketMaxSpanSeconds int64  `bson:"bucketMaxSpanSeconds"`
+}
+
+type collectionInfo struct {
+	Name    string `bson:"name"`
+	Type    string `bson:"type"`
+	Options struct {
+		TimeSeries *timeSeriesOptions `bson:"timeseries"`
+	} `bson:"options"`
+}
+
+// Drift returns the differences between the configured and the actual price data collection options and
+// the indexes created by the migrations
+func (m *Migrator) Drift(ctx context.Context) ([]string, error) {
+	drift, err := m.timeSeriesDrift(ctx)
+	if err != nil {
+		return nil, err
+	}
+	managed := indexes(m.cfg)
+	collections := make([]string, 0, len(managed))
+	for collection := range managed {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+	for _, collection := range collections {
+		indexDrift, err := m.indexDrift(ctx, collection, managed[collection])
+		if err != nil {
+			return nil, err
+		}
+		drift = append(drift, indexDrift...)
+	}
+	return drift, nil
+}
+
+func (m *Migrator) timeSeriesDrift(ctx context.Context) ([]string, error) {
+	name := m.cfg.Collections.PriceData
+	info, err := collectionOptions(ctx, m.db, name)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return []string{fmt.Sprintf("collection %s does not exist", name)}, nil
+	}
+	return timeSeriesDifferences(name, info, metaField, m.cfg.TimeSeries), nil
+}
+
+// collectionOptions returns the listCollections entry of the collection, nil if it does not exist
+func collectionOptions(ctx context.Context, db *mongo.Database, name string) (*collectionInfo, error) {
+	cursor, err := db.ListCollections(ctx, bson.D{{"name", name}})
+	if err != nil {
+		return nil, err
+	}
+	var infos []collectionInfo
+	if err := cursor.All(ctx, &infos); err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, nil
+	}
+	return &infos[0], nil
+}
+
+// timeSeriesDifferences compares the options of the collection with the configured ones
+func timeSeriesDifferences(name string, info *collectionInfo, metaField string, ts config.TimeSeries) []string {
+	actual := info.Options.TimeSeries
+	if info.Type != "timeseries" || actual == nil {
+		return []string{fmt.Sprintf("collection %s is not a time series collection, the data has to be copied into one", name)}
+	}
+
+	var drift []string
+	if actual.TimeField != "timestamp" {
+		drift = append(drift, fmt.Sprintf("collection %s has timeField %q, the points are stored with timestamp", name, actual.TimeField))
+	}
+	if actual.MetaField != metaField {
+		drift = append(drift, fmt.Sprintf("collection %s has metaField %q, expected %q. It cannot be changed, the data has to be copied into a new collection",
+			name, actual.MetaField, metaField))
+	}
+	if ts.BucketSpan > 0 {
+		if actual.BucketMaxSpanSeconds != int64(ts.BucketSpan) {
+			drift = append(drift, fmt.Sprintf("collection %s has a bucket span of %ds, configured %ds",
+				name, actual.BucketMaxSpanSeconds, int64(ts.BucketSpan)))
+		}
+		return drift
+	}
+
+	// the granularity defaults to seconds
+	configured, granularity := ts.Granularity, actual.Granularity
+	if configured == "" {
+		configured = "seconds"
+	}
+	if granularity == "" && actual.BucketMaxSpanSeconds == 0 {
+		granularity = "seconds"
+	}
+	if granularity != configured {
+		drift = append(drift, fmt.Sprintf("collection %s has granularity %q, configured %q. It can only be raised, with collMod",
+			name, granularity, configured))
+	}
+	return drift
+}
+
+func (m *Migrator) indexDrift(ctx context.Context, collection string, models []mongo.IndexModel) ([]string, error) {
+	cursor, err := m.db.Collection(collection).Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var existing []struct {
+		Name string `bson:"name"`
+		Key  bson.D `bson:"key"`
+	}
+	if err := cursor.All(ctx, &existing); err != nil {
+		return nil, err
+	}
+	keys := map[string]string{}
+	for _, index := range existing {
+		keys[index.Name] = keyString(index.Key)
+	}
+
+	var drift []string
+	for _, model := range models {
+		name, want := *model.Options.Name, keyString(model.Keys.(bson.D))
+		got, ok := keys[name]
+		switch {
+		case !ok:
+			drift = append(drift, fmt.Sprintf("index %s of %s is missing", name, collection))
+		case got != want:
+			drift = append(drift, fmt.Sprintf("index %s of %s has keys %s, configured %s", name, collection, got, want))
+		}
+	}
+	return drift, nil
+}
+
+// keyString formats index keys, so that numbers of different types compare equal
+func keyString(keys bson.D) string {
+	s := ""
+	for i, key := range keys {
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("%s: %v", key.Key, key.Value)
+	}
+	return "{" + s + "}"
+}
@@ -0,0 +1,332 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/erich/pricetracking/config"
+	helper "github.com/erich/pricetracking/helper/mongo"
+)
+
+const (
+	// lockTTL bounds how long a crashed runner blocks the others
+	lockTTL = 10 * time.Minute
+	lockID  = "lock"
+)
+
+// ErrLocked is returned when another runner holds the migration lock
+var ErrLocked = errors.New("migrations are locked by another runner")
+
+// ErrNotEmpty is returned when a revert would drop collections holding documents without being forced
+var ErrNotEmpty = errors.New("the revert drops collections holding documents")
+
+// Migration is a versioned schema change, Down reverts Up. Drops returns the collections Down drops with their
+// documents, they are only reverted while empty unless forced.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database, cfg config.MongoConfig) error
+	Down        func(ctx context.Context, db *mongo.Database, cfg config.MongoConfig) error
+	Drops       func(cfg config.MongoConfig) []string
+}
+
+// applied is the record of an applied migration in the schema_migrations collection
+type applied struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"appliedAt"`
+}
+
+type lock struct {
+	ID        string    `bson:"_id"`
+	Owner     string    `bson:"owner"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// Migrator applies the migrations in version order and records them in the schema_migrations collection
+type Migrator struct {
+	db         *mongo.Database
+	cfg        config.MongoConfig
+	migrations []Migration
+	owner      string
+}
+
+// NewMigrator returns a migrator of the registered migrations on the configured database and collections
+func NewMigrator(client *mongo.Client, cfg *config.Config) *Migrator {
+	return newMigrator(helper.Database(client, cfg), cfg.Mongo, migrations)
+}
+
+func newMigrator(db *mongo.Database, cfg config.MongoConfig, ms []Migration) *Migrator {
+	sorted := append([]Migration(nil), ms...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Version == sorted[i-1].Version {
+			panic(fmt.Sprintf("duplicate migration version %d", sorted[i].Version))
+		}
+	}
+
+	host, _ := os.Hostname()
+	return &Migrator{
+		db:         db,
+		cfg:        cfg,
+		migrations: sorted,
+		owner:      fmt.Sprintf("%s/%d/%s", host, os.Getpid(), primitive.NewObjectID().Hex()),
+	}
+}
+
+// Latest returns the version of the last registered migration
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the version of the last applied migration, 0 if none was applied
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	var last applied
+	err := m.db.Collection(m.cfg.Collections.Migrations).
+		FindOne(ctx, bson.D{}, options.FindOne().SetSort(bson.D{{"_id", -1}})).
+		Decode(&last)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return last.Version, nil
+}
+
+// Pending returns the migrations above the applied version
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, migration := range m.migrations {
+		if migration.Version > version {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies the pending migrations and returns them
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func() error {
+		pending, err := m.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range pending {
+			if err := migration.Up(ctx, m.db, m.cfg); err != nil {
+				return fmt.Errorf("migration %d (%s) failed: %w", migration.Version, migration.Description, err)
+			}
+			record := applied{Version: migration.Version, Description: migration.Description, AppliedAt: time.Now().UTC()}
+			if _, err := m.db.Collection(m.cfg.Collections.Migrations).InsertOne(ctx, record); err != nil {
+				return err
+			}
+			log.Printf("migration %d applied: %s", migration.Version, migration.Description)
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the last steps applied migrations and returns them. A migration dropping collections that hold
+// documents fails with ErrNotEmpty unless force is set.
+func (m *Migrator) Down(ctx context.Context, steps int, force bool) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func() error {
+		version, err := m.Version(ctx)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := m.migrations[i]
+			if migration.Version > version {
+				continue
+			}
+			if !force {
+				if err := m.requireEmpty(ctx, migration); err != nil {
+					return err
+				}
+			}
+			if err := migration.Down(ctx, m.db, m.cfg); err != nil {
+				return fmt.Errorf("migration %d (%s) revert failed: %w", migration.Version, migration.Description, err)
+			}
+			if _, err := m.db.Collection(m.cfg.Collections.Migrations).DeleteOne(ctx, bson.D{{"_id", migration.Version}}); err != nil {
+				return err
+			}
+			log.Printf("migration %d reverted: %s", migration.Version, migration.Description)
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// requireEmpty fails with ErrNotEmpty if a collection dropped by the revert of the migration holds documents
+func (m *Migrator) requireEmpty(ctx context.Context, migration Migration) error {
+	if migration.Drops == nil {
+		return nil
+	}
+	for _, name := range migration.Drops(m.cfg) {
+		n, err := m.db.Collection(name).EstimatedDocumentCount(ctx)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return fmt.Errorf("%w: migration %d (%s) drops %s with %d documents, revert it with --force",
+				ErrNotEmpty, migration.Version, migration.Description, name, n)
+		}
+	}
+	return nil
+}
+
+// withLock runs fn while holding the lock document. The lock is renewed while fn runs and taken over once it
+// expires, so that a crashed runner does not block the migrations forever.
+func (m *Migrator) withLock(ctx context.Context, fn func() error) error {
+	collection := m.db.Collection(m.cfg.Collections.Migrations + "_lock")
+	now := time.Now().UTC()
+	filter := bson.D{{"_id", lockID}, {"$or", bson.A{
+		bson.D{{"expiresAt", bson.D{{"$lt", now}}}},
+		bson.D{{"owner", m.owner}},
+	}}}
+	update := bson.D{{"$set", bson.D{{"owner", m.owner}, {"expiresAt", now.Add(lockTTL)}}}}
+
+	// the upsert fails with a duplicate key if the lock document exists and is held by another runner
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		var held lock
+		if err := collection.FindOne(ctx, bson.D{{"_id", lockID}}).Decode(&held); err == nil {
+			return fmt.Errorf("%w: %s until %s", ErrLocked, held.Owner, held.ExpiresAt.Format(time.RFC3339))
+		}
+		return ErrLocked
+	}
+	if err != nil {
+		return fmt.Errorf("failed to acquire the migration lock: %w", err)
+	}
+
+	renewCtx, stopRenewal := context.WithCancel(ctx)
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		m.renewLock(renewCtx, collection)
+	}()
+	defer func() {
+		stopRenewal()
+		<-renewed
+		// released with a fresh context so that a cancelled run does not keep the lock until it expires
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if _, err := collection.DeleteOne(releaseCtx, bson.D{{"_id", lockID}, {"owner", m.owner}}); err != nil {
+			log.Printf("failed to release the migration lock: %v", err)
+		}
+	}()
+	return fn()
+}
+
+// renewLock extends the expiry of the held lock until ctx is done, so that a migration running longer than lockTTL
+// is not taken over by another runner
+func (m *Migrator) renewLock(ctx context.Context, collection *mongo.Collection) {
+	ticker := time.NewTicker(lockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		update := bson.D{{"$set", bson.D{{"expiresAt", time.Now().UTC().Add(lockTTL)}}}}
+		res, err := collection.UpdateOne(ctx, bson.D{{"_id", lockID}, {"owner", m.owner}}, update)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			log.Printf("failed to renew the migration lock: %v", err)
+		case res.MatchedCount == 0:
+			log.Printf("the migration lock of %s was taken over by another runner", m.owner)
+			return
+		}
+	}
+}
+
+func collectionExists(ctx context.Context, db *mongo.Database, name string) (bool, error) {
+	names, err := db.ListCollectionNames(ctx, bson.D{{"name", name}})
+	if err != nil {
+		return false, err
+	}
+	return len(names) > 0, nil
+}
+
+// createCollection creates the collection unless it exists already
+func createCollection(ctx context.Context, db *mongo.Database, name string) error {
+	if exists, err := collectionExists(ctx, db, name); err != nil || exists {
+		return err
+	}
+	return db.CreateCollection(ctx, name)
+}
+
+// metaField is the field the points store their asset in, the points of an asset are bucketed together.
+// The pipelines and indexes of the price data read the asset from it.
+const metaField = "asset"
+
+// createTimeSeriesCollection creates the time series collection unless it exists already, without a metaField if
+// it is empty. The create command is used directly, as the driver options lack the custom bucketing.
+func createTimeSeriesCollection(ctx context.Context, db *mongo.Database, name string, metaField string, ts config.TimeSeries) error {
+	if exists, err := collectionExists(ctx, db, name); err != nil || exists {
+		return err
+	}
+
+	timeseries := bson.D{{"timeField", "timestamp"}}
+	if metaField != "" {
+		timeseries = append(timeseries, bson.E{"metaField", metaField})
+	}
+	switch {
+	case ts.BucketSpan > 0:
+		span := int64(ts.BucketSpan)
+		timeseries = append(timeseries, bson.E{"bucketMaxSpanSeconds", span}, bson.E{"bucketRoundingSeconds", span})
+	case ts.Granularity != "":
+		timeseries = append(timeseries, bson.E{"granularity", ts.Granularity})
+	}
+	return db.RunCommand(ctx, bson.D{{"create", name}, {"timeseries", timeseries}}).Err()
+}
+
+func dropCollection(ctx context.Context, db *mongo.Database, name string) error {
+	return db.Collection(name).Drop(ctx)
+}
+
+// createIndexes creates the indexes of the collection, an existing index with the same name and keys is kept
+func createIndexes(ctx context.Context, db *mongo.Database, name string, indexes ...mongo.IndexModel) error {
+	_, err := db.Collection(name).Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+// dropIndexes drops the named indexes of the collection, missing indexes are ignored
+func dropIndexes(ctx context.Context, db *mongo.Database, name string, indexNames ...string) error {
+	for _, indexName := range indexNames {
+		_, err := db.Collection(name).Indexes().DropOne(ctx, indexName)
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && (cmdErr.Name == "IndexNotFound" || cmdErr.Name == "NamespaceNotFound") {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package migration
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/erich/pricetracking/config"
+)
+
+// copyBatchSize is the number of documents inserted at once when a collection is copied
+const copyBatchSize = 1000
+
+// rebuildTimeSeriesCollection recreates the time series collection with the options and the indexes, as the
+// metaField and the bucketing of an existing collection cannot be changed. The documents are copied into a backup
+// collection, which is renamed to <name>_rebuild once complete, and copied back into the recreated collection.
+// A failed rebuild is resumed from the complete backup by the next run.
+func rebuildTimeSeriesCollection(ctx context.Context, db *mongo.Database, name string, metaField string, ts config.TimeSeries, indexes ...mongo.IndexModel) error {
+	backup, partial := name+"_rebuild", name+"_rebuild_partial"
+
+	complete, err := collectionExists(ctx, db, backup)
+	if err != nil {
+		return err
+	}
+	if !complete {
+		info, err := collectionOptions(ctx, db, name)
+		if err != nil {
+			return err
+		}
+		if info != nil && len(timeSeriesDifferences(name, info, metaField, ts)) == 0 {
+			return nil
+		}
+		if info == nil {
+			if err := createTimeSeriesCollection(ctx, db, name, metaField, ts); err != nil {
+				return err
+			}
+			return createIndexes(ctx, db, name, indexes...)
+		}
+
+		if err := dropCollection(ctx, db, partial); err != nil {
+			return err
+		}
+		if err := copyDocuments(ctx, db.Collection(name), db.Collection(partial)); err != nil {
+			return err
+		}
+		if err := renameCollection(ctx, db, partial, backup); err != nil {
+			return err
+		}
+	}
+
+	// the collection is either the old one or partially copied back by a failed run
+	if err := dropCollection(ctx, db, name); err != nil {
+		return err
+	}
+	if err := createTimeSeriesCollection(ctx, db, name, metaField, ts); err != nil {
+		return err
+	}
+	if err := copyDocuments(ctx, db.Collection(backup), db.Collection(name)); err != nil {
+		return err
+	}
+	if err := createIndexes(ctx, db, name, indexes...); err != nil {
+		return err
+	}
+	return dropCollection(ctx, db, backup)
+}
+
+// copyDocuments inserts the documents of from into to in batches
+func copyDocuments(ctx context.Context, from *mongo.Collection, to *mongo.Collection) error {
+	cursor, err := from.Find(ctx, bson.D{}, options.Find().SetBatchSize(copyBatchSize))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	batch := make([]interface{}, 0, copyBatchSize)
+	for cursor.Next(ctx) {
+		batch = append(batch, bson.Raw(append([]byte(nil), cursor.Current...)))
+		if len(batch) == copyBatchSize {
+			if _, err := to.InsertMany(ctx, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		_, err = to.InsertMany(ctx, batch)
+	}
+	return err
+}
+
+// renameCollection renames a collection within the database, time series collections cannot be renamed
+func renameCollection(ctx context.Context, db *mongo.Database, from string, to string) error {
+	return db.Client().Database("admin").RunCommand(ctx, bson.D{
+		{"renameCollection", db.Name() + "." + from},
+		{"to", db.Name() + "." + to},
+	}).Err()
+}
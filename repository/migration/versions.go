@@ -0,0 +1,143 @@
+package migration
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/erich/pricetracking/config"
+)
+
+// migrations are the schema changes in version order. Applied migrations must not change, a new version is added
+// instead.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create the price data time series collection",
+		Up: func(ctx context.Context, db *mongo.Database, cfg config.MongoConfig) error {
+			// the configured options are applied by the rebuild of version 5
+			return createTimeSeriesCollection(ctx, db, cfg.Collections.PriceData, "", config.TimeSeries{})
+		},
+		Down: func(ctx context.Context, db *mongo.Database, cfg config.MongoConfig) error {
+			return dropCollection(ctx, db, cfg.Collections.PriceData)
+		},
+		Drops: func(cfg config.MongoConfig) []string {
+			return []string{cfg.Collections.PriceData}
+		},
+	},
+	{
+		Version:     2,
+		Description: "create the collections of the last retrieval, corrections, audit, quarantine, anomalies, alert rules and asset metadata",
+		Up: func(ctx context.Context, db *mongo.Database, cfg config.MongoConfig) error {
+			for _, name := range plainCollections(cfg.Collections) {
+				if err := createCollection(ctx, db, name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(ctx context.Context, db *mongo.Database, cfg config.MongoConfig) error {
+			for _, name := range plainCollections(cfg.Collections) {
+				if err := dropCollection(ctx, db, name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Drops: func(cfg config.MongoConfig) []string {
+			return plainCollections(cfg.Collections)
+		},
+	},
+	{
+		Version:     3,
+		Description: "index the upserts of corrections and anomalies by asset and timestamp",
+		Up: func(ctx context.Context, db *mongo.Database, cfg config.MongoConfig) error {
+			if err := createIndexes(ctx, db, cfg.Collections.Corrections, indexes(cfg)[cfg.Collections.Corrections]...); err != nil {
+				return err
+			}
+			return createIndexes(ctx, db, cfg.Collections.Anomalies, indexes(cfg)[cfg.Collections.Anomalies]...)
+		},
+		Down: func(ctx context.Context, db *mongo.Database, cfg config.MongoConfig) error {
+			if err := dropIndexes(ctx, db, cfg.Collections.Corrections, "asset_timestamp"); err != nil {
+				return err
+			}
+			return dropIndexes(ctx, db, cfg.Collections.Anomalies, "asset_timestamp_rule")
+		},
+	},
+	{
+		Version:     4,
+		Description: "index the price data by asset and timestamp",
+		Up: func(ctx context.Context, db *mongo.Database, cfg config.MongoConfig) error {
+			return createIndexes(ctx, db, cfg.Collections.PriceData, indexes(cfg)[cfg.Collections.PriceData]...)
+		},
+		Down: func(ctx context.Context, db *mongo.Database, cfg config.MongoConfig) error {
+			return dropIndexes(ctx, db, cfg.Collections.PriceData, "asset_timestamp")
+		},
+	},
+	{
+		Version:     5,
+		Description: "rebuild the price data collection with the asset metaField and the configured bucketing",
+		Up: func(ctx context.Context, db *mongo.Database, cfg config.MongoConfig) error {
+			return rebuildTimeSeriesCollection(ctx, db, cfg.Collections.PriceData, metaField, cfg.TimeSeries, indexes(cfg)[cfg.Collections.PriceData]...)
+		},
+		Down: func(ctx context.Context, db *mongo.Database, cfg config.MongoConfig) error {
+			return rebuildTimeSeriesCollection(ctx, db, cfg.Collections.PriceData, "", config.TimeSeries{}, indexes(cfg)[cfg.Collections.PriceData]...)
+		},
+	},
+	{
+		Version:     6,
+		Description: "create the alert outbox collection indexed by the next attempt",
+		Up: func(ctx context.Context, db *mongo.Database, cfg config.MongoConfig) error {
+			if err := createCollection(ctx, db, cfg.Collections.AlertOutbox); err != nil {
+				return err
+			}
+			return createIndexes(ctx, db, cfg.Collections.AlertOutbox, indexes(cfg)[cfg.Collections.AlertOutbox]...)
+		},
+		Down: func(ctx context.Context, db *mongo.Database, cfg config.MongoConfig) error {
+			return dropCollection(ctx, db, cfg.Collections.AlertOutbox)
+		},
+		Drops: func(cfg config.MongoConfig) []string {
+			return []string{cfg.Collections.AlertOutbox}
+		},
+	},
+}
+
+// plainCollections are the collections without options created by version 2, mongo would create them on the first
+// insert as well
+func plainCollections(names config.Collections) []string {
+	return []string{
+		names.LastRetrieval,
+		names.Corrections,
+		names.Audit,
+		names.Quarantine,
+		names.Anomalies,
+		names.AlertRules,
+		names.AssetMetadata,
+	}
+}
+
+// indexes are the indexes created by the migrations by collection, the drift report checks them as well
+func indexes(cfg config.MongoConfig) map[string][]mongo.IndexModel {
+	return map[string][]mongo.IndexModel{
+		cfg.Collections.Corrections: {{
+			Keys:    bson.D{{"asset", 1}, {"timestamp", 1}},
+			Options: options.Index().SetName("asset_timestamp").SetUnique(true),
+		}},
+		cfg.Collections.Anomalies: {{
+			Keys:    bson.D{{"asset", 1}, {"timestamp", 1}, {"rule", 1}},
+			Options: options.Index().SetName("asset_timestamp_rule").SetUnique(true),
+		}},
+		// the series of an asset are read in time order, asset is the metaField as well
+		cfg.Collections.PriceData: {{
+			Keys:    bson.D{{"asset", 1}, {"timestamp", 1}},
+			Options: options.Index().SetName("asset_timestamp"),
+		}},
+		// the dispatchers claim the delivery due first
+		cfg.Collections.AlertOutbox: {{
+			Keys:    bson.D{{"nextAttempt", 1}},
+			Options: options.Index().SetName("nextAttempt"),
+		}},
+	}
+}
@@ -0,0 +1,106 @@
+package pricedata
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/erich/pricetracking/config"
+	"github.com/erich/pricetracking/helper/app_errors"
+	helper "github.com/erich/pricetracking/helper/mongo"
+	"github.com/erich/pricetracking/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type alertRuleMongoRepo struct {
+	collection *mongo.Collection
+}
+
+type AlertRuleMongoRepo interface {
+	Create(ctx context.Context, rule model.AlertRule) (model.AlertRule, error)
+	// List returns the rules of the asset, or of all assets if asset is empty, ordered by creation
+	List(ctx context.Context, asset string) ([]model.AlertRule, error)
+	Delete(ctx context.Context, id string) error
+	// SetFiring changes the firing state of the rule and reports whether this call changed it,
+	// so that concurrent evaluations deliver an alert only once
+	SetFiring(ctx context.Context, id string, firing bool, at time.Time) (bool, error)
+}
+
+func NewAlertRuleMongoRepo(client *mongo.Client, cfg *config.Config) (AlertRuleMongoRepo, error) {
+	collection := helper.Collection(client, cfg, cfg.Mongo.Collections.AlertRules)
+
+	return &alertRuleMongoRepo{
+		collection: collection,
+	}, nil
+}
+
+// Create implements AlertRuleMongoRepo.
+func (a *alertRuleMongoRepo) Create(ctx context.Context, rule model.AlertRule) (model.AlertRule, error) {
+	res, err := a.collection.InsertOne(ctx, rule)
+	if err != nil {
+		return model.AlertRule{}, fmt.Errorf("failed to create alert rule: %w", err)
+	}
+	rule.ID = res.InsertedID.(primitive.ObjectID).Hex()
+	return rule, nil
+}
+
+// List implements AlertRuleMongoRepo.
+func (a *alertRuleMongoRepo) List(ctx context.Context, asset string) ([]model.AlertRule, error) {
+	filter := bson.D{}
+	if asset != "" {
+		filter = append(filter, bson.E{"asset", asset})
+	}
+
+	cursor, err := a.collection.Find(ctx, filter, options.Find().SetSort(bson.D{{"_id", 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rules []model.AlertRule
+	if err := cursor.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Delete implements AlertRuleMongoRepo.
+func (a *alertRuleMongoRepo) Delete(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("%w: invalid id %q", app_errors.ErrInvalidRequest, id)
+	}
+
+	res, err := a.collection.DeleteOne(ctx, bson.D{{"_id", objectID}})
+	if err != nil {
+		return fmt.Errorf("failed to delete alert rule: %w", err)
+	}
+	if res.DeletedCount == 0 {
+		return fmt.Errorf("%w: alert rule %s", app_errors.ErrNotFound, id)
+	}
+	return nil
+}
+
+// SetFiring implements AlertRuleMongoRepo.
+func (a *alertRuleMongoRepo) SetFiring(ctx context.Context, id string, firing bool, at time.Time) (bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, fmt.Errorf("%w: invalid id %q", app_errors.ErrInvalidRequest, id)
+	}
+
+	set := bson.D{{"firing", firing}}
+	if firing {
+		set = append(set, bson.E{"firedAt", at})
+	}
+	res, err := a.collection.UpdateOne(ctx,
+		bson.D{{"_id", objectID}, {"firing", bson.D{{"$ne", firing}}}},
+		bson.D{{"$set", set}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to update alert rule: %w", err)
+	}
+	return res.ModifiedCount == 1, nil
+}
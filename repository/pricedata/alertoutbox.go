@@ -0,0 +1,100 @@
+package pricedata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/erich/pricetracking/config"
+	helper "github.com/erich/pricetracking/helper/mongo"
+	"github.com/erich/pricetracking/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type alertOutboxMongoRepo struct {
+	collection *mongo.Collection
+}
+
+// AlertOutboxMongoRepo keeps the webhook deliveries of the firing alerts until they are delivered or given up
+type AlertOutboxMongoRepo interface {
+	Enqueue(ctx context.Context, deliveries []model.AlertDelivery) error
+	// Claim returns the delivery due first at now and defers its next attempt by lease, so that concurrent
+	// dispatchers do not claim it as well. It reports false if no delivery is due.
+	Claim(ctx context.Context, now time.Time, lease time.Duration) (model.AlertDelivery, bool, error)
+	// Retry records a failed attempt and schedules the next one
+	Retry(ctx context.Context, id string, next time.Time, lastError string) error
+	Delete(ctx context.Context, id string) error
+}
+
+func NewAlertOutboxMongoRepo(client *mongo.Client, cfg *config.Config) (AlertOutboxMongoRepo, error) {
+	collection := helper.Collection(client, cfg, cfg.Mongo.Collections.AlertOutbox)
+
+	return &alertOutboxMongoRepo{
+		collection: collection,
+	}, nil
+}
+
+// Enqueue implements AlertOutboxMongoRepo.
+func (a *alertOutboxMongoRepo) Enqueue(ctx context.Context, deliveries []model.AlertDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(deliveries))
+	for i, delivery := range deliveries {
+		delivery.ID = ""
+		docs[i] = delivery
+	}
+	if _, err := a.collection.InsertMany(ctx, docs); err != nil {
+		return fmt.Errorf("failed to enqueue alert deliveries: %w", err)
+	}
+	return nil
+}
+
+// Claim implements AlertOutboxMongoRepo.
+func (a *alertOutboxMongoRepo) Claim(ctx context.Context, now time.Time, lease time.Duration) (model.AlertDelivery, bool, error) {
+	var delivery model.AlertDelivery
+	err := a.collection.FindOneAndUpdate(ctx,
+		bson.D{{"nextAttempt", bson.D{{"$lte", now}}}},
+		bson.D{{"$set", bson.D{{"nextAttempt", now.Add(lease)}}}},
+		options.FindOneAndUpdate().SetSort(bson.D{{"nextAttempt", 1}}),
+	).Decode(&delivery)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return model.AlertDelivery{}, false, nil
+	}
+	if err != nil {
+		return model.AlertDelivery{}, false, fmt.Errorf("failed to claim alert delivery: %w", err)
+	}
+	return delivery, true, nil
+}
+
+// Retry implements AlertOutboxMongoRepo.
+func (a *alertOutboxMongoRepo) Retry(ctx context.Context, id string, next time.Time, lastError string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	_, err = a.collection.UpdateOne(ctx, bson.D{{"_id", objectID}}, bson.D{
+		{"$set", bson.D{{"nextAttempt", next}, {"lastError", lastError}}},
+		{"$inc", bson.D{{"attempts", 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reschedule alert delivery: %w", err)
+	}
+	return nil
+}
+
+// Delete implements AlertOutboxMongoRepo.
+func (a *alertOutboxMongoRepo) Delete(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	if _, err := a.collection.DeleteOne(ctx, bson.D{{"_id", objectID}}); err != nil {
+		return fmt.Errorf("failed to delete alert delivery: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package pricedata
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/erich/pricetracking/config"
+	helper "github.com/erich/pricetracking/helper/mongo"
+	"github.com/erich/pricetracking/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type anomalyMongoRepo struct {
+	collection *mongo.Collection
+}
+
+// AnomalyMongoRepo stores the price points flagged by the anomaly detection
+type AnomalyMongoRepo interface {
+	// Save stores the anomalies, a point flagged again by the same rule is replaced
+	Save(ctx context.Context, anomalies []model.Anomaly) error
+	// List returns the anomalies of the filter in time order
+	List(ctx context.Context, filter model.AnomalyFilter) ([]model.Anomaly, error)
+}
+
+func NewAnomalyMongoRepo(client *mongo.Client, cfg *config.Config) (AnomalyMongoRepo, error) {
+	collection := helper.Collection(client, cfg, cfg.Mongo.Collections.Anomalies)
+
+	return &anomalyMongoRepo{
+		collection: collection,
+	}, nil
+}
+
+// Save implements AnomalyMongoRepo.
+func (a *anomalyMongoRepo) Save(ctx context.Context, anomalies []model.Anomaly) error {
+	models := make([]mongo.WriteModel, len(anomalies))
+	for i, anomaly := range anomalies {
+		models[i] = mongo.NewReplaceOneModel().
+			SetFilter(bson.D{{"asset", anomaly.Asset}, {"timestamp", anomaly.Time}, {"rule", anomaly.Rule}}).
+			SetReplacement(anomaly).
+			SetUpsert(true)
+	}
+
+	if _, err := a.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("failed to save anomalies: %w", err)
+	}
+	return nil
+}
+
+// List implements AnomalyMongoRepo.
+func (a *anomalyMongoRepo) List(ctx context.Context, filter model.AnomalyFilter) ([]model.Anomaly, error) {
+	query := bson.D{{"asset", filter.Asset}}
+	timeRange := bson.D{}
+	if !filter.StartTime.IsZero() {
+		timeRange = append(timeRange, bson.E{"$gte", filter.StartTime})
+	}
+	if !filter.EndTime.IsZero() {
+		timeRange = append(timeRange, bson.E{"$lt", filter.EndTime})
+	}
+	if len(timeRange) > 0 {
+		query = append(query, bson.E{"timestamp", timeRange})
+	}
+	if filter.Rule != "" {
+		query = append(query, bson.E{"rule", filter.Rule})
+	}
+
+	cursor, err := a.collection.Find(ctx, query, options.Find().SetSort(bson.D{{"timestamp", 1}, {"rule", 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var anomalies []model.Anomaly
+	if err := cursor.All(ctx, &anomalies); err != nil {
+		return nil, err
+	}
+	return anomalies, nil
+}
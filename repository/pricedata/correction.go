@@ -0,0 +1,100 @@
+package pricedata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/erich/pricetracking/config"
+	helper "github.com/erich/pricetracking/helper/mongo"
+	"github.com/erich/pricetracking/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type correctionMongoRepo struct {
+	correctionCollection *mongo.Collection
+	auditCollection      *mongo.Collection
+}
+
+// CorrectionMongoRepo stores the manual corrections of price points and their audit trail
+type CorrectionMongoRepo interface {
+	// Get returns the correction of the price point, or nil if it is not corrected
+	Get(ctx context.Context, asset string, t time.Time) (*model.Correction, error)
+	// Save records the audit entry and then creates or replaces the correction of the price point, so that no
+	// correction is live without its audit entry. The previous value of the entry is taken from the replaced
+	// correction, or pointValue if the point was not corrected, and the entry is returned with it.
+	Save(ctx context.Context, correction model.Correction, audit model.AuditEntry, pointValue float64) (model.AuditEntry, error)
+}
+
+func NewCorrectionMongoRepo(client *mongo.Client, cfg *config.Config) (CorrectionMongoRepo, error) {
+	correctionCollection := helper.Collection(client, cfg, cfg.Mongo.Collections.Corrections)
+	auditCollection := helper.Collection(client, cfg, cfg.Mongo.Collections.Audit)
+
+	return &correctionMongoRepo{
+		correctionCollection: correctionCollection,
+		auditCollection:      auditCollection,
+	}, nil
+}
+
+// Get implements CorrectionMongoRepo.
+func (c *correctionMongoRepo) Get(ctx context.Context, asset string, t time.Time) (*model.Correction, error) {
+	var correction model.Correction
+	err := c.correctionCollection.FindOne(ctx, bson.D{{"asset", asset}, {"timestamp", t}}).Decode(&correction)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &correction, nil
+}
+
+// Save implements CorrectionMongoRepo.
+func (c *correctionMongoRepo) Save(ctx context.Context, correction model.Correction, audit model.AuditEntry, pointValue float64) (model.AuditEntry, error) {
+	res, err := c.auditCollection.InsertOne(ctx, audit)
+	if err != nil {
+		return model.AuditEntry{}, fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	auditFilter := bson.D{{"_id", res.InsertedID}}
+
+	filter := bson.D{{"asset", correction.Asset}, {"timestamp", correction.Time}}
+	opts := options.FindOneAndReplace().SetUpsert(true).SetReturnDocument(options.Before)
+	var replaced model.Correction
+	err = c.correctionCollection.FindOneAndReplace(ctx, filter, correction, opts).Decode(&replaced)
+	previous := &replaced
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		previous, err = nil, nil
+	}
+	if err != nil {
+		// the correction is not applied, its audit entry is removed with a fresh context as ctx may be cancelled
+		removeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if _, removeErr := c.auditCollection.DeleteOne(removeCtx, auditFilter); removeErr != nil {
+			log.Printf("failed to remove the audit entry of the failed correction of %s at %s: %v", correction.Asset, correction.Time, removeErr)
+		}
+		return model.AuditEntry{}, fmt.Errorf("failed to save correction: %w", err)
+	}
+
+	// a concurrent correction of the point was applied between the read of the caller and the replace
+	previousValue := model.CorrectedValue(pointValue, previous)
+	if !sameValue(previousValue, audit.PreviousValue) {
+		audit.PreviousValue = previousValue
+		update := bson.D{{"$set", bson.D{{"previousValue", previousValue}}}}
+		if _, err := c.auditCollection.UpdateOne(ctx, auditFilter, update); err != nil {
+			return model.AuditEntry{}, fmt.Errorf("correction saved, but failed to record its previous value: %w", err)
+		}
+	}
+	return audit, nil
+}
+
+// sameValue reports whether both values are deleted or equal
+func sameValue(a *float64, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
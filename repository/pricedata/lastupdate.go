@@ -6,6 +6,7 @@ import (
 	"log"
 	"time"
 
+	"github.com/erich/pricetracking/config"
 	helper "github.com/erich/pricetracking/helper/mongo"
 	"github.com/erich/pricetracking/model"
 	"go.mongodb.org/mongo-driver/bson"
@@ -13,10 +14,6 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-const (
-	COLLECTION_NAME_LASTRETRIVAL = "lastretrival"
-)
-
 // LastUpdateRepo handles operations related to the last update entry
 type lastUpdateRepo struct {
 	collection *mongo.Collection
@@ -27,11 +24,8 @@ type LastUpdateMongoRepo interface {
 	Get(ctx context.Context) (time.Time, error)
 }
 
-func NewLastRetrivalMongoRepo(client *mongo.Client) (LastUpdateMongoRepo, error) {
-	collection, err := helper.CreateCollection(client, COLLECTION_NAME_LASTRETRIVAL)
-	if err != nil {
-		return nil, err
-	}
+func NewLastRetrivalMongoRepo(client *mongo.Client, cfg *config.Config) (LastUpdateMongoRepo, error) {
+	collection := helper.Collection(client, cfg, cfg.Mongo.Collections.LastRetrieval)
 
 	return &lastUpdateRepo{
 		collection: collection,
@@ -0,0 +1,53 @@
+package pricedata
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/erich/pricetracking/config"
+	helper "github.com/erich/pricetracking/helper/mongo"
+	"github.com/erich/pricetracking/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type assetMetadataMongoRepo struct {
+	collection *mongo.Collection
+}
+
+type AssetMetadataMongoRepo interface {
+	// Get returns the metadata of the asset, or nil if none is stored
+	Get(ctx context.Context, asset string) (*model.AssetMetadata, error)
+	Save(ctx context.Context, metadata model.AssetMetadata) error
+}
+
+func NewAssetMetadataMongoRepo(client *mongo.Client, cfg *config.Config) (AssetMetadataMongoRepo, error) {
+	collection := helper.Collection(client, cfg, cfg.Mongo.Collections.AssetMetadata)
+
+	return &assetMetadataMongoRepo{
+		collection: collection,
+	}, nil
+}
+
+// Get implements AssetMetadataMongoRepo.
+func (a *assetMetadataMongoRepo) Get(ctx context.Context, asset string) (*model.AssetMetadata, error) {
+	var metadata model.AssetMetadata
+	err := a.collection.FindOne(ctx, bson.D{{"_id", asset}}).Decode(&metadata)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// Save implements AssetMetadataMongoRepo.
+func (a *assetMetadataMongoRepo) Save(ctx context.Context, metadata model.AssetMetadata) error {
+	_, err := a.collection.ReplaceOne(ctx, bson.D{{"_id", metadata.Asset}}, metadata, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to save asset metadata: %w", err)
+	}
+	return nil
+}
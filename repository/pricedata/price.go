@@ -2,18 +2,17 @@ package pricedata
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"math"
 	"time"
 
+	"github.com/erich/pricetracking/config"
 	helper "github.com/erich/pricetracking/helper/mongo"
 	"github.com/erich/pricetracking/model"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-)
-
-const (
-	DB_NAME         = "zeonology"
-	COLLECTION_NAME = "priceData"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Define a struct that matches the aggregation output
@@ -24,26 +23,75 @@ type AggregationResult struct {
 	AggValue float64 `bson:"aggValue"`
 }
 
+// summaryPoint is a price point of the summary aggregation, price is compared before timestamp by $min and $max
+type summaryPoint struct {
+	Price     float64   `bson:"price"`
+	Timestamp time.Time `bson:"timestamp"`
+}
+
+type summaryResult struct {
+	Count  int64        `bson:"count"`
+	Min    summaryPoint `bson:"min"`
+	Max    summaryPoint `bson:"max"`
+	Mean   float64      `bson:"mean"`
+	StdDev float64      `bson:"stdDev"`
+	First  summaryPoint `bson:"first"`
+	Last   summaryPoint `bson:"last"`
+}
+
 type priceDataMongoRepo struct {
 	mongoClient *mongo.Client
 
 	priceDataCollection *mongo.Collection
+	// collections joined by the points pipeline
+	anomaliesCollection   string
+	correctionsCollection string
+	// points stored before the asset field was introduced belong to the default asset
+	defaultAsset string
+}
+
+// buildInfo is the version of the server as returned by the buildInfo command
+type buildInfo struct {
+	Version      string  `bson:"version"`
+	VersionArray []int32 `bson:"versionArray"`
 }
 
+// replacedEntry is a price point inserted by Replace, its id tells it apart from the older points
+type replacedEntry struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	model.Entry `bson:",inline"`
+}
+
+// minReplaceVersion is the first major version of MongoDB which deletes from time series collections with filters
+// on other fields than the metaField
+const minReplaceVersion = 7
+
 type PriceDataMongoRepo interface {
 	Create(ctx context.Context, pg []model.Entry) error
 	Find(ctx context.Context, query model.Query) ([]model.Entry, error)
+	// IterateRaw calls fn for every stored price point of the query asset in [start, end) in time order
+	IterateRaw(ctx context.Context, query model.Query, fn func(model.Entry) error) error
+	// FindPoint returns the uncorrected price point of the asset at t, or nil if it does not exist
+	FindPoint(ctx context.Context, asset string, t time.Time) (*model.Entry, error)
+	// FindTimes returns which of the given timestamps are already stored for the asset
+	FindTimes(ctx context.Context, asset string, times []time.Time) ([]time.Time, error)
+	// Replace stores the price points of the asset and then removes the older points at their timestamps,
+	// so that a failed call keeps the stored points. It needs MongoDB 7.0 to delete from the time series collection,
+	// which CheckReplaceSupported checks at startup.
+	Replace(ctx context.Context, asset string, entries []model.Entry) error
+	// Summarize computes the statistics of the price points of the query asset in [start, end) in a single aggregation
+	Summarize(ctx context.Context, query model.Query) (model.Summary, error)
 }
 
-func NewPriceDataMongoRepo(client *mongo.Client) (PriceDataMongoRepo, error) {
-	collection, err := helper.CreateTimeSeriesCollection(client, COLLECTION_NAME)
-	if err != nil {
-		return nil, err
-	}
+func NewPriceDataMongoRepo(client *mongo.Client, cfg *config.Config) (PriceDataMongoRepo, error) {
+	collection := helper.Collection(client, cfg, cfg.Mongo.Collections.PriceData)
 
 	return &priceDataMongoRepo{
-		mongoClient:         client,
-		priceDataCollection: collection,
+		mongoClient:           client,
+		priceDataCollection:   collection,
+		anomaliesCollection:   cfg.Mongo.Collections.Anomalies,
+		correctionsCollection: cfg.Mongo.Collections.Corrections,
+		defaultAsset:          cfg.AssetClient.Asset,
 	}, nil
 }
 
@@ -63,33 +111,10 @@ func (p *priceDataMongoRepo) Create(ctx context.Context, pg []model.Entry) error
 
 // FindByEventType implements PhotographerMongoRepo.
 func (p *priceDataMongoRepo) Find(ctx context.Context, query model.Query) ([]model.Entry, error) {
-
-	// Aggregation pipeline
-	pipeline := mongo.Pipeline{
-		// Match documents within the specified time range
-		{{"$match", bson.D{
-			{"timestamp", bson.D{
-				{"$gte", query.StartTime},
-				{"$lt", query.EndTime},
-			}},
-		}}},
-		// Group data into 1-minute intervals and compute the average value
-		{{"$group", bson.D{
-			{"_id", bson.D{
-				{"interval", bson.M{"$dateTrunc": bson.M{
-					"date":    "$timestamp",
-					"unit":    query.WindowUnit,
-					"binSize": query.WindowInterval,
-				}}},
-			}},
-			{"aggValue", bson.M{"$" + string(query.Aggregation): "$price"}},
-		}}},
-	}
-
 	// Execute the aggregation query
-	cursor, err := p.priceDataCollection.Aggregate(ctx, pipeline)
+	cursor, err := p.priceDataCollection.Aggregate(ctx, p.aggregationPipeline(query))
 	if err != nil {
-		log.Fatalf("Failed to aggregate data: %v", err)
+		return nil, fmt.Errorf("failed to aggregate data: %w", err)
 	}
 	defer cursor.Close(ctx)
 
@@ -101,11 +126,246 @@ func (p *priceDataMongoRepo) Find(ctx context.Context, query model.Query) ([]mod
 	// Convert aggregation results to model.Entry
 	entries := make([]model.Entry, len(results))
 	for i, result := range results {
-		entries[i] = model.Entry{
-			Time:  result.ID.Interval,
-			Value: result.AggValue,
-		}
+		entries[i] = result.toEntry()
 	}
 
 	return entries, nil
 }
+
+// IterateRaw implements PriceDataMongoRepo.
+func (p *priceDataMongoRepo) IterateRaw(ctx context.Context, query model.Query, fn func(model.Entry) error) error {
+	pipeline := append(p.pointsPipeline(query), bson.D{{"$sort", bson.D{{"timestamp", 1}}}})
+	cursor, err := p.priceDataCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return fmt.Errorf("failed to find data: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var entry model.Entry
+		if err := cursor.Decode(&entry); err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// FindPoint implements PriceDataMongoRepo.
+func (p *priceDataMongoRepo) FindPoint(ctx context.Context, asset string, t time.Time) (*model.Entry, error) {
+	var entry model.Entry
+	err := p.priceDataCollection.FindOne(ctx, bson.D{{"asset", p.assetFilter(asset)}, {"timestamp", t}}).Decode(&entry)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// FindTimes implements PriceDataMongoRepo.
+func (p *priceDataMongoRepo) FindTimes(ctx context.Context, asset string, times []time.Time) ([]time.Time, error) {
+	filter := bson.D{
+		{"asset", p.assetFilter(asset)},
+		{"timestamp", bson.D{{"$in", times}}},
+	}
+	cursor, err := p.priceDataCollection.Find(ctx, filter, options.Find().SetProjection(bson.D{{"timestamp", 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find data: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var entries []model.Entry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	found := make([]time.Time, len(entries))
+	for i, entry := range entries {
+		found[i] = entry.Time
+	}
+	return found, nil
+}
+
+// Replace implements PriceDataMongoRepo.
+func (p *priceDataMongoRepo) Replace(ctx context.Context, asset string, entries []model.Entry) error {
+	docs := make([]interface{}, len(entries))
+	ids := make(bson.A, len(entries))
+	times := make(bson.A, len(entries))
+	for i, entry := range entries {
+		id := primitive.NewObjectID()
+		docs[i] = replacedEntry{ID: id, Entry: entry}
+		ids[i] = id
+		times[i] = entry.Time
+	}
+	if _, err := p.priceDataCollection.InsertMany(ctx, docs); err != nil {
+		return err
+	}
+
+	// until the older points are deleted, both are stored and a retry of the import removes them
+	filter := bson.D{
+		{"asset", p.assetFilter(asset)},
+		{"timestamp", bson.D{{"$in", times}}},
+		{"_id", bson.D{{"$nin", ids}}},
+	}
+	if _, err := p.priceDataCollection.DeleteMany(ctx, filter); err != nil {
+		return fmt.Errorf("failed to delete the replaced data: %w", err)
+	}
+	return nil
+}
+
+// CheckReplaceSupported fails unless the server deletes from time series collections by timestamp, as Replace does
+func CheckReplaceSupported(ctx context.Context, client *mongo.Client) error {
+	var info buildInfo
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{"buildInfo", 1}}).Decode(&info); err != nil {
+		return fmt.Errorf("failed to read the server version: %w", err)
+	}
+	if len(info.VersionArray) == 0 || info.VersionArray[0] < minReplaceVersion {
+		return fmt.Errorf("overwriting stored price points needs MongoDB %d.0 or later, the server runs %s",
+			minReplaceVersion, info.Version)
+	}
+	return nil
+}
+
+// Summarize implements PriceDataMongoRepo.
+func (p *priceDataMongoRepo) Summarize(ctx context.Context, query model.Query) (model.Summary, error) {
+	point := bson.D{{"price", "$price"}, {"timestamp", "$timestamp"}}
+	pipeline := append(p.pointsPipeline(query),
+		bson.D{{"$sort", bson.D{{"timestamp", 1}}}},
+		bson.D{{"$group", bson.D{
+			{"_id", nil},
+			{"count", bson.D{{"$sum", 1}}},
+			{"min", bson.D{{"$min", point}}},
+			{"max", bson.D{{"$max", point}}},
+			{"mean", bson.D{{"$avg", "$price"}}},
+			{"stdDev", bson.D{{"$stdDevPop", "$price"}}},
+			{"first", bson.D{{"$first", point}}},
+			{"last", bson.D{{"$last", point}}},
+		}}},
+	)
+	cursor, err := p.priceDataCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return model.Summary{}, fmt.Errorf("failed to aggregate data: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	if !cursor.Next(ctx) {
+		return model.Summary{}, cursor.Err()
+	}
+	var result summaryResult
+	if err := cursor.Decode(&result); err != nil {
+		return model.Summary{}, err
+	}
+	return result.toSummary(), nil
+}
+
+func (r summaryResult) toSummary() model.Summary {
+	summary := model.Summary{
+		Count:  r.Count,
+		Min:    r.Min.toEntry(),
+		Max:    r.Max.toEntry(),
+		Mean:   r.Mean,
+		StdDev: r.StdDev,
+		First:  r.First.toEntry(),
+		Last:   r.Last.toEntry(),
+		Change: r.Last.Price - r.First.Price,
+	}
+	if r.First.Price != 0 {
+		summary.ChangePercent = summary.Change / math.Abs(r.First.Price) * 100
+	}
+	return summary
+}
+
+func (s summaryPoint) toEntry() model.Entry {
+	return model.Entry{
+		Time:  s.Timestamp,
+		Value: s.Price,
+	}
+}
+
+func (r AggregationResult) toEntry() model.Entry {
+	return model.Entry{
+		Time:  r.ID.Interval,
+		Value: r.AggValue,
+	}
+}
+
+// assetFilter matches the points of the asset, including the untagged points of the default asset
+func (p *priceDataMongoRepo) assetFilter(asset string) interface{} {
+	if asset == p.defaultAsset {
+		return bson.D{{"$in", bson.A{asset, nil}}}
+	}
+	return asset
+}
+
+// matchFilter matches the points of the query asset within the query time range
+func (p *priceDataMongoRepo) matchFilter(query model.Query) bson.D {
+	return bson.D{
+		{"asset", p.assetFilter(query.Asset)},
+		{"timestamp", bson.D{
+			{"$gte", query.StartTime},
+			{"$lt", query.EndTime},
+		}},
+	}
+}
+
+// pointsPipeline matches the price points of the query, with the manual corrections applied unless the query is uncorrected
+// and without the flagged anomalies if the query excludes them
+func (p *priceDataMongoRepo) pointsPipeline(query model.Query) mongo.Pipeline {
+	pipeline := mongo.Pipeline{
+		// Match documents of the asset within the specified time range
+		{{"$match", p.matchFilter(query)}},
+	}
+	if query.ExcludeAnomalies {
+		pipeline = append(pipeline,
+			bson.D{{"$lookup", bson.D{
+				{"from", p.anomaliesCollection},
+				{"localField", "timestamp"},
+				{"foreignField", "timestamp"},
+				{"pipeline", bson.A{bson.D{{"$match", bson.D{{"asset", query.Asset}}}}}},
+				{"as", "anomalies"},
+			}}},
+			bson.D{{"$match", bson.D{{"anomalies", bson.D{{"$size", 0}}}}}},
+			bson.D{{"$unset", "anomalies"}},
+		)
+	}
+	if query.Uncorrected {
+		return pipeline
+	}
+
+	return append(pipeline,
+		bson.D{{"$lookup", bson.D{
+			{"from", p.correctionsCollection},
+			{"localField", "timestamp"},
+			{"foreignField", "timestamp"},
+			{"pipeline", bson.A{bson.D{{"$match", bson.D{{"asset", query.Asset}}}}}},
+			{"as", "correction"},
+		}}},
+		bson.D{{"$set", bson.D{{"correction", bson.D{{"$first", "$correction"}}}}}},
+		// drop tombstoned points
+		bson.D{{"$match", bson.D{{"correction.deleted", bson.D{{"$ne", true}}}}}},
+		bson.D{{"$set", bson.D{{"price", bson.D{{"$ifNull", bson.A{"$correction.value", "$price"}}}}}}},
+		bson.D{{"$unset", "correction"}},
+	)
+}
+
+// aggregationPipeline groups the price points of the query into windows, sorted by window start
+func (p *priceDataMongoRepo) aggregationPipeline(query model.Query) mongo.Pipeline {
+	return append(p.pointsPipeline(query),
+		// Group data into intervals and compute the aggregated value
+		bson.D{{"$group", bson.D{
+			{"_id", bson.D{
+				{"interval", bson.M{"$dateTrunc": bson.M{
+					"date":    "$timestamp",
+					"unit":    query.WindowUnit,
+					"binSize": query.WindowInterval,
+				}}},
+			}},
+			{"aggValue", bson.M{"$" + string(query.Aggregation): "$price"}},
+		}}},
+		bson.D{{"$sort", bson.D{{"_id.interval", 1}}}},
+	)
+}
@@ -0,0 +1,142 @@
+package pricedata
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/erich/pricetracking/config"
+	"github.com/erich/pricetracking/helper/app_errors"
+	helper "github.com/erich/pricetracking/helper/mongo"
+	"github.com/erich/pricetracking/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type quarantineMongoRepo struct {
+	collection *mongo.Collection
+}
+
+// QuarantineMongoRepo stores the loaded price points which failed validation, until they are reviewed
+type QuarantineMongoRepo interface {
+	Create(ctx context.Context, entries []model.QuarantinedEntry) error
+	// List returns a page of quarantined points ordered by id
+	List(ctx context.Context, filter model.QuarantineFilter) ([]model.QuarantinedEntry, error)
+	// Get returns the quarantined points with the given ids
+	Get(ctx context.Context, ids []string) ([]model.QuarantinedEntry, error)
+	// MarkReleasing flags the quarantined points as being stored by a release
+	MarkReleasing(ctx context.Context, ids []string) error
+	Delete(ctx context.Context, ids []string) error
+}
+
+func NewQuarantineMongoRepo(client *mongo.Client, cfg *config.Config) (QuarantineMongoRepo, error) {
+	collection := helper.Collection(client, cfg, cfg.Mongo.Collections.Quarantine)
+
+	return &quarantineMongoRepo{
+		collection: collection,
+	}, nil
+}
+
+// Create implements QuarantineMongoRepo.
+func (q *quarantineMongoRepo) Create(ctx context.Context, entries []model.QuarantinedEntry) error {
+	docs := make([]interface{}, len(entries))
+	for i, v := range entries {
+		docs[i] = v
+	}
+
+	if _, err := q.collection.InsertMany(ctx, docs); err != nil {
+		return fmt.Errorf("failed to quarantine price points: %w", err)
+	}
+	return nil
+}
+
+// List implements QuarantineMongoRepo.
+func (q *quarantineMongoRepo) List(ctx context.Context, filter model.QuarantineFilter) ([]model.QuarantinedEntry, error) {
+	query := bson.D{}
+	if filter.Asset != "" {
+		query = append(query, bson.E{"asset", filter.Asset})
+	}
+	if filter.Rule != "" {
+		query = append(query, bson.E{"rule", filter.Rule})
+	}
+	if filter.PageToken != "" {
+		last, err := primitive.ObjectIDFromHex(filter.PageToken)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid page token", app_errors.ErrInvalidRequest)
+		}
+		query = append(query, bson.E{"_id", bson.D{{"$gt", last}}})
+	}
+
+	opts := options.Find().SetSort(bson.D{{"_id", 1}}).SetLimit(int64(filter.PageSize))
+	cursor, err := q.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var entries []model.QuarantinedEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Get implements QuarantineMongoRepo.
+func (q *quarantineMongoRepo) Get(ctx context.Context, ids []string) ([]model.QuarantinedEntry, error) {
+	objectIDs, err := toObjectIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := q.collection.Find(ctx, bson.D{{"_id", bson.D{{"$in", objectIDs}}}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var entries []model.QuarantinedEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// MarkReleasing implements QuarantineMongoRepo.
+func (q *quarantineMongoRepo) MarkReleasing(ctx context.Context, ids []string) error {
+	objectIDs, err := toObjectIDs(ids)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.D{{"_id", bson.D{{"$in", objectIDs}}}}
+	if _, err := q.collection.UpdateMany(ctx, filter, bson.D{{"$set", bson.D{{"releasing", true}}}}); err != nil {
+		return fmt.Errorf("failed to mark quarantined price points: %w", err)
+	}
+	return nil
+}
+
+// Delete implements QuarantineMongoRepo.
+func (q *quarantineMongoRepo) Delete(ctx context.Context, ids []string) error {
+	objectIDs, err := toObjectIDs(ids)
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.collection.DeleteMany(ctx, bson.D{{"_id", bson.D{{"$in", objectIDs}}}}); err != nil {
+		return fmt.Errorf("failed to delete quarantined price points: %w", err)
+	}
+	return nil
+}
+
+func toObjectIDs(ids []string) ([]primitive.ObjectID, error) {
+	objectIDs := make([]primitive.ObjectID, len(ids))
+	for i, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid id %q", app_errors.ErrInvalidRequest, id)
+		}
+		objectIDs[i] = objectID
+	}
+	return objectIDs, nil
+}